@@ -0,0 +1,562 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/sqlite"
+	"github.com/whosonfirst/go-foursquare-places/whosonfirst"
+)
+
+// FORMAT_JSON is the format for JSON-encoded responses.
+const FORMAT_JSON string = "json"
+
+// FORMAT_GEOJSON is the format for GeoJSON-encoded responses.
+const FORMAT_GEOJSON string = "geojson"
+
+// FORMAT_NDJSON is the format for newline-delimited JSON responses which are streamed as results are read.
+const FORMAT_NDJSON string = "ndjson"
+
+// FORMAT_SPR is the format for JSON-encoded Who's On First Standard Places Result (SPR) responses.
+const FORMAT_SPR string = "spr"
+
+// DEFAULT_MAX_LIMIT is the default maximum number of results which may be requested in a single response.
+const DEFAULT_MAX_LIMIT int = 1000
+
+// DEFAULT_MAX_RADIUS is the default maximum radius, in metres, for "nearby" queries.
+const DEFAULT_MAX_RADIUS float64 = 50000.0
+
+// PlacesHandlerOptions defines configuration options for the handlers which query a `sqlite.Database` of places.
+type PlacesHandlerOptions struct {
+	// Database is the `sqlite.Database` instance used to query places.
+	Database *sqlite.Database
+	// DefaultLimit is the number of results returned if a request does not specify a limit. If 0 then
+	// `sqlite.DEFAULT_LIMIT` will be used.
+	DefaultLimit int
+	// MaxLimit is the maximum number of results which may be requested. If 0 then `DEFAULT_MAX_LIMIT` will be used.
+	MaxLimit int
+	// MaxRadius is the maximum radius, in metres, for "nearby" queries. If 0 then `DEFAULT_MAX_RADIUS` will be used.
+	MaxRadius float64
+}
+
+// PlaceResult is a place returned by a query.
+type PlaceResult struct {
+	*places.Place
+	// The distance, in metres, from the point of a "nearby" query.
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// PlacesResponse is a JSON-encoded list of places returned by a query.
+type PlacesResponse struct {
+	// The places matching the query.
+	Places []*PlaceResult `json:"places"`
+	// The cursor to pass to the same query to return the next page of results. If empty there are no more results.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SPRResponse is a JSON-encoded list of places, as Who's On First Standard Places Results, returned by a query.
+type SPRResponse struct {
+	// The places matching the query.
+	Places []*whosonfirst.FoursquareStandardPlacesResult `json:"places"`
+	// The cursor to pass to the same query to return the next page of results. If empty there are no more results.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// queryFunc is a function which derives a query from 'req' and 'opts'. Any error it returns is treated as an
+// invalid request.
+type queryFunc func(req *http.Request, opts *sqlite.QueryOptions) (iter.Seq2[*sqlite.QueryResult, error], error)
+
+// PlaceHandler returns an `http.Handler` which returns the place whose Foursquare ID is the "id" path value of
+// the request, for example "/place/{id}".
+func PlaceHandler(opts *PlacesHandlerOptions) (http.Handler, error) {
+
+	if opts.Database == nil {
+		return nil, fmt.Errorf("Missing database")
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		logger := slog.Default()
+		logger = logger.With("method", req.Method)
+		logger = logger.With("path", req.URL.Path)
+
+		format, err := responseFormat(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := req.PathValue("id")
+
+		pl, err := opts.Database.GetPlace(ctx, id)
+
+		if errors.Is(err, sqlite.ErrNotFound) {
+			http.Error(rsp, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			logger.Error("Failed to get place", "id", id, "error", err)
+			http.Error(rsp, "Failed to get place", http.StatusInternalServerError)
+			return
+		}
+
+		var body any = pl
+
+		switch format {
+		case FORMAT_GEOJSON:
+
+			f, err := pl.AsFeature()
+
+			if err != nil {
+				logger.Error("Failed to derive feature", "id", id, "error", err)
+				http.Error(rsp, "Failed to get place", http.StatusInternalServerError)
+				return
+			}
+
+			body = f
+
+		case FORMAT_SPR:
+			body = whosonfirst.NewSPR(pl)
+		}
+
+		rsp.Header().Set("Content-Type", contentType(format))
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(body)
+
+		if err != nil {
+			logger.Error("Failed to encode response", "error", err)
+		}
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// NearbyHandler returns an `http.Handler` which returns the places within the "radius" (metres) of the "lat" and
+// "lon" query parameters of a request, ordered by distance.
+func NearbyHandler(opts *PlacesHandlerOptions) (http.Handler, error) {
+
+	max_radius := opts.MaxRadius
+
+	if max_radius <= 0 {
+		max_radius = DEFAULT_MAX_RADIUS
+	}
+
+	query_func := func(req *http.Request, query_opts *sqlite.QueryOptions) (iter.Seq2[*sqlite.QueryResult, error], error) {
+
+		q := req.URL.Query()
+
+		lat, err := floatParameter(q.Get("lat"), "lat", -90.0, 90.0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lon, err := floatParameter(q.Get("lon"), "lon", -180.0, 180.0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		radius := 1000.0
+
+		if q.Has("radius") {
+
+			radius, err = floatParameter(q.Get("radius"), "radius", 0.0, max_radius)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return opts.Database.Nearby(req.Context(), lat, lon, radius, query_opts), nil
+	}
+
+	return queryHandler(opts, true, query_func)
+}
+
+// BoundingBoxHandler returns an `http.Handler` which returns the places inside the "bbox" query parameter of a
+// request, which is expected to be a comma-separated list of minimum longitude, minimum latitude, maximum
+// longitude and maximum latitude.
+func BoundingBoxHandler(opts *PlacesHandlerOptions) (http.Handler, error) {
+
+	query_func := func(req *http.Request, query_opts *sqlite.QueryOptions) (iter.Seq2[*sqlite.QueryResult, error], error) {
+
+		str_bbox := req.URL.Query().Get("bbox")
+
+		if str_bbox == "" {
+			return nil, fmt.Errorf("Missing bbox parameter")
+		}
+
+		parts := strings.Split(str_bbox, ",")
+
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Invalid bbox parameter, expected {MINX},{MINY},{MAXX},{MAXY}")
+		}
+
+		coords := make([]float64, 4)
+
+		for i, str := range parts {
+
+			v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid bbox parameter, %w", err)
+			}
+
+			coords[i] = v
+		}
+
+		return opts.Database.BoundingBox(req.Context(), coords[0], coords[1], coords[2], coords[3], query_opts), nil
+	}
+
+	return queryHandler(opts, false, query_func)
+}
+
+// SearchHandler returns an `http.Handler` which returns the places whose name or address match the "q" query
+// parameter of a request, ordered by relevance. The last term in the query is treated as a prefix.
+func SearchHandler(opts *PlacesHandlerOptions) (http.Handler, error) {
+
+	query_func := func(req *http.Request, query_opts *sqlite.QueryOptions) (iter.Seq2[*sqlite.QueryResult, error], error) {
+
+		terms := strings.TrimSpace(req.URL.Query().Get("q"))
+
+		if terms == "" {
+			return nil, fmt.Errorf("Missing q parameter")
+		}
+
+		return opts.Database.Search(req.Context(), terms, query_opts), nil
+	}
+
+	return queryHandler(opts, false, query_func)
+}
+
+// CategoryPlacesHandler returns an `http.Handler` which returns the places in the category whose ID is the
+// "id" path value of the request, for example "/categories/{id}/places".
+func CategoryPlacesHandler(opts *PlacesHandlerOptions) (http.Handler, error) {
+
+	query_func := func(req *http.Request, query_opts *sqlite.QueryOptions) (iter.Seq2[*sqlite.QueryResult, error], error) {
+		return opts.Database.CategoryPlaces(req.Context(), req.PathValue("id"), query_opts), nil
+	}
+
+	return queryHandler(opts, false, query_func)
+}
+
+// queryHandler returns an `http.Handler` which writes the results of the query derived by 'query_func' in the
+// format requested. Every query accepts "limit", "cursor", "category" and "format" query parameters.
+func queryHandler(opts *PlacesHandlerOptions, with_distance bool, query_func queryFunc) (http.Handler, error) {
+
+	if opts.Database == nil {
+		return nil, fmt.Errorf("Missing database")
+	}
+
+	default_limit := opts.DefaultLimit
+
+	if default_limit <= 0 {
+		default_limit = sqlite.DEFAULT_LIMIT
+	}
+
+	max_limit := opts.MaxLimit
+
+	if max_limit <= 0 {
+		max_limit = DEFAULT_MAX_LIMIT
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		logger := slog.Default()
+		logger = logger.With("method", req.Method)
+		logger = logger.With("path", req.URL.Path)
+
+		q := req.URL.Query()
+
+		format, err := responseFormat(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := default_limit
+
+		if q.Has("limit") {
+
+			v, err := strconv.Atoi(q.Get("limit"))
+
+			if err != nil || v < 1 || v > max_limit {
+				http.Error(rsp, fmt.Sprintf("Invalid limit parameter, must be between 1 and %d", max_limit), http.StatusBadRequest)
+				return
+			}
+
+			limit = v
+		}
+
+		// Ask for one more result than the limit to know whether there is another page of results
+
+		query_opts := &sqlite.QueryOptions{
+			Limit:    limit + 1,
+			Cursor:   q.Get("cursor"),
+			Category: q.Get("category"),
+		}
+
+		seq, err := query_func(req, query_opts)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeError := func(err error) {
+
+			if errors.Is(err, sqlite.ErrInvalidQuery) {
+				http.Error(rsp, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			logger.Error("Failed to query places", "error", err)
+			http.Error(rsp, "Failed to query places", http.StatusInternalServerError)
+		}
+
+		if format == FORMAT_NDJSON {
+			writeNDJSON(rsp, req, seq, limit, with_distance, writeError, logger)
+			return
+		}
+
+		results := make([]*PlaceResult, 0)
+		next_cursor := ""
+
+		// The cursor of the most recent result
+		last_cursor := ""
+
+		for r, err := range seq {
+
+			if err != nil {
+				writeError(err)
+				return
+			}
+
+			if len(results) == limit {
+				next_cursor = last_cursor
+				break
+			}
+
+			results = append(results, placeResult(r, with_distance))
+			last_cursor = r.Cursor
+		}
+
+		var body any
+
+		switch format {
+		case FORMAT_GEOJSON:
+
+			fc := geojson.NewFeatureCollection()
+
+			for _, r := range results {
+
+				f, err := r.Place.AsFeature()
+
+				if err != nil {
+					writeError(err)
+					return
+				}
+
+				if r.Distance != nil {
+					f.Properties["distance"] = *r.Distance
+				}
+
+				fc.Append(f)
+			}
+
+			if next_cursor != "" {
+				fc.ExtraMembers = geojson.Properties{
+					"next_cursor": next_cursor,
+				}
+			}
+
+			body = fc
+
+		case FORMAT_SPR:
+
+			spr_results := make([]*whosonfirst.FoursquareStandardPlacesResult, len(results))
+
+			for i, r := range results {
+				spr_results[i] = whosonfirst.NewSPR(r.Place)
+			}
+
+			body = &SPRResponse{
+				Places:     spr_results,
+				NextCursor: next_cursor,
+			}
+
+		default:
+
+			body = &PlacesResponse{
+				Places:     results,
+				NextCursor: next_cursor,
+			}
+		}
+
+		rsp.Header().Set("Content-Type", contentType(format))
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(body)
+
+		if err != nil {
+			logger.Error("Failed to encode response", "error", err)
+		}
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// writeNDJSON streams the results in 'seq' as newline-delimited JSON-encoded `PlaceResult` records. If there are
+// more than 'limit' results a final `{"next_cursor": "..."}` record is written.
+func writeNDJSON(rsp http.ResponseWriter, req *http.Request, seq iter.Seq2[*sqlite.QueryResult, error], limit int, with_distance bool, writeError func(error), logger *slog.Logger) {
+
+	ctx := req.Context()
+
+	flusher, _ := rsp.(http.Flusher)
+	enc := json.NewEncoder(rsp)
+
+	count := 0
+	last_cursor := ""
+
+	for r, err := range seq {
+
+		if ctx.Err() != nil {
+			logger.Debug("Request cancelled", "count", count)
+			return
+		}
+
+		if err != nil {
+
+			// Once results have been written the status can't be changed so just stop
+
+			if count == 0 {
+				writeError(err)
+			} else {
+				logger.Error("Failed to query places", "error", err)
+			}
+
+			return
+		}
+
+		if count == 0 {
+			rsp.Header().Set("Content-Type", contentType(FORMAT_NDJSON))
+		}
+
+		if count == limit {
+
+			err := enc.Encode(map[string]string{"next_cursor": last_cursor})
+
+			if err != nil {
+				logger.Error("Failed to encode cursor", "error", err)
+			}
+
+			break
+		}
+
+		err = enc.Encode(placeResult(r, with_distance))
+
+		if err != nil {
+			logger.Debug("Failed to encode result", "error", err)
+			return
+		}
+
+		count += 1
+		last_cursor = r.Cursor
+
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+	}
+
+	if count == 0 {
+		rsp.Header().Set("Content-Type", contentType(FORMAT_NDJSON))
+		rsp.WriteHeader(http.StatusOK)
+	}
+}
+
+func placeResult(r *sqlite.QueryResult, with_distance bool) *PlaceResult {
+
+	pr := &PlaceResult{
+		Place: r.Place,
+	}
+
+	if with_distance {
+		d := r.Distance
+		pr.Distance = &d
+	}
+
+	return pr
+}
+
+// responseFormat returns the response format for 'req' derived from its "format" query parameter or,
+// if absent, its Accept header. The default is `FORMAT_JSON`.
+func responseFormat(req *http.Request) (string, error) {
+
+	format := req.URL.Query().Get("format")
+
+	switch format {
+	case FORMAT_JSON, FORMAT_GEOJSON, FORMAT_NDJSON, FORMAT_SPR:
+		return format, nil
+	case "":
+		// pass
+	default:
+		return "", fmt.Errorf("Invalid format parameter, must be one of: %s, %s, %s, %s", FORMAT_JSON, FORMAT_GEOJSON, FORMAT_NDJSON, FORMAT_SPR)
+	}
+
+	accept := req.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "application/geo+json"):
+		return FORMAT_GEOJSON, nil
+	case strings.Contains(accept, "application/x-ndjson"):
+		return FORMAT_NDJSON, nil
+	default:
+		return FORMAT_JSON, nil
+	}
+}
+
+func contentType(format string) string {
+
+	switch format {
+	case FORMAT_GEOJSON:
+		return "application/geo+json"
+	case FORMAT_NDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+func floatParameter(str string, name string, min float64, max float64) (float64, error) {
+
+	if str == "" {
+		return 0.0, fmt.Errorf("Missing %s parameter", name)
+	}
+
+	v, err := strconv.ParseFloat(str, 64)
+
+	if err != nil {
+		return 0.0, fmt.Errorf("Invalid %s parameter, %w", name, err)
+	}
+
+	if v < min || v > max {
+		return 0.0, fmt.Errorf("Invalid %s parameter, must be between %v and %v", name, min, max)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,307 @@
+// Package api provides HTTP handlers for reverse-geocoding and querying Foursquare places.
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+)
+
+// DEFAULT_MAX_LINE_SIZE is the default maximum size, in bytes, of a single line in a batch request.
+const DEFAULT_MAX_LINE_SIZE int = 1024 * 1024
+
+// DEFAULT_MAX_BODY_SIZE is the default maximum size, in bytes, of the body of a batch request.
+const DEFAULT_MAX_BODY_SIZE int64 = 1024 * 1024 * 1024
+
+// ReverseGeocodeHandlerOptions defines configuration options for the `ReverseGeocodeHandler` and
+// `BatchReverseGeocodeHandler` methods.
+type ReverseGeocodeHandlerOptions struct {
+	// Resolver is the `reversegeo.Resolver` instance used to reverse-geocode places.
+	Resolver *reversegeo.Resolver
+	// MaxLineSize is the maximum size, in bytes, of a single line in a batch request (or the body of a single
+	// request). If 0 then `DEFAULT_MAX_LINE_SIZE` will be used.
+	MaxLineSize int
+	// MaxBodySize is the maximum size, in bytes, of the body of a batch request. If 0 then `DEFAULT_MAX_BODY_SIZE`
+	// will be used. If less than 0 the size of the body is not limited.
+	MaxBodySize int64
+}
+
+// BatchError is the final record written by the `BatchReverseGeocodeHandler` handler if processing stops
+// after results have already been written.
+type BatchError struct {
+	// A description of the error.
+	Error string `json:"error"`
+	// The (1-based) line number of the request body at which processing stopped.
+	Line int `json:"line"`
+}
+
+// ReverseGeocodeRequest is a place to reverse-geocode. It is either a JSON-encoded `places.Place` record or a
+// JSON object with "lat" and "lon" properties. If both are present "lat" and "lon" take precedence.
+type ReverseGeocodeRequest struct {
+	places.Place
+	Lat *float64 `json:"lat,omitempty"`
+	Lon *float64 `json:"lon,omitempty"`
+}
+
+// AsPlace returns the `places.Place` record for 'req'.
+func (req *ReverseGeocodeRequest) AsPlace() *places.Place {
+
+	pl := req.Place
+
+	if req.Lat != nil && req.Lon != nil {
+		pl.Latitude = *req.Lat
+		pl.Longitude = *req.Lon
+	}
+
+	return &pl
+}
+
+// ReverseGeocodeHandler returns an `http.Handler` which reverse-geocodes a single place, posted as a JSON-encoded
+// `ReverseGeocodeRequest`, and returns a JSON-encoded `reversegeo.Result`.
+func ReverseGeocodeHandler(opts *ReverseGeocodeHandlerOptions) (http.Handler, error) {
+
+	if opts.Resolver == nil {
+		return nil, fmt.Errorf("Missing resolver")
+	}
+
+	max_line_size := opts.MaxLineSize
+
+	if max_line_size == 0 {
+		max_line_size = DEFAULT_MAX_LINE_SIZE
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		logger := slog.Default()
+		logger = logger.With("method", req.Method)
+		logger = logger.With("path", req.URL.Path)
+
+		if req.Method != http.MethodPost {
+			http.Error(rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var rg_req ReverseGeocodeRequest
+
+		body := http.MaxBytesReader(rsp, req.Body, int64(max_line_size))
+
+		dec := json.NewDecoder(body)
+		err := dec.Decode(&rg_req)
+
+		if err != nil {
+
+			logger.Debug("Failed to decode request", "error", err)
+
+			var max_bytes_err *http.MaxBytesError
+
+			if errors.As(err, &max_bytes_err) {
+				http.Error(rsp, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(rsp, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		rg_rsp, err := opts.Resolver.ResolvePlace(ctx, rg_req.AsPlace())
+
+		if err != nil {
+			logger.Error("Failed to resolve place", "id", rg_req.Id, "error", err)
+			http.Error(rsp, "Failed to resolve place", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(rg_rsp)
+
+		if err != nil {
+			logger.Error("Failed to encode response", "error", err)
+		}
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// BatchReverseGeocodeHandler returns an `http.Handler` which reverse-geocodes a newline-delimited list of places,
+// posted as JSON-encoded `ReverseGeocodeRequest` records, and streams a newline-delimited list of JSON-encoded
+// `reversegeo.Result` records in the same order. Places which fail to resolve are returned with a parent ID of -1
+// and an "error" reason. Processing stops at the first line which can not be decoded, or if the request body can
+// not be read (for example because it exceeds `MaxBodySize`). If that happens before any results have been written
+// an HTTP error is returned otherwise, since the response status has already been sent, a final JSON-encoded
+// `BatchError` record is written.
+func BatchReverseGeocodeHandler(opts *ReverseGeocodeHandlerOptions) (http.Handler, error) {
+
+	if opts.Resolver == nil {
+		return nil, fmt.Errorf("Missing resolver")
+	}
+
+	max_line_size := opts.MaxLineSize
+
+	if max_line_size == 0 {
+		max_line_size = DEFAULT_MAX_LINE_SIZE
+	}
+
+	max_body_size := opts.MaxBodySize
+
+	if max_body_size == 0 {
+		max_body_size = DEFAULT_MAX_BODY_SIZE
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		logger := slog.Default()
+		logger = logger.With("method", req.Method)
+		logger = logger.With("path", req.URL.Path)
+
+		if req.Method != http.MethodPost {
+			http.Error(rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body := req.Body
+
+		if max_body_size > 0 {
+			body = http.MaxBytesReader(rsp, body, max_body_size)
+		}
+
+		rsp.Header().Set("Content-Type", "application/x-ndjson")
+
+		flusher, _ := rsp.(http.Flusher)
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), max_line_size)
+
+		enc := json.NewEncoder(rsp)
+		count := 0
+		line_number := 0
+
+		stop := func(status int, msg string) {
+
+			if count == 0 {
+				http.Error(rsp, msg, status)
+				return
+			}
+
+			batch_err := &BatchError{
+				Error: msg,
+				Line:  line_number,
+			}
+
+			err := enc.Encode(batch_err)
+
+			if err != nil {
+				logger.Error("Failed to encode error", "error", err)
+			}
+		}
+
+		stopRead := func(err error) {
+
+			logger.Warn("Failed to read request body", "count", count, "error", err)
+
+			var max_bytes_err *http.MaxBytesError
+
+			switch {
+			case errors.As(err, &max_bytes_err):
+				stop(http.StatusRequestEntityTooLarge, "Request body too large")
+			case errors.Is(err, bufio.ErrTooLong):
+				stop(http.StatusRequestEntityTooLarge, "Line too long")
+			default:
+				stop(http.StatusBadRequest, "Failed to read request body")
+			}
+		}
+
+		for scanner.Scan() {
+
+			line_number += 1
+
+			select {
+			case <-ctx.Done():
+				logger.Debug("Request cancelled", "count", count)
+				return
+			default:
+				// pass
+			}
+
+			line := scanner.Bytes()
+
+			if len(line) == 0 {
+				continue
+			}
+
+			var rg_req ReverseGeocodeRequest
+
+			err := json.Unmarshal(line, &rg_req)
+
+			if err != nil {
+
+				// A truncated final line is returned by the scanner if the body could not be read in full
+				read_err := scanner.Err()
+
+				if read_err != nil {
+					stopRead(read_err)
+					return
+				}
+
+				logger.Warn("Failed to decode line, stopping", "line", line_number, "error", err)
+				stop(http.StatusBadRequest, "Failed to decode line")
+				return
+			}
+
+			pl := rg_req.AsPlace()
+
+			rg_rsp, err := opts.Resolver.ResolvePlace(ctx, pl)
+
+			if err != nil {
+
+				logger.Error("Failed to resolve place", "id", pl.Id, "error", err)
+
+				rg_rsp = &reversegeo.Result{
+					Id:               pl.Id,
+					ParentId:         -1,
+					BelongsTo:        make([]int64, 0),
+					Hierarchies:      make([]map[string]int64, 0),
+					Alternates:       make([]int64, 0),
+					BoundaryDistance: -1.0,
+					NameSimilarity:   -1.0,
+					Reason:           reversegeo.REASON_ERROR,
+				}
+			}
+
+			err = enc.Encode(rg_rsp)
+
+			if err != nil {
+				logger.Error("Failed to encode result, stopping", "id", pl.Id, "error", err)
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			count += 1
+		}
+
+		err := scanner.Err()
+
+		if err != nil && err != io.EOF {
+			// Note that the line which could not be read is the one after the last line which was read.
+			line_number += 1
+			stopRead(err)
+		}
+	}
+
+	return http.HandlerFunc(fn), nil
+}
@@ -0,0 +1,119 @@
+// Package cache provides interfaces and implementations for caching the properties of Who's On First parent records
+// used when reverse-geocoding Foursquare places.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aaronland/go-roster"
+)
+
+// ErrCacheMiss is returned by `ParentCache.Get` when there is no cached record for a given ID.
+var ErrCacheMiss = errors.New("Cache miss")
+
+// Parent is the subset of a Who's On First record's properties used to describe the ancestors of a place.
+type Parent struct {
+	// The Who's On First ID of the parent record.
+	Id int64 `json:"wof:id"`
+	// The value of the parent record's `wof:lastmodified` property.
+	LastModified int64 `json:"wof:lastmodified"`
+	// The value of the parent record's `wof:belongs_to` property.
+	BelongsTo []int64 `json:"wof:belongs_to"`
+	// The value of the parent record's `wof:hierarchy` property.
+	Hierarchies []map[string]int64 `json:"wof:hierarchy"`
+}
+
+// ParentCache is an interface for caching `Parent` records.
+type ParentCache interface {
+	// Get returns the `Parent` record for an ID or `ErrCacheMiss` if there is no cached record.
+	Get(context.Context, int64) (*Parent, error)
+	// Set caches a `Parent` record.
+	Set(context.Context, *Parent) error
+	// Close performs any terminating operations associated with the cache.
+	Close() error
+}
+
+var cache_roster roster.Roster
+
+// ParentCacheInitializationFunc is a function defined by individual cache package and used to create
+// an instance of that cache
+type ParentCacheInitializationFunc func(ctx context.Context, uri string) (ParentCache, error)
+
+// RegisterParentCache registers 'scheme' as a key pointing to 'init_func' in an internal lookup table
+// used to create new `ParentCache` instances by the `NewParentCache` method.
+func RegisterParentCache(ctx context.Context, scheme string, init_func ParentCacheInitializationFunc) error {
+
+	err := ensureParentCacheRoster()
+
+	if err != nil {
+		return err
+	}
+
+	return cache_roster.Register(ctx, scheme, init_func)
+}
+
+func ensureParentCacheRoster() error {
+
+	if cache_roster == nil {
+
+		r, err := roster.NewDefaultRoster()
+
+		if err != nil {
+			return err
+		}
+
+		cache_roster = r
+	}
+
+	return nil
+}
+
+// NewParentCache returns a new `ParentCache` instance configured by 'uri'. The value of 'uri' is parsed
+// as a `url.URL` and its scheme is used as the key for a corresponding `ParentCacheInitializationFunc`
+// function used to instantiate the new `ParentCache`. It is assumed that the scheme (and initialization
+// function) have been registered by the `RegisterParentCache` method.
+func NewParentCache(ctx context.Context, uri string) (ParentCache, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := u.Scheme
+
+	i, err := cache_roster.Driver(ctx, scheme)
+
+	if err != nil {
+		return nil, err
+	}
+
+	init_func := i.(ParentCacheInitializationFunc)
+	return init_func(ctx, uri)
+}
+
+// ParentCacheSchemes returns the list of schemes that have been registered.
+func ParentCacheSchemes() []string {
+
+	ctx := context.Background()
+	schemes := []string{}
+
+	err := ensureParentCacheRoster()
+
+	if err != nil {
+		return schemes
+	}
+
+	for _, dr := range cache_roster.Drivers(ctx) {
+		scheme := fmt.Sprintf("%s://", strings.ToLower(dr))
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
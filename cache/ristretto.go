@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// RistrettoParentCache implements the `ParentCache` interface using an in-memory ristretto cache.
+type RistrettoParentCache struct {
+	ParentCache
+	cache *ristretto.Cache[int64, *Parent]
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterParentCache(ctx, "ristretto", NewRistrettoParentCache)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewRistrettoParentCache returns a new `RistrettoParentCache` instance configured by 'uri' which is expected to
+// take the form of:
+//
+//	ristretto://
+func NewRistrettoParentCache(ctx context.Context, uri string) (ParentCache, error) {
+
+	c, err := ristretto.NewCache(&ristretto.Config[int64, *Parent]{
+		NumCounters: 1e7,     // number of keys to track frequency of (10M).
+		MaxCost:     1 << 30, // maximum cost of cache (1GB).
+		BufferItems: 64,      // number of keys per Get buffer.
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &RistrettoParentCache{
+		cache: c,
+	}
+
+	return pc, nil
+}
+
+func (pc *RistrettoParentCache) Get(ctx context.Context, id int64) (*Parent, error) {
+
+	v, exists := pc.cache.Get(id)
+
+	if !exists {
+		return nil, ErrCacheMiss
+	}
+
+	return v, nil
+}
+
+func (pc *RistrettoParentCache) Set(ctx context.Context, p *Parent) error {
+	pc.cache.Set(p.Id, p, 1)
+	return nil
+}
+
+func (pc *RistrettoParentCache) Close() error {
+	pc.cache.Close()
+	return nil
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqlite_schema string = `CREATE TABLE IF NOT EXISTS parents (
+	id INTEGER PRIMARY KEY,
+	lastmodified INTEGER,
+	belongs_to TEXT,
+	hierarchies TEXT
+)`
+
+// SQLiteParentCache implements the `ParentCache` interface using a SQLite database so that cached records
+// persist between runs.
+type SQLiteParentCache struct {
+	ParentCache
+	db *sql.DB
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterParentCache(ctx, "sqlite", NewSQLiteParentCache)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewSQLiteParentCache returns a new `SQLiteParentCache` instance configured by 'uri' which is expected to
+// take the form of:
+//
+//	sqlite://{PATH_TO_SQLITE_DATABASE}
+//
+// The database will be created if it does not already exist.
+func NewSQLiteParentCache(ctx context.Context, uri string) (ParentCache, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Account for relative paths like sqlite://cache.db
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, sqlite_schema)
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create schema, %w", err)
+	}
+
+	pc := &SQLiteParentCache{
+		db: db,
+	}
+
+	return pc, nil
+}
+
+func (pc *SQLiteParentCache) Get(ctx context.Context, id int64) (*Parent, error) {
+
+	var lastmod int64
+	var str_belongs_to string
+	var str_hierarchies string
+
+	q := "SELECT lastmodified, belongs_to, hierarchies FROM parents WHERE id = ?"
+	row := pc.db.QueryRowContext(ctx, q, id)
+
+	err := row.Scan(&lastmod, &str_belongs_to, &str_hierarchies)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrCacheMiss
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query parent %d, %w", id, err)
+	}
+
+	p := &Parent{
+		Id:           id,
+		LastModified: lastmod,
+	}
+
+	err = json.Unmarshal([]byte(str_belongs_to), &p.BelongsTo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal belongs to for parent %d, %w", id, err)
+	}
+
+	err = json.Unmarshal([]byte(str_hierarchies), &p.Hierarchies)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal hierarchies for parent %d, %w", id, err)
+	}
+
+	return p, nil
+}
+
+func (pc *SQLiteParentCache) Set(ctx context.Context, p *Parent) error {
+
+	enc_belongs_to, err := json.Marshal(p.BelongsTo)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal belongs to for parent %d, %w", p.Id, err)
+	}
+
+	enc_hierarchies, err := json.Marshal(p.Hierarchies)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal hierarchies for parent %d, %w", p.Id, err)
+	}
+
+	q := "INSERT OR REPLACE INTO parents (id, lastmodified, belongs_to, hierarchies) VALUES (?, ?, ?, ?)"
+	_, err = pc.db.ExecContext(ctx, q, p.Id, p.LastModified, string(enc_belongs_to), string(enc_hierarchies))
+
+	if err != nil {
+		return fmt.Errorf("Failed to store parent %d, %w", p.Id, err)
+	}
+
+	return nil
+}
+
+func (pc *SQLiteParentCache) Close() error {
+	return pc.db.Close()
+}
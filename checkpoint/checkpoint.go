@@ -0,0 +1,103 @@
+// Package checkpoint provides methods for recording how far a long-running command got before it finished or
+// was interrupted so that it can be resumed later.
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint records the progress of a long-running command.
+type Checkpoint struct {
+	// The name of the command that wrote the checkpoint.
+	Command string `json:"command"`
+	// The source (for example an emitter URI or a path) being processed when the checkpoint was written.
+	Source string `json:"source,omitempty"`
+	// The number of records read from the source, including any that were skipped.
+	Counter int64 `json:"counter"`
+	// The number of records that were processed.
+	Processed int64 `json:"processed"`
+	// The value to pass to the command's -start-after flag to resume processing. This is only
+	// meaningful if `Complete` is false.
+	StartAfter int64 `json:"start_after"`
+	// Whether or not all the records in the source were processed.
+	Complete bool `json:"complete"`
+	// The reason processing stopped early, if it did.
+	Error string `json:"error,omitempty"`
+	// The time the checkpoint was written.
+	Updated time.Time `json:"updated"`
+}
+
+// Write writes 'cp' as JSON to 'path'. The checkpoint is written to a temporary file first and then
+// renamed so an interruption while writing never leaves a partial checkpoint behind.
+func (cp *Checkpoint) Write(path string) error {
+
+	cp.Updated = time.Now()
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive absolute path for %s, %w", path, err)
+	}
+
+	tmp_wr, err := os.CreateTemp(filepath.Dir(abs_path), filepath.Base(abs_path)+".*")
+
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file, %w", err)
+	}
+
+	tmp_path := tmp_wr.Name()
+
+	enc := json.NewEncoder(tmp_wr)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(cp)
+
+	if err != nil {
+		tmp_wr.Close()
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to encode checkpoint, %w", err)
+	}
+
+	err = tmp_wr.Close()
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to close temporary file, %w", err)
+	}
+
+	err = os.Rename(tmp_path, abs_path)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to write %s, %w", path, err)
+	}
+
+	return nil
+}
+
+// Read reads a JSON-encoded `Checkpoint` from 'path'.
+func Read(path string) (*Checkpoint, error) {
+
+	r, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer r.Close()
+
+	var cp *Checkpoint
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&cp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	return cp, nil
+}
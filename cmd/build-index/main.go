@@ -0,0 +1,75 @@
+package main
+
+/*
+
+./bin/build-index \
+    -index-path /usr/local/data/4sq/4sq-index.db \
+    /usr/local/data/4sq/4sq.csv.bz2
+
+*/
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/whosonfirst/go-foursquare-places/index"
+)
+
+func main() {
+
+	var index_path string
+	var workers int
+	var verbose bool
+
+	flag.StringVar(&index_path, "index-path", "", "The path to the SQLite database where the index is stored. It will be created if it does not already exist.")
+	flag.IntVar(&workers, "workers", 0, "The number of bzip2 blocks to decompress concurrently. If 0 then the number of CPUs will be used.")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if index_path == "" {
+		log.Fatal("Missing -index-path flag")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	idx, err := index.NewIndex(ctx, index_path)
+
+	if err != nil {
+		log.Fatalf("Failed to create index, %v", err)
+	}
+
+	defer idx.Close()
+
+	for _, path := range flag.Args() {
+
+		t1 := time.Now()
+
+		opts := &index.BuildOptions{
+			Workers: workers,
+			Progress: func(count int64) {
+				slog.Info("Status", "path", path, "count", count, "elapsed", time.Since(t1))
+			},
+		}
+
+		count, err := idx.Build(ctx, path, opts)
+
+		if err != nil {
+			idx.Close()
+			log.Fatalf("Failed to index %s after %d places, %v", path, count, err)
+		}
+
+		slog.Info("Indexed places", "path", path, "count", count, "elapsed", time.Since(t1))
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+/*
+
+./bin/conflate \
+    -emitter-uri csv:///usr/local/data/4sq/4sq-us.csv.bz2 \
+    -venues-iterator-uri repo:// \
+    -format csv \
+    /usr/local/data/whosonfirst-data-venue-us \
+    > /usr/local/data/4sq/4sq-us-conflated.csv
+
+./bin/conflate \
+    -emitter-uri csv:///usr/local/data/4sq/4sq-us.csv.bz2 \
+    -venues-database-path /usr/local/data/whosonfirst-data-venue-us-latest.db \
+    -writer-uri repo:///usr/local/data/whosonfirst-data-venue-us \
+    > /usr/local/data/4sq/4sq-us-conflated.ndjson
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/sfomuseum/go-csvdict/v2"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/conflate"
+	"github.com/whosonfirst/go-foursquare-places/dedupe"
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+	"github.com/whosonfirst/go-foursquare-places/ledger"
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-export/v2"
+	"github.com/whosonfirst/go-writer/v3"
+)
+
+func main() {
+
+	var emitter_uri string
+	var venues_iterator_uri string
+	var venues_database_path string
+	var reader_uri string
+	var writer_uri string
+	var ledger_uri string
+	var workers int
+	var format string
+	var verbose bool
+
+	opts := new(conflate.Options)
+
+	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI used to read the places to conflate.")
+	flag.StringVar(&venues_iterator_uri, "venues-iterator-uri", "", "A registered whosonfirst/go-whosonfirst-iterate/v2/iterator.Iterator URI used to read Who's On First venues from the URIs passed as arguments.")
+	flag.StringVar(&venues_database_path, "venues-database-path", "", "The path to a Who's On First SQLite database to read venues from. This flag and the -venues-iterator-uri flag are mutually exclusive.")
+
+	flag.Float64Var(&opts.MaxDistance, "max-distance", conflate.DEFAULT_MAX_DISTANCE, "The maximum distance, in metres, between a place and the venues it is compared with.")
+	flag.Float64Var(&opts.MatchThreshold, "match-threshold", conflate.DEFAULT_MATCH_THRESHOLD, "The minimum score (0.0 to 1.0) for a place to be matched with a venue.")
+	flag.Float64Var(&opts.AmbiguousThreshold, "ambiguous-threshold", conflate.DEFAULT_AMBIGUOUS_THRESHOLD, "The minimum score (0.0 to 1.0) for a venue to be considered a candidate for a place.")
+	flag.Float64Var(&opts.MinMargin, "min-margin", conflate.DEFAULT_MIN_MARGIN, "The minimum difference between the scores of the best and second-best candidates for a place to be matched with the best candidate.")
+	flag.Float64Var(&opts.MinNameSimilarity, "min-name-similarity", dedupe.DEFAULT_MIN_NAME_SIMILARITY, "The minimum similarity (0.0 to 1.0) of the names of a place and the venues it is compared with.")
+	flag.IntVar(&opts.MaxCandidates, "max-candidates", conflate.DEFAULT_MAX_CANDIDATES, "The maximum number of candidates to include for each place.")
+
+	flag.StringVar(&writer_uri, "writer-uri", "", "An optional registered whosonfirst/go-writer/v3.Writer URI. If present venues which are matched with a single place will be assigned a 4sq:id concordance, exported using the whosonfirst/go-whosonfirst-export package and written to this writer.")
+	flag.StringVar(&reader_uri, "reader-uri", "", "A registered whosonfirst/go-reader.Reader URI used to read the venues which are assigned concordances. Required if -writer-uri is present, unless the -venues-database-path flag is present in which case venues will be read from that database.")
+
+	flag.StringVar(&ledger_uri, "ledger-uri", "", "An optional registered whosonfirst/go-foursquare-places/ledger.Ledger URI. If present the Who's On First IDs of venues which are matched with a single place, or which already have a 4sq:id concordance, will be recorded in the ledger.")
+
+	flag.IntVar(&workers, "workers", 0, "The number of workers used to match places. If 0 then the number of CPUs will be used.")
+	flag.StringVar(&format, "format", "ndjson", "The format to write results to STDOUT in. Valid options are: ndjson (one JSON-encoded result per line), csv (one row per place).")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if emitter_uri == "" {
+		log.Fatal("Missing -emitter-uri flag")
+	}
+
+	switch {
+	case venues_iterator_uri != "" && venues_database_path != "":
+		log.Fatal("The -venues-iterator-uri and -venues-database-path flags are mutually exclusive")
+	case venues_iterator_uri == "" && venues_database_path == "":
+		log.Fatal("Missing -venues-iterator-uri or -venues-database-path flag")
+	}
+
+	if writer_uri != "" && reader_uri == "" && venues_database_path == "" {
+		log.Fatal("Missing -reader-uri flag")
+	}
+
+	if format != "ndjson" && format != "csv" {
+		log.Fatalf("Invalid -format flag, must be one of: ndjson, csv")
+	}
+
+	// Stop matching new places when interrupted but still write the results for the places which were
+	// matched before that. Concordances are only written (and recorded) once every place has been matched
+	// since a venue matched so far may also match a place which has not been read yet. Once they are being
+	// written concordances are written in full, regardless of interruptions.
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	process_ctx := context.WithoutCancel(ctx)
+
+	// Index venues
+
+	c := conflate.NewConflator(opts)
+
+	var count int64
+	var err error
+
+	if venues_database_path != "" {
+		count, err = c.AddVenuesFromDatabase(ctx, venues_database_path)
+	} else {
+		count, err = c.AddVenuesFromIterator(ctx, venues_iterator_uri, flag.Args()...)
+	}
+
+	if err != nil {
+		log.Fatalf("Failed to read venues, %v", err)
+	}
+
+	if ctx.Err() != nil {
+		log.Fatalf("Interrupted while reading venues, %v", ctx.Err())
+	}
+
+	slog.Info("Indexed venues", "count", count)
+
+	// Match places
+
+	e, err := emitter.NewEmitter(ctx, emitter_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create emitter, %v", err)
+	}
+
+	defer e.Close()
+
+	var csv_wr *csvdict.Writer
+	enc := json.NewEncoder(os.Stdout)
+
+	if format == "csv" {
+
+		csv_wr, err = csvdict.NewWriter(os.Stdout)
+
+		if err != nil {
+			log.Fatalf("Failed to create CSV writer, %v", err)
+		}
+	}
+
+	// The number of places with each status, read by the progress function while the pipeline is running
+	counts := map[conflate.Status]*int64{
+		conflate.MATCH:     new(int64),
+		conflate.AMBIGUOUS: new(int64),
+		conflate.NEW:       new(int64),
+	}
+
+	summary := func(stats *pipeline.Stats) []any {
+		return []any{
+			"processed", stats.Processed,
+			"matched", atomic.LoadInt64(counts[conflate.MATCH]),
+			"ambiguous", atomic.LoadInt64(counts[conflate.AMBIGUOUS]),
+			"new", atomic.LoadInt64(counts[conflate.NEW]),
+			"elapsed", stats.Elapsed,
+		}
+	}
+
+	// The Foursquare IDs of the places matched with each venue, excluding existing concordances
+	matches := make(map[int64][]string)
+
+	// The Who's On First IDs of venues with existing concordances, keyed by Foursquare ID
+	concordances := make(map[string]int64)
+
+	pipeline_opts := &pipeline.Options[*places.Place, *conflate.Result]{
+		Workers: workers,
+		Process: func(ctx context.Context, pl *places.Place) (*conflate.Result, error) {
+			return c.Match(pl), nil
+		},
+		Sink: func(ctx context.Context, rsp *conflate.Result) error {
+
+			atomic.AddInt64(counts[rsp.Status], 1)
+
+			switch {
+			case rsp.Concordance:
+				concordances[rsp.Id] = rsp.WOFId
+			case rsp.Status == conflate.MATCH:
+				matches[rsp.WOFId] = append(matches[rsp.WOFId], rsp.Id)
+			}
+
+			if csv_wr != nil {
+				return csv_wr.WriteRow(resultRow(rsp))
+			}
+
+			return enc.Encode(rsp)
+		},
+		SinkErrors: pipeline.ErrorPolicy{
+			Halt: true,
+		},
+		Progress: func(stats *pipeline.Stats) {
+			slog.Info("Status", summary(stats)...)
+		},
+	}
+
+	// The reason matching stopped early, other than being interrupted
+	var run_err error
+
+	interrupted := false
+
+	stats, err := pipeline.Run(ctx, e.Emit(ctx), pipeline_opts)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Warn("Interrupted, concordances will not be written or recorded since not every place has been matched")
+		interrupted = true
+	case err != nil && stats == nil:
+		log.Fatalf("Failed to run pipeline, %v", err)
+	case err != nil:
+		slog.Error("Stopped matching places", "error", err)
+		run_err = err
+	}
+
+	if csv_wr != nil {
+
+		csv_wr.Flush()
+
+		err := csv_wr.Error()
+
+		if err != nil {
+			log.Fatalf("Failed to write results, %v", err)
+		}
+	}
+
+	slog.Info("Matched places", summary(stats)...)
+
+	// Write and record concordances
+
+	if (writer_uri != "" || ledger_uri != "") && run_err == nil && !interrupted {
+
+		var wr writer.Writer
+		var r reader.Reader
+		var export_opts *export.Options
+
+		var l ledger.Ledger
+
+		if writer_uri != "" {
+
+			if reader_uri != "" {
+
+				r, err = reader.NewReader(process_ctx, reader_uri)
+
+				if err != nil {
+					log.Fatalf("Failed to create reader, %v", err)
+				}
+
+			} else {
+
+				db_r, err := conflate.NewDatabaseReader(process_ctx, venues_database_path)
+
+				if err != nil {
+					log.Fatalf("Failed to create database reader, %v", err)
+				}
+
+				defer db_r.Close()
+				r = db_r
+			}
+
+			wr, err = writer.NewWriter(process_ctx, writer_uri)
+
+			if err != nil {
+				log.Fatalf("Failed to create writer, %v", err)
+			}
+
+			export_opts, err = conflate.NewExportOptions(process_ctx)
+
+			if err != nil {
+				log.Fatalf("Failed to create export options, %v", err)
+			}
+		}
+
+		if ledger_uri != "" {
+
+			l, err = ledger.NewLedger(process_ctx, ledger_uri)
+
+			if err != nil {
+				log.Fatalf("Failed to create ledger, %v", err)
+			}
+
+			defer l.Close()
+		}
+
+		written := 0
+		recorded := 0
+
+		record := func(wof_id int64, fsq_id string) {
+
+			_, err := l.Record(process_ctx, fsq_id, wof_id)
+
+			if errors.Is(err, ledger.ErrConflict) {
+				slog.Warn("Ledger has a conflicting entry, skipping", "wof:id", wof_id, "4sq:id", fsq_id, "error", err)
+				return
+			}
+
+			if err != nil {
+				slog.Error("Failed to record concordance", "wof:id", wof_id, "4sq:id", fsq_id, "error", err)
+				return
+			}
+
+			recorded += 1
+		}
+
+		if l != nil {
+
+			for fsq_id, wof_id := range concordances {
+				record(wof_id, fsq_id)
+			}
+		}
+
+		ids := make([]int64, 0, len(matches))
+
+		for id := range matches {
+			ids = append(ids, id)
+		}
+
+		slices.Sort(ids)
+
+		for _, id := range ids {
+
+			fsq_ids := matches[id]
+
+			if len(fsq_ids) > 1 {
+				slog.Warn("Venue matched more than one place, skipping", "wof:id", id, "4sq:id", strings.Join(fsq_ids, ","))
+				continue
+			}
+
+			if wr != nil {
+
+				ok, err := conflate.WriteConcordance(process_ctx, r, wr, id, fsq_ids[0], export_opts)
+
+				if errors.Is(err, conflate.ErrConflictingConcordance) {
+					slog.Warn("Venue has a concordance for a different place, skipping", "wof:id", id, "4sq:id", fsq_ids[0], "error", err)
+					continue
+				}
+
+				if err != nil {
+					slog.Error("Failed to write concordance", "wof:id", id, "4sq:id", fsq_ids[0], "error", err)
+					continue
+				}
+
+				if ok {
+					written += 1
+				}
+			}
+
+			if l != nil {
+				record(id, fsq_ids[0])
+			}
+		}
+
+		if wr != nil {
+
+			err = wr.Close(process_ctx)
+
+			if err != nil {
+				log.Fatalf("Failed to close writer, %v", err)
+			}
+
+			slog.Info("Wrote concordances", "count", written)
+		}
+
+		if l != nil {
+			slog.Info("Recorded concordances", "count", recorded)
+		}
+	}
+
+	if run_err != nil {
+		log.Fatal(run_err)
+	}
+}
+
+// resultRow returns 'rsp' as a CSV row.
+func resultRow(rsp *conflate.Result) map[string]string {
+
+	candidates := make([]string, len(rsp.Candidates))
+
+	for i, c := range rsp.Candidates {
+		candidates[i] = strconv.FormatInt(c.Id, 10)
+	}
+
+	score := ""
+
+	if len(rsp.Candidates) > 0 {
+		score = strconv.FormatFloat(rsp.Candidates[0].Score.Score, 'f', 4, 64)
+	}
+
+	row := map[string]string{
+		"4sq:id":               rsp.Id,
+		"name":                 rsp.Name,
+		"wof:id":               strconv.FormatInt(rsp.WOFId, 10),
+		"conflate:status":      string(rsp.Status),
+		"conflate:score":       score,
+		"conflate:concordance": strconv.FormatBool(rsp.Concordance),
+		"conflate:candidates":  strings.Join(candidates, ","),
+	}
+
+	return row
+}
@@ -0,0 +1,159 @@
+package main
+
+/*
+
+./bin/dedupe \
+    -emitter-uri csv:///usr/local/data/4sq/4sq-us.csv.bz2 \
+    -format csv \
+    > /usr/local/data/4sq/4sq-us-duplicates.csv
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/sfomuseum/go-csvdict/v2"
+	"github.com/whosonfirst/go-foursquare-places/dedupe"
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+)
+
+func main() {
+
+	var emitter_uri string
+	var format string
+	var verbose bool
+
+	opts := new(dedupe.Options)
+
+	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI used to read the places to deduplicate.")
+	flag.Float64Var(&opts.MaxDistance, "max-distance", dedupe.DEFAULT_MAX_DISTANCE, "The maximum distance, in metres, between places which are compared.")
+	flag.Float64Var(&opts.Threshold, "threshold", dedupe.DEFAULT_THRESHOLD, "The minimum score (0.0 to 1.0) for a pair of places to be considered duplicates.")
+	flag.Float64Var(&opts.MinNameSimilarity, "min-name-similarity", dedupe.DEFAULT_MIN_NAME_SIMILARITY, "The minimum similarity (0.0 to 1.0) of the names of places which are compared.")
+	flag.IntVar(&opts.Workers, "workers", 0, "The number of workers used to compare places. If 0 then the number of CPUs will be used.")
+	flag.StringVar(&format, "format", "ndjson", "The format to write clusters to STDOUT in. Valid options are: ndjson (one JSON-encoded cluster per line), csv (one row per place in a cluster).")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if emitter_uri == "" {
+		log.Fatal("Missing -emitter-uri flag")
+	}
+
+	if format != "ndjson" && format != "csv" {
+		log.Fatalf("Invalid -format flag, must be one of: ndjson, csv")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	e, err := emitter.NewEmitter(ctx, emitter_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create emitter, %v", err)
+	}
+
+	defer e.Close()
+
+	d := dedupe.NewDeduper(opts)
+
+	for pl, err := range e.Emit(ctx) {
+
+		if err != nil {
+			slog.Error("Failed to yield place", "error", err)
+			continue
+		}
+
+		err = d.AddPlace(pl)
+
+		if err != nil {
+			slog.Warn("Failed to add place", "id", pl.Id, "error", err)
+			continue
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Fatalf("Interrupted while reading places, %v", ctx.Err())
+	}
+
+	slog.Info("Comparing places", "count", d.Len())
+
+	clusters, err := d.Clusters(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to derive clusters, %v", err)
+	}
+
+	duplicates := 0
+
+	switch format {
+	case "csv":
+
+		csv_wr, err := csvdict.NewWriter(os.Stdout)
+
+		if err != nil {
+			log.Fatalf("Failed to create CSV writer, %v", err)
+		}
+
+		for _, c := range clusters {
+
+			for _, m := range c.Members {
+
+				row := map[string]string{
+					"4sq:id":               m.Id,
+					"name":                 m.Name,
+					"latitude":             strconv.FormatFloat(m.Latitude, 'f', -1, 64),
+					"longitude":            strconv.FormatFloat(m.Longitude, 'f', -1, 64),
+					"dedupe:cluster":       c.Survivor,
+					"dedupe:cluster_score": strconv.FormatFloat(c.Score, 'f', 4, 64),
+					"dedupe:score":         strconv.FormatFloat(m.Score, 'f', 4, 64),
+					"dedupe:is_survivor":   strconv.FormatBool(m.Id == c.Survivor),
+				}
+
+				err := csv_wr.WriteRow(row)
+
+				if err != nil {
+					log.Fatalf("Failed to write row, %v", err)
+				}
+			}
+
+			duplicates += len(c.Members) - 1
+		}
+
+		csv_wr.Flush()
+
+		err = csv_wr.Error()
+
+		if err != nil {
+			log.Fatalf("Failed to write clusters, %v", err)
+		}
+
+	default:
+
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, c := range clusters {
+
+			err := enc.Encode(c)
+
+			if err != nil {
+				log.Fatalf("Failed to encode cluster, %v", err)
+			}
+
+			duplicates += len(c.Members) - 1
+		}
+	}
+
+	slog.Info("Summary", "places", d.Len(), "clusters", len(clusters), "duplicates", duplicates)
+}
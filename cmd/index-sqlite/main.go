@@ -0,0 +1,115 @@
+package main
+
+/*
+
+./bin/index-sqlite \
+    -database-path /usr/local/data/4sq/4sq.db \
+    -emitter-uri csv:///usr/local/data/4sq/4sq.csv.bz2
+
+*/
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+	"github.com/whosonfirst/go-foursquare-places/sqlite"
+)
+
+func main() {
+
+	var database_path string
+	var emitter_uri string
+	var batch_size int
+	var optimize bool
+	var verbose bool
+
+	flag.StringVar(&database_path, "database-path", "", "The path to the SQLite database to load places in to. It will be created if it does not already exist.")
+	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI.")
+	flag.IntVar(&batch_size, "batch-size", sqlite.DEFAULT_BATCH_SIZE, "The number of places to write in a single database transaction.")
+	flag.BoolVar(&optimize, "optimize", true, "Optimize the full-text index and update query planner statistics once all the places have been loaded.")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if database_path == "" {
+		log.Fatal("Missing -database-path flag")
+	}
+
+	if emitter_uri == "" {
+		log.Fatal("Missing -emitter-uri flag")
+	}
+
+	// Stop reading new places when interrupted but still write the ones which have been read
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := sqlite.NewDatabase(ctx, database_path)
+
+	if err != nil {
+		log.Fatalf("Failed to create database, %v", err)
+	}
+
+	defer db.Close()
+
+	e, err := emitter.NewEmitter(ctx, emitter_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create emitter, %v", err)
+	}
+
+	defer e.Close()
+
+	t1 := time.Now()
+	last_progress := time.Now()
+
+	opts := &sqlite.LoadOptions{
+		BatchSize: batch_size,
+		Progress: func(stats *sqlite.LoadStats) {
+
+			if time.Since(last_progress) < 10*time.Second {
+				return
+			}
+
+			slog.Info("Status", "added", stats.Added, "updated", stats.Updated, "unchanged", stats.Unchanged, "errors", stats.Errors, "elapsed", time.Since(t1))
+			last_progress = time.Now()
+		},
+	}
+
+	stats, err := db.Load(ctx, e.Emit(ctx), opts)
+
+	slog.Info("Summary", "added", stats.Added, "updated", stats.Updated, "unchanged", stats.Unchanged, "errors", stats.Errors, "elapsed", time.Since(t1))
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Warn("Interrupted, the places read so far have been written and loading can be resumed by running the same command again")
+		return
+	case err != nil:
+		db.Close()
+		log.Fatalf("Failed to load places, %v", err)
+	}
+
+	if optimize {
+
+		slog.Info("Optimize database")
+
+		err = db.Optimize(ctx)
+
+		if err != nil {
+			db.Close()
+			log.Fatalf("Failed to optimize database, %v", err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+/*
+
+./bin/ledger \
+    -ledger-uri 'sqlite:///usr/local/data/4sq/ledger.db?provider=local://' \
+    -mode assign \
+    4aee4d4688a04abe82d1ace4
+
+./bin/ledger \
+    -ledger-uri sqlite:///usr/local/data/4sq/ledger.db \
+    -mode merge \
+    -into 4aee4d4688a04abe82d1ace4 \
+    cb57d89eed29405b908b0b6e
+
+comm -13 ids-new.txt ids-old.txt | ./bin/ledger \
+    -ledger-uri sqlite:///usr/local/data/4sq/ledger.db \
+    -mode retire \
+    -
+
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/whosonfirst/go-foursquare-places/ledger"
+)
+
+func main() {
+
+	var ledger_uri string
+	var mode string
+	var into string
+	var verbose bool
+
+	flag.StringVar(&ledger_uri, "ledger-uri", "", "A registered whosonfirst/go-foursquare-places/ledger.Ledger URI.")
+	flag.StringVar(&mode, "mode", "lookup", "The operation to perform for each ID passed as an argument. Valid options are: lookup (look up the entry for a Foursquare ID), lookup-wof (look up the entry for a Who's On First ID), assign (look up the entry for a Foursquare ID, allocating a new Who's On First ID if necessary), merge (merge a Foursquare ID in to the place defined by the -into flag), retire (retire a Foursquare ID).")
+	flag.StringVar(&into, "into", "", "The Foursquare ID of the place to merge places in to. Required if -mode is merge.")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if ledger_uri == "" {
+		log.Fatal("Missing -ledger-uri flag")
+	}
+
+	var op func(context.Context, ledger.Ledger, string) (*ledger.Entry, error)
+
+	switch mode {
+	case "lookup":
+		op = func(ctx context.Context, l ledger.Ledger, id string) (*ledger.Entry, error) {
+			return l.Lookup(ctx, id)
+		}
+	case "lookup-wof":
+		op = func(ctx context.Context, l ledger.Ledger, id string) (*ledger.Entry, error) {
+
+			wof_id, err := strconv.ParseInt(id, 10, 64)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return l.LookupWOFId(ctx, wof_id)
+		}
+	case "assign":
+		op = func(ctx context.Context, l ledger.Ledger, id string) (*ledger.Entry, error) {
+			return l.Assign(ctx, id)
+		}
+	case "merge":
+
+		if into == "" {
+			log.Fatal("Missing -into flag")
+		}
+
+		op = func(ctx context.Context, l ledger.Ledger, id string) (*ledger.Entry, error) {
+			return l.Merge(ctx, id, into)
+		}
+	case "retire":
+		op = func(ctx context.Context, l ledger.Ledger, id string) (*ledger.Entry, error) {
+			return l.Retire(ctx, id)
+		}
+	default:
+		log.Fatalf("Invalid -mode flag, must be one of: lookup, lookup-wof, assign, merge, retire")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	l, err := ledger.NewLedger(ctx, ledger_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create ledger, %v", err)
+	}
+
+	defer l.Close()
+
+	// IDs are read from STDIN, one per line, if the only argument is "-"
+
+	ids := func(yield func(string) bool) {
+
+		args := flag.Args()
+
+		if len(args) != 1 || args[0] != "-" {
+
+			for _, id := range args {
+
+				if !yield(id) {
+					return
+				}
+			}
+
+			return
+		}
+
+		scanner := bufio.NewScanner(os.Stdin)
+
+		for scanner.Scan() {
+
+			id := strings.TrimSpace(scanner.Text())
+
+			if id == "" {
+				continue
+			}
+
+			if !yield(id) {
+				return
+			}
+		}
+
+		err := scanner.Err()
+
+		if err != nil {
+			log.Fatalf("Failed to read IDs, %v", err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := 0
+
+	for id := range ids {
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		e, err := op(ctx, l, id)
+
+		if err != nil {
+			slog.Error("Failed to "+mode+" ID", "id", id, "error", err)
+			failed += 1
+			continue
+		}
+
+		err = enc.Encode(e)
+
+		if err != nil {
+			log.Fatalf("Failed to encode entry, %v", err)
+		}
+	}
+
+	if failed > 0 {
+		log.Fatalf("Failed to %s %d ID(s)", mode, failed)
+	}
+}
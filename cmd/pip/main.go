@@ -0,0 +1,234 @@
+package main
+
+/*
+
+./bin/pip \
+    -spatial-database-uri 'pmtiles://?tiles=file:///usr/local/data/pmtiles/&database=whosonfirst-point-in-polygon-z13-20240406&enable-cache=true&pmtiles-cache-size=4096&zoom=13&layer=whosonfirst' \
+    -latitude 37.61686 \
+    -longitude -122.38362
+
+./bin/pip \
+    -spatial-database-uri 'pmtiles://?tiles=file:///usr/local/data/pmtiles/&database=whosonfirst-point-in-polygon-z13-20240406&enable-cache=true&pmtiles-cache-size=4096&zoom=13&layer=whosonfirst' \
+    -emitter-uri csv:///usr/local/data/4sq/4sq.csv.bz2 \
+    -id cb57d89eed29405b908b0b6e
+
+./bin/pip \
+    -spatial-database-uri 'pmtiles://?tiles=file:///usr/local/data/pmtiles/&database=whosonfirst-point-in-polygon-z13-20240406&enable-cache=true&pmtiles-cache-size=4096&zoom=13&layer=whosonfirst' \
+    -index-path /usr/local/data/4sq/4sq-index.db \
+    -id cb57d89eed29405b908b0b6e
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/whosonfirst/go-reader-database-sql"
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-pmtiles"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+	"github.com/whosonfirst/go-foursquare-places/index"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// ancestor is the subset of properties for a Who's On First record used to describe the hierarchy of a parent.
+type ancestor struct {
+	Id        int64  `json:"wof:id"`
+	Name      string `json:"wof:name"`
+	Placetype string `json:"wof:placetype"`
+}
+
+// pipResponse is the JSON-encoded output of the pip tool.
+type pipResponse struct {
+	Place       *places.Place              `json:"place"`
+	Candidates  []spr.StandardPlacesResult `json:"candidates"`
+	Result      *reversegeo.Result         `json:"result"`
+	Hierarchies []map[string]*ancestor     `json:"hierarchies"`
+}
+
+func main() {
+
+	var latitude float64
+	var longitude float64
+
+	var id string
+	var emitter_uri string
+	var index_path string
+
+	var verbose bool
+
+	flag.Float64Var(&latitude, "latitude", 0.0, "The latitude of the point to query. Ignored if -id is set.")
+	flag.Float64Var(&longitude, "longitude", 0.0, "The longitude of the point to query. Ignored if -id is set.")
+
+	flag.StringVar(&id, "id", "", "The Foursquare ID of a place to query. If set the place will be looked up using the -index-path or -emitter-uri flags.")
+	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI used to look up the place defined by the -id flag. Since this means scanning every place until a match is found it is much slower than using -index-path.")
+	flag.StringVar(&index_path, "index-path", "", "The path to an index database created by the build-index tool used to look up the place defined by the -id flag.")
+
+	resolver_flags := reversegeo.AppendResolverFlags(flag.CommandLine)
+
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	// See notes in cmd/reverse-geocode/main.go
+
+	var c = jsoniter.Config{
+		EscapeHTML:              true,
+		SortMapKeys:             false,
+		MarshalFloatWith6Digits: true,
+	}.Froze()
+
+	geojson.CustomJSONMarshaler = c
+	geojson.CustomJSONUnmarshaler = c
+
+	ctx := context.Background()
+
+	var pl *places.Place
+
+	switch {
+	case id != "" && index_path != "":
+
+		idx, err := index.NewIndex(ctx, index_path)
+
+		if err != nil {
+			log.Fatalf("Failed to create index, %v", err)
+		}
+
+		defer idx.Close()
+
+		pl, err = idx.GetPlace(ctx, id)
+
+		if err != nil {
+			log.Fatalf("Failed to find place %s, %v", id, err)
+		}
+
+	case id != "":
+
+		if emitter_uri == "" {
+			log.Fatal("Missing -index-path or -emitter-uri flag")
+		}
+
+		e, err := emitter.NewEmitter(ctx, emitter_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to create emitter, %v", err)
+		}
+
+		defer e.Close()
+
+		for candidate, err := range e.Emit(ctx) {
+
+			if err != nil {
+				slog.Warn("Failed to yield place", "error", err)
+				continue
+			}
+
+			if candidate.Id == id {
+				pl = candidate
+				break
+			}
+		}
+
+		if pl == nil {
+			log.Fatalf("Failed to find place %s", id)
+		}
+
+	default:
+
+		pl = &places.Place{
+			Latitude:  latitude,
+			Longitude: longitude,
+		}
+	}
+
+	resolver, err := resolver_flags.NewResolver(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to create resolver, %v", err)
+	}
+
+	defer resolver_flags.Close(ctx)
+
+	candidates, err := resolver.Candidates(ctx, pl)
+
+	if err != nil {
+		log.Fatalf("Failed to derive candidates, %v", err)
+	}
+
+	rsp, err := resolver.ResolvePlace(ctx, pl)
+
+	if err != nil {
+		log.Fatalf("Failed to resolve place, %v", err)
+	}
+
+	// Look up the names and placetypes of each of the parent's ancestors
+
+	properties_reader := resolver.PropertiesReader()
+	ancestors := make(map[int64]*ancestor)
+
+	hierarchies := make([]map[string]*ancestor, len(rsp.Hierarchies))
+
+	for i, h := range rsp.Hierarchies {
+
+		hierarchies[i] = make(map[string]*ancestor)
+
+		for k, ancestor_id := range h {
+
+			a, exists := ancestors[ancestor_id]
+
+			if !exists {
+
+				a = &ancestor{
+					Id: ancestor_id,
+				}
+
+				if ancestor_id > 0 {
+
+					body, err := wof_reader.LoadBytes(ctx, properties_reader, ancestor_id)
+
+					if err != nil {
+						slog.Warn("Failed to read ancestor", "id", ancestor_id, "error", err)
+					} else {
+						a.Name, _ = properties.Name(body)
+						a.Placetype, _ = properties.Placetype(body)
+					}
+				}
+
+				ancestors[ancestor_id] = a
+			}
+
+			hierarchies[i][k] = a
+		}
+	}
+
+	pip_rsp := &pipResponse{
+		Place:       pl,
+		Candidates:  candidates,
+		Result:      rsp,
+		Hierarchies: hierarchies,
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(pip_rsp)
+
+	if err != nil {
+		log.Fatalf("Failed to encode response, %v", err)
+	}
+}
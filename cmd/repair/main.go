@@ -0,0 +1,376 @@
+package main
+
+/*
+
+./bin/repair \
+    -results-path /usr/local/data/4sq/4sq-wof.csv \
+    -index-path /usr/local/data/4sq/4sq-index.db \
+    -spatial-database-uri 'pmtiles://?tiles=file:///usr/local/data/pmtiles/&database=whosonfirst-point-in-polygon-z13-20250101&enable-cache=true&pmtiles-cache-size=4096&zoom=13&layer=whosonfirst' \
+    -unmatched \
+    > /usr/local/data/4sq/4sq-wof-repaired.csv
+
+*/
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"iter"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/whosonfirst/go-reader-database-sql"
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-pmtiles"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/paulmach/orb/geojson"
+	"github.com/sfomuseum/go-csvdict/v2"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+	"github.com/whosonfirst/go-foursquare-places/index"
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+	"github.com/whosonfirst/go-foursquare-places/repair"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+)
+
+func main() {
+
+	var results_path string
+	var emitter_uri string
+	var index_path string
+	var workers int
+	var max_errors int64
+	var verbose bool
+
+	selector := &repair.Selector{
+		Predicates: make([]*repair.Predicate, 0),
+	}
+
+	flag.StringVar(&results_path, "results-path", "", "The path to a CSV file produced by the reverse-geocode tool.")
+	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI used to read the places to repair. Since this means scanning every place until all the selected places have been found it is much slower than using -index-path.")
+	flag.StringVar(&index_path, "index-path", "", "The path to an index database created by the build-index tool used to look up the places to repair.")
+	flag.IntVar(&workers, "workers", 5, "The maximum number of workers to process reverse geocoding tasks.")
+	flag.Int64Var(&max_errors, "max-errors", 0, "If > 0 then stop processing once more than 'max_errors' places have failed to be reverse geocoded.")
+
+	flag.BoolVar(&selector.Unmatched, "unmatched", false, "Repair rows which were not matched to a parent (wof:parent_id=-1).")
+	flag.BoolVar(&selector.Failed, "failed", false, "Repair rows which failed to be reverse geocoded (reversegeo:reason=error). This requires that the results were produced with the -with-metadata flag.")
+
+	flag.Func("where", "Repair rows matching a {COLUMN}={VALUE} or {COLUMN}!={VALUE} predicate, for example wof:parent_id=85865899. May be passed multiple times.", func(str string) error {
+
+		p, err := repair.ParsePredicate(str)
+
+		if err != nil {
+			return err
+		}
+
+		selector.Predicates = append(selector.Predicates, p)
+		return nil
+	})
+
+	resolver_flags := reversegeo.AppendResolverFlags(flag.CommandLine)
+
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if results_path == "" {
+		log.Fatal("Missing -results-path flag")
+	}
+
+	if index_path == "" && emitter_uri == "" {
+		log.Fatal("Missing -index-path or -emitter-uri flag")
+	}
+
+	if selector.IsZero() {
+		log.Fatal("Nothing to repair, please specify one or more of the -unmatched, -failed or -where flags")
+	}
+
+	// See notes in cmd/reverse-geocode/main.go
+
+	var c = jsoniter.Config{
+		EscapeHTML:              true,
+		SortMapKeys:             false,
+		MarshalFloatWith6Digits: true,
+	}.Froze()
+
+	geojson.CustomJSONMarshaler = c
+	geojson.CustomJSONUnmarshaler = c
+
+	// Stop emitting new places when interrupted but still write a patched copy of the
+	// results with the places which were repaired before that.
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	process_ctx := context.WithoutCancel(ctx)
+
+	// Select the rows to repair and record the columns in the results file
+
+	selected := make(map[string]bool)
+	columns := make(map[string]bool)
+
+	results_r, err := csvdict.NewReaderFromPath(results_path)
+
+	if err != nil {
+		log.Fatalf("Failed to open results, %v", err)
+	}
+
+	for row, err := range results_r.Iterate() {
+
+		if err != nil {
+			log.Fatalf("Failed to read results, %v", err)
+		}
+
+		if len(columns) == 0 {
+
+			for k, _ := range row {
+				columns[k] = true
+			}
+		}
+
+		if selector.Match(row) {
+			selected[row["4sq:id"]] = true
+		}
+	}
+
+	slog.Info("Selected rows to repair", "count", len(selected))
+
+	if len(selected) == 0 {
+		slog.Info("Nothing to repair")
+	}
+
+	// Reverse-geocode the selected places
+
+	repaired := make(map[string]map[string]string)
+
+	// The reason processing stopped early, other than being interrupted
+	var run_err error
+
+	if len(selected) > 0 {
+
+		resolver, err := resolver_flags.NewResolver(process_ctx)
+
+		if err != nil {
+			log.Fatalf("Failed to create resolver, %v", err)
+		}
+
+		defer func() {
+
+			err := resolver_flags.Close(process_ctx)
+
+			if err != nil {
+				slog.Error("Failed to close resolver", "error", err)
+			}
+		}()
+
+		row_opts := resolver_flags.RowOptions()
+
+		found := int64(0)
+
+		var places_seq iter.Seq2[*places.Place, error]
+
+		if index_path != "" {
+
+			idx, err := index.NewIndex(ctx, index_path)
+
+			if err != nil {
+				log.Fatalf("Failed to create index, %v", err)
+			}
+
+			defer idx.Close()
+
+			// Look up the selected places, in a stable order, by ID
+
+			ids := make([]string, 0, len(selected))
+
+			for id, _ := range selected {
+				ids = append(ids, id)
+			}
+
+			sort.Strings(ids)
+
+			places_seq = func(yield func(*places.Place, error) bool) {
+
+				for _, id := range ids {
+
+					if ctx.Err() != nil {
+						return
+					}
+
+					pl, err := idx.GetPlace(ctx, id)
+
+					if errors.Is(err, index.ErrNotFound) {
+						continue
+					}
+
+					if err == nil {
+						atomic.AddInt64(&found, 1)
+					} else {
+						err = fmt.Errorf("Failed to read %s, %w", id, err)
+					}
+
+					if !yield(pl, err) {
+						return
+					}
+				}
+			}
+
+		} else {
+
+			e, err := emitter.NewEmitter(ctx, emitter_uri)
+
+			if err != nil {
+				log.Fatalf("Failed to create emitter, %v", err)
+			}
+
+			defer e.Close()
+
+			places_seq = func(yield func(*places.Place, error) bool) {
+
+				for pl, err := range e.Emit(ctx) {
+
+					if err == nil && !selected[pl.Id] {
+						continue
+					}
+
+					if err == nil {
+						atomic.AddInt64(&found, 1)
+					}
+
+					if !yield(pl, err) {
+						return
+					}
+
+					// Stop reading once all the selected places have been found
+
+					if atomic.LoadInt64(&found) == int64(len(selected)) {
+						return
+					}
+				}
+			}
+		}
+
+		pipeline_opts := &pipeline.Options[*places.Place, *reversegeo.Result]{
+			Workers: workers,
+			Process: func(ctx context.Context, pl *places.Place) (*reversegeo.Result, error) {
+
+				rsp, err := resolver.ResolvePlace(ctx, pl)
+
+				if err != nil {
+					return nil, fmt.Errorf("Failed to resolve %s, %w", pl.Id, err)
+				}
+
+				return rsp, nil
+			},
+			// Rows which fail to be reverse geocoded (again) are left as-is
+			Sink: func(ctx context.Context, rsp *reversegeo.Result) error {
+				repaired[rsp.Id] = rsp.RowWithOptions(row_opts)
+				return nil
+			},
+			ProcessErrors: pipeline.ErrorPolicy{
+				MaxErrors: max_errors,
+			},
+			Progress: func(stats *pipeline.Stats) {
+				slog.Info("Status", "selected", len(selected), "found", atomic.LoadInt64(&found), "processed", stats.Processed, "errors", stats.ProcessErrors, "elapsed", stats.Elapsed)
+			},
+		}
+
+		stats, err := pipeline.Run(ctx, places_seq, pipeline_opts)
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			slog.Warn("Interrupted, writing results with the places that have been repaired so far")
+		case err != nil && stats == nil:
+			log.Fatalf("Failed to run pipeline, %v", err)
+		case err != nil:
+			slog.Error("Stopped repairing places, writing results with the places that have been repaired so far", "error", err)
+			run_err = err
+		}
+
+		slog.Info("Repaired", "selected", len(selected), "found", atomic.LoadInt64(&found), "processed", stats.Processed, "errors", stats.ProcessErrors, "elapsed", stats.Elapsed)
+
+		if ctx.Err() == nil && atomic.LoadInt64(&found) < int64(len(selected)) {
+			slog.Warn("Some selected places were not found", "count", int64(len(selected))-atomic.LoadInt64(&found))
+		}
+	}
+
+	// Write a patched copy of the results, in their original order, to STDOUT
+
+	for _, row := range repaired {
+
+		for k, _ := range row {
+			columns[k] = true
+		}
+	}
+
+	column_names := make([]string, 0, len(columns))
+
+	for k, _ := range columns {
+		column_names = append(column_names, k)
+	}
+
+	sort.Strings(column_names)
+
+	results_r, err = csvdict.NewReaderFromPath(results_path)
+
+	if err != nil {
+		log.Fatalf("Failed to open results, %v", err)
+	}
+
+	csv_wr, err := csvdict.NewWriter(os.Stdout)
+
+	if err != nil {
+		log.Fatalf("Failed to create CSV writer, %v", err)
+	}
+
+	matched := 0
+
+	for row, err := range results_r.Iterate() {
+
+		if err != nil {
+			log.Fatalf("Failed to read results, %v", err)
+		}
+
+		repaired_row, exists := repaired[row["4sq:id"]]
+
+		if exists {
+
+			row = repaired_row
+
+			if row["wof:parent_id"] != "-1" {
+				matched += 1
+			}
+		}
+
+		err = csv_wr.WriteRow(repair.Fill(row, column_names))
+
+		if err != nil {
+			log.Fatalf("Failed to write row, %v", err)
+		}
+	}
+
+	csv_wr.Flush()
+
+	err = csv_wr.Error()
+
+	if err != nil {
+		log.Fatalf("Failed to write results, %v", err)
+	}
+
+	slog.Info("Summary", "selected", len(selected), "repaired", len(repaired), "matched", matched)
+
+	if run_err != nil {
+		log.Fatal(run_err)
+	}
+}
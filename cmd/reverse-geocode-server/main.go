@@ -0,0 +1,151 @@
+package main
+
+/*
+
+./bin/reverse-geocode-server \
+    -server-address localhost:8080 \
+    -spatial-database-uri 'pmtiles://?tiles=file:///usr/local/data/pmtiles/&database=whosonfirst-point-in-polygon-z13-20240406&enable-cache=true&pmtiles-cache-size=4096&zoom=13&layer=whosonfirst'
+
+$> curl -s -X POST -d '{"lat": 37.61686, "lon": -122.38362}' http://localhost:8080/reverse-geocode
+
+$> cat places.ndjson | curl -s -X POST --data-binary @- http://localhost:8080/reverse-geocode/batch
+
+*/
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/whosonfirst/go-reader-database-sql"
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-pmtiles"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places/api"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+	"github.com/whosonfirst/go-foursquare-places/tracing"
+)
+
+func main() {
+
+	var server_address string
+	var max_body_size int64
+	var verbose bool
+
+	flag.StringVar(&server_address, "server-address", "localhost:8080", "The address (host and port) the server should listen for requests on.")
+	flag.Int64Var(&max_body_size, "max-body-size", api.DEFAULT_MAX_BODY_SIZE, "The maximum size, in bytes, of the body of a batch request. If less than 0 the size of the body is not limited.")
+
+	resolver_flags := reversegeo.AppendResolverFlags(flag.CommandLine)
+	tracing_flags := tracing.AppendTracingFlags(flag.CommandLine)
+
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	// See notes in cmd/reverse-geocode/main.go
+
+	var c = jsoniter.Config{
+		EscapeHTML:              true,
+		SortMapKeys:             false,
+		MarshalFloatWith6Digits: true,
+	}.Froze()
+
+	geojson.CustomJSONMarshaler = c
+	geojson.CustomJSONUnmarshaler = c
+
+	ctx := context.Background()
+
+	err := tracing_flags.SetTracerProvider(ctx, "reverse-geocode-server")
+
+	if err != nil {
+		log.Fatalf("Failed to set up tracing, %v", err)
+	}
+
+	defer func() {
+
+		err := tracing_flags.Shutdown(ctx)
+
+		if err != nil {
+			slog.Error("Failed to shut down tracing", "error", err)
+		}
+	}()
+
+	resolver, err := resolver_flags.NewResolver(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to create resolver, %v", err)
+	}
+
+	defer func() {
+
+		err := resolver_flags.Close(ctx)
+
+		if err != nil {
+			slog.Error("Failed to close resolver", "error", err)
+		}
+	}()
+
+	handler_opts := &api.ReverseGeocodeHandlerOptions{
+		Resolver:    resolver,
+		MaxBodySize: max_body_size,
+	}
+
+	rg_handler, err := api.ReverseGeocodeHandler(handler_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to create reverse geocode handler, %v", err)
+	}
+
+	batch_handler, err := api.BatchReverseGeocodeHandler(handler_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to create batch reverse geocode handler, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/reverse-geocode", rg_handler)
+	mux.Handle("/reverse-geocode/batch", batch_handler)
+
+	server := &http.Server{
+		Addr:    server_address,
+		Handler: mux,
+	}
+
+	signal_ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done_ch := make(chan error, 1)
+
+	go func() {
+
+		<-signal_ctx.Done()
+
+		slog.Info("Shutting down, waiting for in-flight requests to complete")
+		done_ch <- server.Shutdown(ctx)
+	}()
+
+	slog.Info("Listening for requests", "address", server_address)
+
+	err = server.ListenAndServe()
+
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to serve requests, %v", err)
+	}
+
+	err = <-done_ch
+
+	if err != nil {
+		slog.Error("Failed to shut down server", "error", err)
+	}
+}
@@ -13,63 +13,133 @@ package main
 */
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
-	"strconv"
-	"strings"
-	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/whosonfirst/go-reader-database-sql"
 	_ "github.com/whosonfirst/go-whosonfirst-spatial-pmtiles"
 
-	"github.com/dgraph-io/ristretto/v2"
 	jsoniter "github.com/json-iterator/go"
-	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"github.com/sfomuseum/go-csvdict/v2"
 	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/checkpoint"
 	"github.com/whosonfirst/go-foursquare-places/emitter"
-	"github.com/whosonfirst/go-reader"
-	"github.com/whosonfirst/go-whosonfirst-feature/properties"
-	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
-	"github.com/whosonfirst/go-whosonfirst-spatial/database"
-	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
-	"github.com/whosonfirst/go-whosonfirst-spatial/hierarchy"
-	hierarchy_filter "github.com/whosonfirst/go-whosonfirst-spatial/hierarchy/filter"
+	"github.com/whosonfirst/go-foursquare-places/metrics"
+	"github.com/whosonfirst/go-foursquare-places/order"
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+	"github.com/whosonfirst/go-foursquare-places/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func main() {
+// resolvedPlace is a place and the result of reverse geocoding it.
+type resolvedPlace struct {
+	place  *places.Place
+	result *reversegeo.Result
+	// The span tracing the place from the time it is processed until it is written
+	span trace.Span
+}
+
+// skipHeaderWriter is an `io.Writer` which discards everything up to and including the first newline written to
+// it. It is used to append rows to existing CSV files without writing another header.
+type skipHeaderWriter struct {
+	writer  io.Writer
+	skipped bool
+}
+
+func (w *skipHeaderWriter) Write(p []byte) (int, error) {
+
+	if w.skipped {
+		return w.writer.Write(p)
+	}
 
-	var spatial_database_uri string
-	var properties_reader_uri string
+	i := bytes.IndexByte(p, '\n')
+
+	if i == -1 {
+		return len(p), nil
+	}
+
+	w.skipped = true
+
+	_, err := w.writer.Write(p[i+1:])
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+var tracer = otel.Tracer("github.com/whosonfirst/go-foursquare-places/cmd/reverse-geocode")
+
+func main() {
 
 	var emitter_uri string
 	var workers int
+	var max_errors int64
+
+	var ordered bool
+	var reorder_buffer_size int
+	var sort_by_id bool
 	var start_after int64
 
+	var checkpoint_path string
+	var resume bool
+
 	var profile bool
 	var mem_profile string
 	var cpu_profile string
 
 	var verbose bool
+	var tile_cache_stats bool
 
-	flag.StringVar(&spatial_database_uri, "spatial-database-uri", "", "A registered whosonfirst/go-whosonfirst-spatial/database/SpatialDatabase URI to use for perforning reverse geocoding tasks.")
+	var country_mismatches_path string
 
-	flag.StringVar(&properties_reader_uri, "properties-reader-uri", "{spatial-database-uri}", "...")
+	var metrics_addr string
 
 	flag.StringVar(&emitter_uri, "emitter-uri", "", "A registered whosonfirst/go-foursquare-places/emitter.Emitter URI.")
 	flag.IntVar(&workers, "workers", 5, "The maximum number of workers to process reverse geocoding tasks.")
 
+	flag.Int64Var(&max_errors, "max-errors", 0, "If > 0 then stop processing once more than 'max_errors' places have failed to be reverse geocoded.")
+
+	flag.BoolVar(&ordered, "ordered", false, "Write results in the same order that places are emitted, rather than the order they finish processing in.")
+	flag.IntVar(&reorder_buffer_size, "reorder-buffer-size", 0, "The maximum number of places which have been emitted but not yet written. When -ordered is enabled this bounds the number of results held in memory waiting for a slower place to finish. If less than -workers then twice the number of workers will be used.")
+	flag.BoolVar(&sort_by_id, "sort-by-id", false, "Sort results by their 4sq:id column before writing them. Results are sorted out-of-core, using temporary files, and nothing is written until all places have been processed.")
+
 	flag.Int64Var(&start_after, "start-after", 0, "If > 0 then delay processing for 'start_after' number of records.")
 
+	flag.StringVar(&checkpoint_path, "checkpoint-path", "", "An optional path to write a JSON-encoded checkpoint to when processing completes or is interrupted (by SIGINT or SIGTERM). The checkpoint records the -start-after value needed to resume processing.")
+	flag.BoolVar(&resume, "resume", false, "Derive the -start-after value from the checkpoint written to -checkpoint-path by a previous run.")
+
+	flag.BoolVar(&tile_cache_stats, "tile-cache-stats", false, "Report the hit rate of the directory cache for a PMTiles spatial database, as recorded by the protomaps/go-pmtiles package. The size of that cache is set by the ?pmtiles-cache-size= parameter in the -spatial-database-uri flag and is measured in megabytes.")
+
+	resolver_flags := reversegeo.AppendResolverFlags(flag.CommandLine)
+
+	flag.StringVar(&country_mismatches_path, "country-mismatches-path", "", "An optional path to write a CSV report of places whose country disagrees with their parent's country ancestors. If -resume is set the report is appended to. Implies -check-country.")
+
+	tracing_flags := tracing.AppendTracingFlags(flag.CommandLine)
+
+	flag.StringVar(&metrics_addr, "metrics-addr", "", "An optional address (host and port) to serve Prometheus metrics (from /metrics) and net/http/pprof profiles (from /debug/pprof/) on while processing.")
+
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
 	flag.BoolVar(&profile, "profile", false, "Enable pprof profiling.")
 
@@ -127,306 +197,498 @@ func main() {
 
 	// END OF json wah-wah...
 
-	ctx := context.Background()
+	// Stop emitting new places when interrupted but let the places already being
+	// processed finish (see process_ctx below) so their results are written.
 
-	e, err := emitter.NewEmitter(ctx, emitter_uri)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer e.Close()
+	process_ctx := context.WithoutCancel(ctx)
 
-	spatial_db, err := database.NewSpatialDatabase(ctx, spatial_database_uri)
+	err := tracing_flags.SetTracerProvider(process_ctx, "reverse-geocode")
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Failed to set up tracing, %v", err)
 	}
 
-	defer spatial_db.Disconnect(ctx)
-
-	var properties_reader reader.Reader
-	properties_reader = spatial_db
+	// Flush any remaining spans once everything else (deferred) has finished
 
-	if properties_reader_uri != "{spatial-database-uri}" {
+	defer func() {
 
-		r, err := reader.NewReader(ctx, properties_reader_uri)
+		err := tracing_flags.Shutdown(process_ctx)
 
 		if err != nil {
-			log.Fatal(err)
+			slog.Error("Failed to shut down tracing", "error", err)
 		}
+	}()
 
-		properties_reader = r
+	if metrics_addr != "" {
+
+		metrics_server := &http.Server{
+			Addr:    metrics_addr,
+			Handler: metrics.NewServeMux(),
+		}
+
+		go func() {
+
+			slog.Info("Serving metrics", "address", metrics_addr)
+
+			err := metrics_server.ListenAndServe()
+
+			if err != nil && err != http.ErrServerClosed {
+				slog.Error("Failed to serve metrics", "error", err)
+			}
+		}()
+
+		defer metrics_server.Shutdown(process_ctx)
 	}
 
-	inputs := &filter.SPRInputs{}
-	inputs.IsCurrent = []int64{1}
+	if resume {
+
+		if checkpoint_path == "" {
+			log.Fatal("The -resume flag requires a -checkpoint-path flag")
+		}
+
+		cp, err := checkpoint.Read(checkpoint_path)
 
-	results_cb := hierarchy_filter.FirstButForgivingSPRResultsFunc
+		if err != nil {
+			log.Fatalf("Failed to read checkpoint, %v", err)
+		}
+
+		if cp.Complete {
+			slog.Info("Checkpoint is complete, nothing to resume", "path", checkpoint_path)
+			return
+		}
 
-	resolver_opts := &hierarchy.PointInPolygonHierarchyResolverOptions{
-		Database: spatial_db,
+		if cp.Source != emitter_uri {
+			log.Fatalf("Checkpoint was written for a different emitter URI (%s)", cp.Source)
+		}
+
+		start_after = cp.StartAfter
+		slog.Info("Resume from checkpoint", "path", checkpoint_path, "start after", start_after)
 	}
 
-	resolver, err := hierarchy.NewPointInPolygonHierarchyResolver(ctx, resolver_opts)
+	e, err := emitter.NewEmitter(ctx, emitter_uri)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mu := new(sync.RWMutex)
-	wg := new(sync.WaitGroup)
+	defer e.Close()
+
+	if country_mismatches_path != "" {
+		flag.Set("check-country", "true")
+	}
+
+	var mismatches_wr *csvdict.Writer
+
+	if country_mismatches_path != "" {
+
+		// When resuming the mismatches found by the previous run(s) are kept and new ones appended to them
+
+		mismatches_flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+		if resume {
+			mismatches_flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+
+		mismatches_fh, err := os.OpenFile(country_mismatches_path, mismatches_flags, 0644)
+
+		if err != nil {
+			log.Fatalf("Failed to create country mismatches report, %v", err)
+		}
+
+		defer mismatches_fh.Close()
+
+		mismatches_info, err := mismatches_fh.Stat()
+
+		if err != nil {
+			log.Fatalf("Failed to stat country mismatches report, %v", err)
+		}
+
+		var mismatches_w io.Writer = mismatches_fh
+
+		if mismatches_info.Size() > 0 {
+			mismatches_w = &skipHeaderWriter{writer: mismatches_fh}
+		}
 
-	throttle := make(chan bool, workers)
+		wr, err := csvdict.NewWriter(mismatches_w)
 
-	for i := 0; i < workers; i++ {
-		throttle <- true
+		if err != nil {
+			log.Fatalf("Failed to create country mismatches writer, %v", err)
+		}
+
+		mismatches_wr = wr
 	}
 
-	parent_cache, err := ristretto.NewCache(&ristretto.Config[string, string]{
-		NumCounters: 1e7,     // number of keys to track frequency of (10M).
-		MaxCost:     1 << 30, // maximum cost of cache (1GB).
-		BufferItems: 64,      // number of keys per Get buffer.
-	})
+	resolver, err := resolver_flags.NewResolver(process_ctx)
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Failed to create resolver, %v", err)
 	}
 
-	var csv_wr *csvdict.Writer
+	defer func() {
 
-	// START OF put me a function that takes: id, name, lat, lon, resolver, parent_cache, *csvdict.Writer
-	
-	i64_to_string := func(i64_list []int64) []string {
+		err := resolver_flags.Close(process_ctx)
 
-		str_list := make([]string, len(i64_list))
-
-		for i, id := range i64_list {
-			str_list[i] = strconv.FormatInt(id, 10)
+		if err != nil {
+			slog.Error("Failed to close resolver", "error", err)
 		}
+	}()
 
-		return str_list
-	}
+	cell_cache := resolver_flags.CellCache()
+
+	csv_wr, err := csvdict.NewWriter(os.Stdout)
 
-	i64_to_csv := func(i64_list []int64) string {
-		return strings.Join(i64_to_string(i64_list), ",")
+	if err != nil {
+		log.Fatalf("Failed to create CSV writer, %v", err)
 	}
-	
-	process_place := func(ctx context.Context, pl *places.Place) error {
 
-		parent_id := int64(-1)
-		belongs_to := make([]int64, 0)
-		str_hierarchies := ""
+	row_opts := resolver_flags.RowOptions()
 
-		defer func() {
+	// The number of places read from the emitter, including those that were skipped
+	counter := int64(0)
 
-			out := map[string]string{
-				"4sq:id":          pl.Id,
-				"wof:parent_id":   strconv.FormatInt(parent_id, 10),
-				"wof:belongs_to":  i64_to_csv(belongs_to),
-				"wof:hierarchies": str_hierarchies,
-			}
+	country_mismatches := int64(0)
 
-			mu.Lock()
-			defer mu.Unlock()
+	places_seq := func(yield func(*places.Place, error) bool) {
 
-			if csv_wr == nil {
+		for pl, err := range e.Emit(ctx) {
 
-				wr, err := csvdict.NewWriter(os.Stdout)
+			count := atomic.AddInt64(&counter, 1)
 
-				if err != nil {
-					slog.Error("Failed to create CSV writer", "error", err)
-					return
-				}
+			if err == nil && start_after > 0 && start_after > count {
+				slog.Debug("Start after throttle", "after", start_after, "count", count)
+				continue
+			}
 
-				csv_wr = wr
+			if err != nil {
+				metrics.ParseErrors.Inc()
+			} else {
+				metrics.PlacesEmitted.Inc()
 			}
 
-			csv_wr.WriteRow(out)
-			csv_wr.Flush()
-		}()
+			if !yield(pl, err) {
+				// This place was not processed so make sure it is included when resuming
+				atomic.AddInt64(&counter, -1)
+				return
+			}
+		}
+	}
 
-		lat := pl.Latitude
-		lon := pl.Longitude
+	process_place := func(ctx context.Context, pl *places.Place) (*resolvedPlace, error) {
 
-		pt := orb.Point([2]float64{lon, lat})
-		f := geojson.NewFeature(pt)
+		// This span is ended by sink_place once the result has been written. It is nested under the
+		// span in which the place was emitted, if any.
 
-		f.Properties["wof:id"] = pl.Id
-		f.Properties["wof:name"] = pl.Name
-		f.Properties["wof:placetype"] = "venue"
-		f.Properties["lbl:latitude"] = lat
-		f.Properties["lbl:longitude"] = lon
+		ctx, span := tracer.Start(emitter.ContextWithPlace(ctx, pl), "reverse-geocode.place", trace.WithAttributes(
+			attribute.String("4sq.id", pl.Id),
+		))
 
-		body, err := f.MarshalJSON()
+		t1 := time.Now()
+		rsp, err := resolver.ResolvePlace(ctx, pl)
+
+		metrics.ProcessDuration.Observe(time.Since(t1).Seconds())
+		metrics.PlacesProcessed.Inc()
 
 		if err != nil {
-			slog.Error("Failed to marshal JSON", "error", err)
-			return err
-		}
 
-		possible, err := resolver.PointInPolygon(ctx, inputs, body)
+			metrics.PlacesFailed.Inc()
 
-		if err != nil {
-			slog.Error("Failed to resolve PIP", "error", err)
-			return err
+			rsp = &reversegeo.Result{
+				Id:               pl.Id,
+				ParentId:         -1,
+				BoundaryDistance: -1.0,
+				NameSimilarity:   -1.0,
+				Reason:           reversegeo.REASON_ERROR,
+			}
+
+			err = fmt.Errorf("Failed to resolve %s, %w", pl.Id, err)
+
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		r := &resolvedPlace{
+			place:  pl,
+			result: rsp,
+			span:   span,
 		}
 
-		parent_spr, err := results_cb(ctx, spatial_db, body, possible)
+		return r, err
+	}
+
+	write_row := func(row map[string]string) error {
+
+		err := csv_wr.WriteRow(row)
 
 		if err != nil {
-			slog.Error("Failed to process results", "error", err)
 			return err
 		}
 
-		if parent_spr != nil {
+		csv_wr.Flush()
+		return csv_wr.Error()
+	}
 
-			p_id, err := strconv.ParseInt(parent_spr.Id(), 10, 64)
+	// If results are being sorted then rows are written by a separate goroutine which
+	// reads them from 'rows_ch', sorts them and writes them once 'rows_ch' is closed.
 
-			if err != nil {
-				slog.Error("Failed to parse parse parent ID", "id", parent_spr.Id(), "error", err)
-				return err
-			}
+	var rows_ch chan map[string]string
+	var sort_done chan error
 
-			parent_id = p_id
+	if sort_by_id {
 
-			k := parent_spr.Id()
+		rows_ch = make(chan map[string]string, 1000)
+		sort_done = make(chan error, 1)
 
-			v, exists := parent_cache.Get(k)
+		go func() {
 
-			if exists {
-				// slog.Info("GET", "k", k, "v", v)
-				str_hierarchies = v
-			} else {
+			rows_seq := func(yield func(map[string]string, error) bool) {
+
+				for row := range rows_ch {
+
+					if !yield(row, nil) {
+						return
+					}
+				}
+			}
+
+			sort_opts := &order.SortRowsOptions{
+				Column: "4sq:id",
+			}
+
+			var sort_err error
 
-				belongs_to = parent_spr.BelongsTo()
+			for row, err := range order.SortRows(process_ctx, rows_seq, sort_opts) {
 
-				parent_body, err := wof_reader.LoadBytes(ctx, properties_reader, p_id)
+				if err == nil {
+					err = write_row(row)
+				}
 
 				if err != nil {
-					slog.Warn("Failed to derive record from properties reader", "id", p_id, "error", err)
-				} else {
-
-					hierarchies := properties.Hierarchies(parent_body)
-
-					candidates := []string{
-						"microhood_id",
-						"neighbourhood_id",
-						"macrohood_id",
-						"borough_id",
-						"locality_id",
-						"localadmin_id",
-						"county_id",
-						"region_id",
-						"country_id",
-						"continent_id",
-						"empire_id",
-					}
+					sort_err = fmt.Errorf("Failed to write sorted results, %w", err)
+					break
+				}
+			}
 
-					str_hier := make([]string, len(hierarchies))
+			// Make sure the sink is never blocked if sorting fails
 
-					for i, h := range hierarchies {
+			for range rows_ch {
+			}
 
-						// colon-separated list
-						hier_csv := make([]string, len(candidates))
+			sort_done <- sort_err
+		}()
+	}
 
-						for j, k := range candidates {
+	write_place := func(ctx context.Context, r *resolvedPlace) error {
 
-							id, exists := h[k]
-							v := ""
+		row := r.result.RowWithOptions(row_opts)
 
-							if exists {
-								v = strconv.FormatInt(id, 10)
-							}
+		if rows_ch != nil {
+			rows_ch <- row
+		} else {
 
-							hier_csv[j] = v
-						}
+			err := write_row(row)
 
-						str_hier[i] = strings.Join(hier_csv, ":")
-					}
+			if err != nil {
+				return fmt.Errorf("Failed to write row for %s, %w", r.place.Id, err)
+			}
+		}
+
+		if r.result.CountryAgreement == reversegeo.COUNTRY_DISAGREE {
+
+			atomic.AddInt64(&country_mismatches, 1)
 
-					str_hierarchies = strings.Join(str_hier, ",")
+			if mismatches_wr != nil {
+
+				err := mismatches_wr.WriteRow(reversegeo.CountryMismatchRow(r.place, r.result))
+
+				if err != nil {
+					return fmt.Errorf("Failed to write country mismatch for %s, %w", r.place.Id, err)
 				}
 
-				parent_cache.Set(k, str_hierarchies, 1)
+				mismatches_wr.Flush()
 			}
 		}
 
 		return nil
 	}
 
-	// END OF put me a function that takes: id, name, lat, lon, parent_cache, *csvdict.Writer
-	
-	counter := int64(0)
+	sink_place := func(ctx context.Context, r *resolvedPlace) error {
+
+		defer r.span.End()
+
+		ctx, span := tracer.Start(trace.ContextWithSpan(ctx, r.span), "WriteRow")
+		defer span.End()
+
+		err := write_place(ctx, r)
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		return nil
+	}
+
 	last_processed := int64(0)
-	processed := int64(0)
-	timing := int64(0)
 
-	ticker := time.NewTicker(time.Duration(10) * time.Second)
-	defer ticker.Stop()
+	progress := func(stats *pipeline.Stats) {
 
-	start := time.Now()
+		diff := int64(0)
 
-	go func() {
+		if last_processed > 0 {
+			diff = stats.Processed - last_processed
+		}
 
-		for {
-			select {
-			case <-ticker.C:
+		last_processed = stats.Processed
 
-				p := atomic.LoadInt64(&processed)
-				diff := int64(0)
+		metrics.Throughput.Set(stats.Throughput())
 
-				if last_processed > 0 {
-					diff = p - last_processed
-				}
+		args := []any{
+			"counter", atomic.LoadInt64(&counter),
+			"processed", stats.Processed,
+			"errors", stats.ProcessErrors,
+			"diff", diff,
+			"avg t2p", stats.AverageProcessTime(),
+			"elapsed", stats.Elapsed,
+		}
+
+		if tile_cache_stats {
 
-				last_processed = p
+			hit_rate, err := metrics.PMTilesCacheHitRate()
 
-				slog.Info("Status", "counter", counter, "processed", p, "diff", diff, "avg t2p", float64(timing)/float64(p), "elaspsed", time.Since(start))
+			if err != nil {
+				slog.Warn("Failed to derive PMTiles cache hit rate", "error", err)
+			} else {
+				args = append(args, "pmtiles cache hit rate", hit_rate)
 			}
 		}
-	}()
 
-	for pl, err := range e.Emit(ctx) {
+		if cell_cache != nil {
+			args = append(args, "cell cache hit rate", cell_cache.HitRate())
+		}
 
-		counter += 1
+		slog.Info("Status", args...)
+	}
+
+	pipeline_opts := &pipeline.Options[*places.Place, *resolvedPlace]{
+		Workers:      workers,
+		BufferSize:   reorder_buffer_size,
+		Ordered:      ordered,
+		Process:      process_place,
+		Sink:         sink_place,
+		SinkFailures: true,
+		ProcessErrors: pipeline.ErrorPolicy{
+			MaxErrors: max_errors,
+		},
+		SinkErrors: pipeline.ErrorPolicy{
+			Halt: true,
+		},
+		Progress: progress,
+	}
+
+	stats, run_err := pipeline.Run(ctx, places_seq, pipeline_opts)
+
+	if run_err != nil && stats == nil {
+		log.Fatalf("Failed to run pipeline, %v", run_err)
+	}
+
+	sort_failed := false
+
+	if sort_by_id {
+
+		slog.Info("Sorting results")
+		close(rows_ch)
+
+		err := <-sort_done
 
 		if err != nil {
-			slog.Error("Failed to yield place", "error", err)
-			continue
+			run_err = errors.Join(run_err, err)
+			sort_failed = true
 		}
+	}
+
+	interrupted := errors.Is(run_err, context.Canceled) && !sort_failed
+	complete := run_err == nil
+
+	if interrupted {
+		slog.Warn("Interrupted, finished processing in-flight places")
+	}
 
-		if start_after > 0 && start_after > counter {
-			slog.Debug("Start after throttle", "after", start_after, "count", counter)
-			continue
+	// If processing stopped before reaching 'start_after' then resume from there rather than the beginning
+	resume_after := max(atomic.LoadInt64(&counter)+1, start_after)
+
+	if checkpoint_path != "" {
+
+		cp := &checkpoint.Checkpoint{
+			Command:    "reverse-geocode",
+			Source:     emitter_uri,
+			Counter:    atomic.LoadInt64(&counter),
+			Processed:  stats.Processed,
+			StartAfter: resume_after,
+			Complete:   complete,
+		}
+
+		if run_err != nil {
+			cp.Error = run_err.Error()
 		}
 
-		<-throttle
+		err := cp.Write(checkpoint_path)
 
-		wg.Add(1)
+		if err != nil {
+			slog.Error("Failed to write checkpoint", "path", checkpoint_path, "error", err)
+		}
+	}
 
-		go func(pl *places.Place) {
+	slog.Info("Summary", "counter", atomic.LoadInt64(&counter), "processed", stats.Processed, "errors", stats.ProcessErrors, "elapsed", stats.Elapsed, "complete", complete)
 
-			t1 := time.Now()
+	if !complete {
+		slog.Info("Resume processing with", "start after", resume_after)
+	}
 
-			defer func() {
-				throttle <- true
+	if tile_cache_stats {
 
-				t2 := time.Since(t1)
-				atomic.AddInt64(&timing, t2.Milliseconds())
-				atomic.AddInt64(&processed, 1)
+		hits, misses, err := metrics.PMTilesCacheStats()
 
-				wg.Done()
-			}()
+		if err != nil {
+			slog.Warn("Failed to derive PMTiles cache stats", "error", err)
+		} else {
 
-			err = process_place(ctx, pl)
+			hit_rate := 0.0
 
-			if err != nil {
-				slog.Error("Failed to process place", "error", err)
+			if hits+misses > 0 {
+				hit_rate = float64(hits) / float64(hits+misses)
 			}
-		}(pl)
+
+			slog.Info("PMTiles cache", "hits", hits, "misses", misses, "hit rate", hit_rate)
+		}
 	}
 
-	wg.Wait()
+	if cell_cache != nil {
+		hits, misses := cell_cache.Stats()
+		slog.Info("Cell cache", "hits", hits, "misses", misses, "hit rate", cell_cache.HitRate())
+	}
+
+	if row_opts.Country {
+		slog.Info("Country check", "mismatches", atomic.LoadInt64(&country_mismatches))
+	}
+
+	if run_err != nil && !interrupted {
+		// log.Fatal doesn't run deferred functions so close the resolver (and flush its caches) and tracing first
+		err := resolver_flags.Close(process_ctx)
+
+		if err != nil {
+			slog.Error("Failed to close resolver", "error", err)
+		}
+
+		err = tracing_flags.Shutdown(process_ctx)
+
+		if err != nil {
+			slog.Error("Failed to shut down tracing", "error", err)
+		}
 
+		log.Fatal(run_err)
+	}
 }
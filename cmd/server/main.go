@@ -0,0 +1,140 @@
+package main
+
+/*
+
+./bin/server \
+    -server-address localhost:8080 \
+    -database-path /usr/local/data/4sq/4sq.db
+
+$> curl -s 'http://localhost:8080/place/4b0587fdf964a52060a822e3'
+
+$> curl -s 'http://localhost:8080/nearby?lat=37.7749&lon=-122.4194&radius=500&format=geojson'
+
+$> curl -s 'http://localhost:8080/search?q=blue+bott&format=ndjson'
+
+*/
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places/api"
+	"github.com/whosonfirst/go-foursquare-places/sqlite"
+)
+
+func main() {
+
+	var server_address string
+	var database_path string
+	var default_limit int
+	var max_limit int
+	var max_radius float64
+	var verbose bool
+
+	flag.StringVar(&server_address, "server-address", "localhost:8080", "The address (host and port) the server should listen for requests on.")
+	flag.StringVar(&database_path, "database-path", "", "The path to a SQLite database of places created by the index-sqlite tool.")
+	flag.IntVar(&default_limit, "default-limit", sqlite.DEFAULT_LIMIT, "The number of results to return if a request does not specify a limit.")
+	flag.IntVar(&max_limit, "max-limit", api.DEFAULT_MAX_LIMIT, "The maximum number of results a request may ask for.")
+	flag.Float64Var(&max_radius, "max-radius", api.DEFAULT_MAX_RADIUS, "The maximum radius, in metres, for nearby queries.")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	if database_path == "" {
+		log.Fatal("Missing -database-path flag")
+	}
+
+	// See notes in cmd/reverse-geocode/main.go
+
+	var c = jsoniter.Config{
+		EscapeHTML:              true,
+		SortMapKeys:             false,
+		MarshalFloatWith6Digits: true,
+	}.Froze()
+
+	geojson.CustomJSONMarshaler = c
+	geojson.CustomJSONUnmarshaler = c
+
+	ctx := context.Background()
+
+	db, err := sqlite.NewReadOnlyDatabase(ctx, database_path)
+
+	if err != nil {
+		log.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	handler_opts := &api.PlacesHandlerOptions{
+		Database:     db,
+		DefaultLimit: default_limit,
+		MaxLimit:     max_limit,
+		MaxRadius:    max_radius,
+	}
+
+	handlers := map[string]func(*api.PlacesHandlerOptions) (http.Handler, error){
+		"GET /place/{id}":             api.PlaceHandler,
+		"GET /nearby":                 api.NearbyHandler,
+		"GET /bbox":                   api.BoundingBoxHandler,
+		"GET /search":                 api.SearchHandler,
+		"GET /categories/{id}/places": api.CategoryPlacesHandler,
+	}
+
+	mux := http.NewServeMux()
+
+	for pattern, handler_func := range handlers {
+
+		h, err := handler_func(handler_opts)
+
+		if err != nil {
+			log.Fatalf("Failed to create handler for %s, %v", pattern, err)
+		}
+
+		mux.Handle(pattern, h)
+	}
+
+	server := &http.Server{
+		Addr:    server_address,
+		Handler: mux,
+	}
+
+	signal_ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done_ch := make(chan error, 1)
+
+	go func() {
+
+		<-signal_ctx.Done()
+
+		slog.Info("Shutting down, waiting for in-flight requests to complete")
+		done_ch <- server.Shutdown(ctx)
+	}()
+
+	slog.Info("Listening for requests", "address", server_address)
+
+	err = server.ListenAndServe()
+
+	if err != nil && err != http.ErrServerClosed {
+		db.Close()
+		log.Fatalf("Failed to serve requests, %v", err)
+	}
+
+	err = <-done_ch
+
+	if err != nil {
+		slog.Error("Failed to shut down server", "error", err)
+	}
+}
@@ -1,49 +1,197 @@
 package main
 
 import (
-	_ "context"
+	"context"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/sfomuseum/go-csvdict/v2"
+	"github.com/whosonfirst/go-foursquare-places/checkpoint"
+	"github.com/whosonfirst/go-foursquare-places/metrics"
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
 )
 
+// countryWriter is a CSV writer for the records in a single country and the file it writes to.
+type countryWriter struct {
+	fh *os.File
+	wr *csv.Writer
+	// The columns of the file, or nil if its header has not been written yet
+	header []string
+}
+
+// WriteRow writes the values of 'row' in the order of the file's columns, writing a header of the (sorted) keys
+// in 'row' first if the file doesn't have one. It returns an error if the keys in 'row' are not the file's columns.
+func (cw *countryWriter) WriteRow(row map[string]string) error {
+
+	if cw.header == nil {
+
+		header := make([]string, 0, len(row))
+
+		for k := range row {
+			header = append(header, k)
+		}
+
+		slices.Sort(header)
+
+		err := cw.wr.Write(header)
+
+		if err != nil {
+			return err
+		}
+
+		cw.header = header
+	}
+
+	if len(row) != len(cw.header) {
+		return fmt.Errorf("Row has %d columns but %s has %d", len(row), cw.fh.Name(), len(cw.header))
+	}
+
+	out := make([]string, len(cw.header))
+
+	for i, k := range cw.header {
+
+		v, exists := row[k]
+
+		if !exists {
+			return fmt.Errorf("Row is missing the %s column of %s", k, cw.fh.Name())
+		}
+
+		out[i] = v
+	}
+
+	return cw.wr.Write(out)
+}
+
+// readHeader returns the header of the CSV file at 'path' or nil if the file does not exist or is empty.
+func readHeader(path string) ([]string, error) {
+
+	r, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	header, err := csv.NewReader(r).Read()
+
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// sortedRow is a row and the countries it should be written to.
+type sortedRow struct {
+	row       map[string]string
+	countries []string
+}
+
 func main() {
 
+	var target string
+	var start_after int64
+	var checkpoint_path string
+	var metrics_addr string
+	var workers int
+
+	flag.StringVar(&target, "target", "", "The directory to write per-country CSV files to. Records are appended to existing files.")
+	flag.Int64Var(&start_after, "start-after", 0, "If > 0 then skip the first 'start_after' - 1 records of the first path.")
+	flag.IntVar(&workers, "workers", 200, "The maximum number of workers to derive the countries of rows. Rows are written to their per-country files one at a time.")
+	flag.StringVar(&checkpoint_path, "checkpoint-path", "", "An optional path to write a JSON-encoded checkpoint to when processing completes or is interrupted (by SIGINT or SIGTERM).")
+
+	flag.StringVar(&metrics_addr, "metrics-addr", "", "An optional address (host and port) to serve Prometheus metrics (from /metrics) and net/http/pprof profiles (from /debug/pprof/) on while processing.")
+
 	flag.Parse()
 
-	countries := new(sync.Map)
-	writers := make(map[string]*csvdict.Writer)
+	if target == "" {
+		log.Fatal("Missing -target flag")
+	}
 
-	counter := int64(0)
+	// Stop reading new rows when interrupted but let the rows already being
+	// sorted finish so that their writers can be flushed and closed.
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	process_ctx := context.WithoutCancel(ctx)
+
+	if metrics_addr != "" {
 
-	workers := 200
-	throttle := make(chan bool, workers)
+		metrics_server := &http.Server{
+			Addr:    metrics_addr,
+			Handler: metrics.NewServeMux(),
+		}
+
+		go func() {
+
+			slog.Info("Serving metrics", "address", metrics_addr)
+
+			err := metrics_server.ListenAndServe()
+
+			if err != nil && err != http.ErrServerClosed {
+				slog.Error("Failed to serve metrics", "error", err)
+			}
+		}()
 
-	for i := 0; i < workers; i++ {
-		throttle <- true
+		defer metrics_server.Shutdown(process_ctx)
 	}
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	countries := new(sync.Map)
+	writers := make(map[string]*countryWriter)
+
+	close_writers := func() {
+
+		for country, cw := range writers {
+
+			cw.wr.Flush()
 
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				slog.Info("Status", "count", atomic.LoadInt64(&counter))
+			err := cw.wr.Error()
+
+			if err != nil {
+				slog.Error("Failed to flush writer", "country", country, "error", err)
+			}
+
+			err = cw.fh.Close()
+
+			if err != nil {
+				slog.Error("Failed to close writer", "country", country, "error", err)
 			}
+
+			delete(writers, country)
 		}
-	}()
+	}
+
+	defer close_writers()
+
+	// The total number of rows sorted across all paths
+	counter := int64(0)
 
 	derive_country := func(id int64) (string, error) {
 
@@ -54,7 +202,14 @@ func main() {
 		}
 
 		url := fmt.Sprintf("https://spelunker.whosonfirst.org/select/%d?select=properties.wof:country", id)
-		rsp, err := http.Get(url)
+
+		req, err := http.NewRequestWithContext(process_ctx, http.MethodGet, url, nil)
+
+		if err != nil {
+			return "", err
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
 
 		if err != nil {
 			return "", err
@@ -79,98 +234,259 @@ func main() {
 		return country, nil
 	}
 
-	for _, path := range flag.Args() {
+	// Writers are only created and used by the pipeline's sink, which writes one row at a time
 
-		r, err := csvdict.NewReaderFromPath(path)
+	get_writer := func(str_country string) (*countryWriter, error) {
+
+		cw, exists := writers[str_country]
+
+		if exists {
+			return cw, nil
+		}
+
+		csv_path := filepath.Join(target, fmt.Sprintf("foursquare-wof-%s.csv", str_country))
+
+		// Rows are appended to existing files (for example when resuming) without writing another header
+
+		header, err := readHeader(csv_path)
 
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("Failed to read header of %s, %w", csv_path, err)
+		}
+
+		fh, err := os.OpenFile(csv_path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s for writing, %w", csv_path, err)
+		}
+
+		cw = &countryWriter{
+			fh:     fh,
+			wr:     csv.NewWriter(fh),
+			header: header,
+		}
+
+		writers[str_country] = cw
+
+		// slog.Info(csv_path)
+		return cw, nil
+	}
+
+	// The position of the country ID in each (colon-separated) hierarchy
+	country_idx := slices.Index(reversegeo.HIERARCHY_KEYS, "country_id")
+
+	sort_row := func(ctx context.Context, row map[string]string) (*sortedRow, error) {
+
+		hiers := strings.Split(row["wof:hierarchies"], ",")
+
+		r := &sortedRow{
+			row:       row,
+			countries: make([]string, 0, len(hiers)),
+		}
+
+		for _, str_h := range hiers {
+
+			country_id := int64(-1)
+			str_country := "XY"
+
+			h := strings.Split(str_h, ":")
+
+			if len(h) > country_idx && h[country_idx] != "" && h[country_idx] != "-1" {
+				str_country = h[country_idx]
+
+				v, err := strconv.ParseInt(str_country, 10, 64)
+
+				if err != nil {
+					slog.Error(err.Error())
+				} else {
+
+					country_id = v
+					code, err := derive_country(country_id)
+
+					if err != nil {
+						slog.Error(err.Error())
+					} else {
+						str_country = code
+					}
+				}
+			}
+
+			r.countries = append(r.countries, str_country)
 		}
 
-		wg := new(sync.WaitGroup)
-		mu := new(sync.RWMutex)
+		return r, nil
+	}
+
+	write_row := func(ctx context.Context, r *sortedRow) error {
+
+		for _, str_country := range r.countries {
 
-		for row, err := range r.Iterate() {
+			csv_wr, err := get_writer(str_country)
 
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 
-			<-throttle
-			wg.Add(1)
+			// slog.Info("Write", "country", str_country, "id", r.row["4sq:id"])
+			err = csv_wr.WriteRow(r.row)
 
-			go func(row map[string]string) {
+			if err != nil {
+				return fmt.Errorf("Failed to write row for %s, %w", str_country, err)
+			}
+		}
+
+		return nil
+	}
 
-				defer func() {
-					atomic.AddInt64(&counter, 1)
-					throttle <- true
-					wg.Done()
-				}()
+	var current_path string
+	path_counter := int64(0)
 
-				hiers := strings.Split(row["wof:hierarchies"], ",")
+	completed := make([]string, 0)
 
-				for _, str_h := range hiers {
+	// The first error that stopped processing, other than being interrupted
+	var run_err error
 
-					country_id := int64(-1)
-					str_country := "XY"
+	for idx, path := range flag.Args() {
 
-					h := strings.Split(str_h, ":")
+		if ctx.Err() != nil {
+			break
+		}
 
-					if len(h) >= 3 && h[3] != "" && h[3] != "-1" {
-						str_country = h[3]
+		current_path = path
+		path_counter = 0
 
-						v, err := strconv.ParseInt(str_country, 10, 64)
+		r, err := csvdict.NewReaderFromPath(path)
 
-						if err != nil {
-							slog.Error(err.Error())
-						} else {
+		if err != nil {
+			run_err = err
+			break
+		}
 
-							country_id = v
-							code, err := derive_country(country_id)
+		rows_seq := func(yield func(map[string]string, error) bool) {
 
-							if err != nil {
-								slog.Error(err.Error())
-							} else {
-								str_country = code
-							}
-						}
-					}
+			for row, err := range r.Iterate() {
 
-					mu.RLock()
-					csv_wr, exists := writers[str_country]
-					mu.RUnlock()
+				count := atomic.AddInt64(&path_counter, 1)
 
-					if !exists {
+				if err == nil && idx == 0 && start_after > 0 && start_after > count {
+					continue
+				}
 
-						mu.Lock()
+				if err != nil {
+					metrics.ParseErrors.Inc()
+				} else {
+					metrics.PlacesEmitted.Inc()
+				}
 
-						csv_path := fmt.Sprintf("/Users/asc/data/foursquare-wof-sorted/foursquare-wof-%s.csv", str_country)
-						wr, err := csvdict.NewWriterFromPath(csv_path)
+				if !yield(row, err) {
+					// This row was not processed so make sure it is included when resuming
+					atomic.AddInt64(&path_counter, -1)
+					return
+				}
+			}
+		}
 
-						if err != nil {
-							log.Fatal(err)
-						}
+		pipeline_opts := &pipeline.Options[map[string]string, *sortedRow]{
+			Workers: workers,
+			Process: func(ctx context.Context, row map[string]string) (*sortedRow, error) {
 
-						writers[str_country] = wr
-						csv_wr = wr
+				t1 := time.Now()
+				v, err := sort_row(ctx, row)
 
-						// slog.Info(csv_path)
-						mu.Unlock()
-					}
+				metrics.ProcessDuration.Observe(time.Since(t1).Seconds())
+				metrics.PlacesProcessed.Inc()
 
-					// slog.Info("Write", "country", str_country, "id", row["4sq:id"])
-					csv_wr.WriteRow(row)
+				if err != nil {
+					metrics.PlacesFailed.Inc()
 				}
 
-			}(row)
+				return v, err
+			},
+			Sink: write_row,
+			EmitErrors: pipeline.ErrorPolicy{
+				Halt: true,
+			},
+			ProcessErrors: pipeline.ErrorPolicy{
+				Halt: true,
+			},
+			SinkErrors: pipeline.ErrorPolicy{
+				Halt: true,
+			},
+			Progress: func(stats *pipeline.Stats) {
+				slog.Info("Status", "count", atomic.LoadInt64(&counter)+stats.Processed)
+				metrics.Throughput.Set(stats.Throughput())
+			},
+			ProgressInterval: 5 * time.Second,
+		}
+
+		stats, err := pipeline.Run(ctx, rows_seq, pipeline_opts)
+
+		if stats != nil {
+			atomic.AddInt64(&counter, stats.Processed)
+		}
+
+		for _, cw := range writers {
+			cw.wr.Flush()
 		}
 
-		wg.Wait()
+		if err != nil {
 
-		for _, wr := range writers {
-			wr.Flush()
+			if !errors.Is(err, context.Canceled) {
+				run_err = err
+			}
+
+			break
 		}
 
+		completed = append(completed, path)
 		slog.Info("Complete", "path", path, "count", atomic.LoadInt64(&counter))
 	}
+
+	complete := len(completed) == len(flag.Args())
+
+	resume_after := atomic.LoadInt64(&path_counter) + 1
+
+	// If processing stopped before reaching 'start_after' then resume from there rather than the beginning
+	if len(completed) == 0 {
+		resume_after = max(resume_after, start_after)
+	}
+
+	if checkpoint_path != "" {
+
+		cp := &checkpoint.Checkpoint{
+			Command:   "sort",
+			Processed: atomic.LoadInt64(&counter),
+			Complete:  complete,
+		}
+
+		if !complete {
+			cp.Source = current_path
+			cp.Counter = atomic.LoadInt64(&path_counter)
+			cp.StartAfter = resume_after
+		}
+
+		if run_err != nil {
+			cp.Error = run_err.Error()
+		} else if ctx.Err() != nil {
+			cp.Error = ctx.Err().Error()
+		}
+
+		err := cp.Write(checkpoint_path)
+
+		if err != nil {
+			slog.Error("Failed to write checkpoint", "path", checkpoint_path, "error", err)
+		}
+	}
+
+	slog.Info("Summary", "count", atomic.LoadInt64(&counter), "completed", len(completed), "paths", len(flag.Args()))
+
+	if !complete && current_path != "" {
+		slog.Info("Resume processing with", "path", current_path, "start after", resume_after)
+	}
+
+	if run_err != nil {
+		// log.Fatal doesn't run deferred functions so close the writers first
+		close_writers()
+		log.Fatal(run_err)
+	}
 }
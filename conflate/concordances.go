@@ -0,0 +1,115 @@
+package conflate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-foursquare-places/whosonfirst"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-export/v2"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"github.com/whosonfirst/go-writer/v3"
+)
+
+// concordance_path is the path of the "4sq:id" concordance in a Who's On First record.
+const concordance_path string = "properties.wof:concordances." + whosonfirst.CONCORDANCE
+
+// ErrConflictingConcordance is returned when a Who's On First record already has a "4sq:id" concordance for a
+// different place.
+var ErrConflictingConcordance = errors.New("Record has a conflicting 4sq:id concordance")
+
+// existingIdProvider implements the `whosonfirst/go-whosonfirst-id.Provider` interface for exporting records which
+// already have a Who's On First ID.
+type existingIdProvider struct{}
+
+// NewID returns an error since concordances are only assigned to existing records.
+func (pr *existingIdProvider) NewID(ctx context.Context) (int64, error) {
+	return -1, fmt.Errorf("Records without a wof:id can not be exported")
+}
+
+// NewExportOptions returns a new `export.Options` instance for exporting the records which concordances have been
+// assigned to. Unlike the default options these will never mint new IDs (or connect to a remote ID provider).
+func NewExportOptions(ctx context.Context) (*export.Options, error) {
+	return export.NewDefaultOptionsWithProvider(ctx, &existingIdProvider{})
+}
+
+// AssignConcordance assigns a "4sq:id" concordance for the place with Foursquare ID 'fsq_id' to the Who's On First
+// record in 'body' and exports it using 'opts'. It returns false, and the original record, if the record already has
+// that concordance or `ErrConflictingConcordance` if it has a concordance for a different place.
+func AssignConcordance(ctx context.Context, body []byte, fsq_id string, opts *export.Options) (bool, []byte, error) {
+
+	existing := gjson.GetBytes(body, concordance_path)
+
+	if existing.Exists() {
+
+		if existing.String() == fsq_id {
+			return false, body, nil
+		}
+
+		return false, nil, fmt.Errorf("%w (%s)", ErrConflictingConcordance, existing.String())
+	}
+
+	to_assign := map[string]interface{}{
+		concordance_path: fsq_id,
+	}
+
+	body, err := export.AssignProperties(ctx, body, to_assign)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to assign concordance, %w", err)
+	}
+
+	body, err = export.Prepare(body, opts)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to prepare record, %w", err)
+	}
+
+	body, err = export.Format(body, opts)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to format record, %w", err)
+	}
+
+	return true, body, nil
+}
+
+// WriteConcordance reads the Who's On First record with ID 'wof_id' from 'r', assigns it a "4sq:id" concordance for
+// the place with Foursquare ID 'fsq_id' using `AssignConcordance` and, if it has changed, writes it to 'wr'. It
+// returns whether the record was written.
+func WriteConcordance(ctx context.Context, r reader.Reader, wr writer.Writer, wof_id int64, fsq_id string, opts *export.Options) (bool, error) {
+
+	body, err := wof_reader.LoadBytes(ctx, r, wof_id)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to read %d, %w", wof_id, err)
+	}
+
+	changed, body, err := AssignConcordance(ctx, body, fsq_id, opts)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to assign concordance to %d, %w", wof_id, err)
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	path, err := uri.Id2RelPath(wof_id)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to derive path for %d, %w", wof_id, err)
+	}
+
+	_, err = wr.Write(ctx, path, bytes.NewReader(body))
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to write %d, %w", wof_id, err)
+	}
+
+	return true, nil
+}
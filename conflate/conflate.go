@@ -0,0 +1,318 @@
+// Package conflate provides methods for matching Foursquare places with existing Who's On First (WOF) venues.
+//
+// Venues are read from a go-whosonfirst-iterate iterator or a WOF SQLite database and indexed in memory using a
+// `spatial.Index`. Each place is compared with the venues within a maximum distance of it using the same scores
+// (name, distance, address, telephone, website and category) as the `dedupe` package and is either matched with a
+// venue, matched ambiguously with several venues or considered new. Venues which already have a "4sq:id"
+// concordance are always matched with that place.
+package conflate
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/dedupe"
+	"github.com/whosonfirst/go-foursquare-places/spatial"
+	"github.com/whosonfirst/go-foursquare-places/whosonfirst"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+)
+
+// DEFAULT_MAX_DISTANCE is the default maximum distance, in metres, between a place and the venues it is compared
+// with. This is larger than the default for deduplicating places since the coordinates of older venues are often
+// less precise.
+const DEFAULT_MAX_DISTANCE float64 = 250.0
+
+// DEFAULT_MATCH_THRESHOLD is the default minimum score for a place to be matched with a venue.
+const DEFAULT_MATCH_THRESHOLD float64 = 0.8
+
+// DEFAULT_AMBIGUOUS_THRESHOLD is the default minimum score for a venue to be considered a candidate for a place.
+const DEFAULT_AMBIGUOUS_THRESHOLD float64 = 0.6
+
+// DEFAULT_MIN_MARGIN is the default minimum difference between the scores of the best and second-best candidates
+// for a place to be matched with the best candidate.
+const DEFAULT_MIN_MARGIN float64 = 0.1
+
+// DEFAULT_MAX_CANDIDATES is the default maximum number of candidates included in a `Result`.
+const DEFAULT_MAX_CANDIDATES int = 5
+
+// Status is the outcome of matching a place with venues.
+type Status string
+
+const (
+	// MATCH is the status of places which have been matched with a single venue.
+	MATCH Status = "match"
+	// AMBIGUOUS is the status of places which may be one of several venues, or whose best candidate does not
+	// score high enough to be matched.
+	AMBIGUOUS Status = "ambiguous"
+	// NEW is the status of places which don't match any venues.
+	NEW Status = "new"
+)
+
+// Options defines configuration options for creating a new `Conflator`.
+type Options struct {
+	// MaxDistance is the maximum distance, in metres, between a place and the venues it is compared with. Default
+	// is `DEFAULT_MAX_DISTANCE`.
+	MaxDistance float64
+	// MatchThreshold is the minimum score for a place to be matched with a venue. Default is
+	// `DEFAULT_MATCH_THRESHOLD`.
+	MatchThreshold float64
+	// AmbiguousThreshold is the minimum score for a venue to be considered a candidate for a place. Default is
+	// `DEFAULT_AMBIGUOUS_THRESHOLD`.
+	AmbiguousThreshold float64
+	// MinMargin is the minimum difference between the scores of the best and second-best candidates for a place
+	// to be matched with the best candidate. Default is `DEFAULT_MIN_MARGIN`.
+	MinMargin float64
+	// MinNameSimilarity is the minimum similarity of the names of a place and the venues it is compared with.
+	// Default is `dedupe.DEFAULT_MIN_NAME_SIMILARITY`.
+	MinNameSimilarity float64
+	// MaxCandidates is the maximum number of candidates included in a `Result`. Default is
+	// `DEFAULT_MAX_CANDIDATES`.
+	MaxCandidates int
+}
+
+// Candidate is a venue which may be the same as a place.
+type Candidate struct {
+	// The Who's On First ID of the venue.
+	Id int64 `json:"wof:id"`
+	// The name of the venue.
+	Name string `json:"wof:name"`
+	// The score for the place and the venue.
+	Score *dedupe.Score `json:"score"`
+}
+
+// Result is the result of matching a place with venues.
+type Result struct {
+	// The Foursquare ID of the place.
+	Id string `json:"4sq:id"`
+	// The name of the place.
+	Name string `json:"name"`
+	// The outcome of matching the place.
+	Status Status `json:"status"`
+	// The Who's On First ID of the venue the place was matched with, or -1.
+	WOFId int64 `json:"wof:id"`
+	// Whether the place was matched using an existing "4sq:id" concordance.
+	Concordance bool `json:"concordance"`
+	// The venues which may be the same as the place, ordered by score (highest first).
+	Candidates []*Candidate `json:"candidates"`
+}
+
+// Conflator accumulates Who's On First venues and matches places with them.
+type Conflator struct {
+	max_distance        float64
+	match_threshold     float64
+	ambiguous_threshold float64
+	min_margin          float64
+	min_name_similarity float64
+	max_candidates      int
+	mu                  *sync.Mutex
+	build_once          *sync.Once
+	builder             *spatial.Builder
+	index               *spatial.Index
+	records             map[string]*dedupe.Record
+	// The Who's On First IDs of venues with "4sq:id" concordances, keyed by Foursquare ID
+	concordances map[string]int64
+}
+
+// NewConflator returns a new `Conflator` instance configured by 'opts'.
+func NewConflator(opts *Options) *Conflator {
+
+	c := &Conflator{
+		max_distance:        DEFAULT_MAX_DISTANCE,
+		match_threshold:     DEFAULT_MATCH_THRESHOLD,
+		ambiguous_threshold: DEFAULT_AMBIGUOUS_THRESHOLD,
+		min_margin:          DEFAULT_MIN_MARGIN,
+		min_name_similarity: dedupe.DEFAULT_MIN_NAME_SIMILARITY,
+		max_candidates:      DEFAULT_MAX_CANDIDATES,
+		mu:                  new(sync.Mutex),
+		build_once:          new(sync.Once),
+		builder:             spatial.NewBuilder(nil),
+		records:             make(map[string]*dedupe.Record),
+		concordances:        make(map[string]int64),
+	}
+
+	if opts != nil {
+
+		if opts.MaxDistance > 0 {
+			c.max_distance = opts.MaxDistance
+		}
+
+		if opts.MatchThreshold > 0 {
+			c.match_threshold = opts.MatchThreshold
+		}
+
+		if opts.AmbiguousThreshold > 0 {
+			c.ambiguous_threshold = opts.AmbiguousThreshold
+		}
+
+		if opts.MinMargin > 0 {
+			c.min_margin = opts.MinMargin
+		}
+
+		if opts.MinNameSimilarity > 0 {
+			c.min_name_similarity = opts.MinNameSimilarity
+		}
+
+		if opts.MaxCandidates > 0 {
+			c.max_candidates = opts.MaxCandidates
+		}
+	}
+
+	return c
+}
+
+// AddVenue adds the Who's On First record in 'body' to the conflator. It returns false, and no error, if the record
+// is not a venue or is an alternate geometry or has been deprecated or superseded. This method is safe for concurrent
+// use but venues can not be added once places have been matched.
+func (c *Conflator) AddVenue(body []byte) (bool, error) {
+
+	if !isCandidateVenue(body) {
+		return false, nil
+	}
+
+	pl, err := PlaceFromVenue(body)
+
+	if err != nil {
+		return false, err
+	}
+
+	concordances := properties.Concordances(body)
+	fsq_id, _ := concordances[whosonfirst.CONCORDANCE].(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.builder == nil {
+		return false, fmt.Errorf("Venues can not be added after places have been matched")
+	}
+
+	_, exists := c.records[pl.Id]
+
+	if exists {
+		return false, fmt.Errorf("Venue %s has already been added", pl.Id)
+	}
+
+	err = c.builder.Add(pl.Id, pl.Latitude, pl.Longitude)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to index venue %s, %w", pl.Id, err)
+	}
+
+	c.records[pl.Id] = dedupe.NewRecord(pl)
+
+	if fsq_id != "" {
+
+		id, _ := strconv.ParseInt(pl.Id, 10, 64)
+		c.concordances[fsq_id] = id
+	}
+
+	return true, nil
+}
+
+// Len returns the number of venues which have been added to the conflator.
+func (c *Conflator) Len() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.records)
+}
+
+// Match matches 'pl' with the venues which have been added to the conflator. If a venue has a "4sq:id" concordance
+// for the place it is matched with that venue. Otherwise the place is compared with the venues within the maximum
+// distance of it and:
+//
+// * If the best candidate scores at least the match threshold, and at least the minimum margin more than any other
+// candidate, the place is matched with that venue.
+// * If any candidates score at least the ambiguous threshold the match is ambiguous.
+// * Otherwise the place is new.
+//
+// This method is safe for concurrent use.
+func (c *Conflator) Match(pl *places.Place) *Result {
+
+	c.build_once.Do(func() {
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.index = c.builder.Build()
+		c.builder = nil
+	})
+
+	rsp := &Result{
+		Id:         pl.Id,
+		Name:       pl.Name,
+		Status:     NEW,
+		WOFId:      -1,
+		Candidates: make([]*Candidate, 0),
+	}
+
+	a := dedupe.NewRecord(pl)
+
+	wof_id, exists := c.concordances[pl.Id]
+
+	if exists {
+
+		b := c.records[strconv.FormatInt(wof_id, 10)]
+
+		rsp.Status = MATCH
+		rsp.WOFId = wof_id
+		rsp.Concordance = true
+		rsp.Candidates = append(rsp.Candidates, c.candidate(a, b))
+
+		return rsp
+	}
+
+	for _, r := range c.index.Radius(pl.Latitude, pl.Longitude, c.max_distance, nil) {
+
+		candidate := c.candidate(a, c.records[r.Id])
+
+		if candidate.Score.Name < c.min_name_similarity || candidate.Score.Score < c.ambiguous_threshold {
+			continue
+		}
+
+		rsp.Candidates = append(rsp.Candidates, candidate)
+	}
+
+	if len(rsp.Candidates) == 0 {
+		return rsp
+	}
+
+	slices.SortFunc(rsp.Candidates, func(a *Candidate, b *Candidate) int {
+		return cmp.Or(cmp.Compare(b.Score.Score, a.Score.Score), cmp.Compare(a.Id, b.Id))
+	})
+
+	if len(rsp.Candidates) > c.max_candidates {
+		rsp.Candidates = rsp.Candidates[:c.max_candidates]
+	}
+
+	best := rsp.Candidates[0]
+	rsp.Status = AMBIGUOUS
+
+	if best.Score.Score >= c.match_threshold {
+
+		if len(rsp.Candidates) == 1 || best.Score.Score-rsp.Candidates[1].Score.Score >= c.min_margin {
+			rsp.Status = MATCH
+			rsp.WOFId = best.Id
+		}
+	}
+
+	return rsp
+}
+
+// candidate returns a new `Candidate` for the place 'a' and the venue 'b'.
+func (c *Conflator) candidate(a *dedupe.Record, b *dedupe.Record) *Candidate {
+
+	id, _ := strconv.ParseInt(b.Id, 10, 64)
+
+	candidate := &Candidate{
+		Id:    id,
+		Name:  b.Name,
+		Score: dedupe.Compare(a, b, c.max_distance),
+	}
+
+	return candidate
+}
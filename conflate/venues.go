@@ -0,0 +1,321 @@
+package conflate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/whosonfirst"
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-feature/alt"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+// venues_query is the query used to read the (non-alternate) records in a Who's On First SQLite database.
+const venues_query string = `SELECT body FROM geojson WHERE COALESCE(is_alt, 0) = 0`
+
+// venue_query is the query used to read a single (non-alternate) record in a Who's On First SQLite database.
+const venue_query string = `SELECT body FROM geojson WHERE id = ? AND COALESCE(is_alt, 0) = 0`
+
+// DatabaseReader implements the `whosonfirst/go-reader.Reader` interface for the (non-alternate) records in the
+// `geojson` table of a Who's On First SQLite database.
+type DatabaseReader struct {
+	reader.Reader
+	db *sql.DB
+}
+
+// PlaceFromVenue returns the Who's On First record in 'body' as a `places.Place` instance whose ID is the record's
+// `wof:id`. Addresses and telephone numbers are read from the `addr:` properties of the record and websites and
+// categories from the `4sq:` properties assigned by the `whosonfirst.AsFeature` method, if present. Records which
+// are no longer current are assigned their cessation date as their closing date.
+//
+// Who's On First's own categories ("wof:category", "sg:classifiers" and "wof:tags") are not read since there is no
+// mapping between them and Foursquare's category taxonomy. This means that venues which weren't created from
+// Foursquare places have no categories and are matched without a category score.
+func PlaceFromVenue(body []byte) (*places.Place, error) {
+
+	id, err := properties.Id(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive ID, %w", err)
+	}
+
+	name, err := properties.Name(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive name for %d, %w", id, err)
+	}
+
+	centroid, _, err := properties.Centroid(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive centroid for %d, %w", id, err)
+	}
+
+	props := gjson.GetBytes(body, "properties")
+
+	address := props.Get("addr:full").String()
+
+	if address == "" {
+
+		parts := make([]string, 0, 2)
+
+		for _, k := range []string{"addr:housenumber", "addr:street"} {
+
+			v := props.Get(k).String()
+
+			if v != "" {
+				parts = append(parts, v)
+			}
+		}
+
+		address = strings.Join(parts, " ")
+	}
+
+	pl := &places.Place{
+		Id:         strconv.FormatInt(id, 10),
+		Name:       name,
+		Latitude:   centroid.Lat(),
+		Longitude:  centroid.Lon(),
+		Country:    properties.Country(body),
+		Address:    address,
+		PostCode:   props.Get("addr:postcode").String(),
+		Telephone:  props.Get("addr:phone").String(),
+		Website:    props.Get("4sq:website").String(),
+		Categories: make([]places.Category, 0),
+	}
+
+	category_ids := props.Get("4sq:category_ids").Array()
+	category_labels := props.Get("4sq:category_labels").Array()
+
+	for i, c := range category_ids {
+
+		category := places.Category{
+			Id:     c.String(),
+			Labels: make([]string, 0),
+		}
+
+		if i < len(category_labels) {
+			category.Labels = strings.Split(category_labels[i].String(), " > ")
+		}
+
+		pl.Categories = append(pl.Categories, category)
+	}
+
+	is_current, err := properties.IsCurrent(body)
+
+	if err == nil && is_current.IsFalse() {
+		pl.DateClosed = properties.Cessation(body)
+	}
+
+	return pl, nil
+}
+
+// isCandidateVenue reports whether 'body' is a Who's On First venue record which places can be matched with:
+// Alternate geometries and records which have been deprecated or superseded are excluded.
+func isCandidateVenue(body []byte) bool {
+
+	if alt.IsAlt(body) {
+		return false
+	}
+
+	pt, err := properties.Placetype(body)
+
+	if err != nil || pt != whosonfirst.PLACETYPE {
+		return false
+	}
+
+	is_deprecated, err := properties.IsDeprecated(body)
+
+	if err != nil || is_deprecated.IsTrue() {
+		return false
+	}
+
+	is_superseded, err := properties.IsSuperseded(body)
+
+	if err != nil || is_superseded.IsTrue() {
+		return false
+	}
+
+	return true
+}
+
+// AddVenuesFromIterator adds the venues yielded by a `whosonfirst/go-whosonfirst-iterate/v2/iterator.Iterator`
+// instance, created using 'iterator_uri', for 'uris' to the conflator. It returns the number of venues added. Records which are not venues are skipped and
+// venues which can't be added, for example because they don't have a name, are logged and skipped.
+func (c *Conflator) AddVenuesFromIterator(ctx context.Context, iterator_uri string, uris ...string) (int64, error) {
+
+	count := int64(0)
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		ok, err := c.AddVenue(body)
+
+		if err != nil {
+			slog.Warn("Failed to add venue", "path", path, "error", err)
+			return nil
+		}
+
+		if ok {
+			atomic.AddInt64(&count, 1)
+		}
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, iterator_uri, iter_cb)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, uris...)
+
+	if err != nil {
+		return atomic.LoadInt64(&count), fmt.Errorf("Failed to iterate URIs, %w", err)
+	}
+
+	return atomic.LoadInt64(&count), nil
+}
+
+// AddVenuesFromDatabase adds the venues in the `geojson` table of the Who's On First SQLite database at 'path' to
+// the conflator. It returns the number of venues added. Records are skipped, or logged and skipped, as they are by
+// `AddVenuesFromIterator`.
+func (c *Conflator) AddVenuesFromDatabase(ctx context.Context, path string) (int64, error) {
+
+	db, err := openDatabase(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, venues_query)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to query database, %w", err)
+	}
+
+	defer rows.Close()
+
+	count := int64(0)
+
+	for rows.Next() {
+
+		var body []byte
+
+		err := rows.Scan(&body)
+
+		if err != nil {
+			return count, fmt.Errorf("Failed to scan row, %w", err)
+		}
+
+		ok, err := c.AddVenue(body)
+
+		if err != nil {
+			slog.Warn("Failed to add venue", "error", err)
+			continue
+		}
+
+		if ok {
+			count += 1
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return count, fmt.Errorf("Failed to read rows, %w", err)
+	}
+
+	return count, nil
+}
+
+// NewDatabaseReader returns a new `DatabaseReader` instance for the Who's On First SQLite database at 'path'.
+func NewDatabaseReader(ctx context.Context, path string) (*DatabaseReader, error) {
+
+	db, err := openDatabase(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DatabaseReader{
+		db: db,
+	}
+
+	return r, nil
+}
+
+// Read returns the record for the Who's On First URI 'path', for example "101/736/545/101736545.geojson".
+// Alternate geometries are not supported.
+func (r *DatabaseReader) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	id, uri_args, err := uri.ParseURI(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s, %w", path, err)
+	}
+
+	if uri_args.IsAlternate {
+		return nil, fmt.Errorf("Alternate geometries are not supported")
+	}
+
+	var body []byte
+
+	err = r.db.QueryRowContext(ctx, venue_query, id).Scan(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %d, %w", id, err)
+	}
+
+	return ioutil.NewReadSeekCloser(bytes.NewReader(body))
+}
+
+// ReaderURI returns the value of 'path'.
+func (r *DatabaseReader) ReaderURI(ctx context.Context, path string) string {
+	return path
+}
+
+// Close closes the underlying database.
+func (r *DatabaseReader) Close() error {
+	return r.db.Close()
+}
+
+// openDatabase opens the Who's On First SQLite database at 'path' for reading.
+func openDatabase(path string) (*sql.DB, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=ro", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	return db, nil
+}
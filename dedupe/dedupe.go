@@ -0,0 +1,429 @@
+// Package dedupe provides methods for finding clusters of (near-)duplicate Foursquare places, for example the
+// same business entered twice a few metres apart.
+//
+// Candidate pairs are blocked by spatial cell: only places within a maximum distance of each other, found using
+// an in-memory `spatial.Index`, with similar names are compared. Pairs are scored on the similarity of their
+// names and addresses, the distance between them, whether they share a telephone number or website and whether
+// their categories are compatible. Places linked by pairs which score above a threshold are grouped in to
+// clusters and the most complete, current place in each cluster is suggested as its survivor.
+package dedupe
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+	"github.com/whosonfirst/go-foursquare-places/spatial"
+)
+
+// DEFAULT_MAX_DISTANCE is the default maximum distance, in metres, between places which are compared.
+const DEFAULT_MAX_DISTANCE float64 = 100.0
+
+// DEFAULT_THRESHOLD is the default minimum score for a pair of places to be considered duplicates.
+const DEFAULT_THRESHOLD float64 = 0.75
+
+// DEFAULT_MIN_NAME_SIMILARITY is the default minimum similarity of the names of places which are compared.
+const DEFAULT_MIN_NAME_SIMILARITY float64 = 0.5
+
+// Options defines configuration options for creating a new `Deduper`.
+type Options struct {
+	// MaxDistance is the maximum distance, in metres, between places which are compared. Default is
+	// `DEFAULT_MAX_DISTANCE`.
+	MaxDistance float64
+	// Threshold is the minimum score for a pair of places to be considered duplicates. Default is
+	// `DEFAULT_THRESHOLD`.
+	Threshold float64
+	// MinNameSimilarity is the minimum similarity of the names of places which are compared. Default is
+	// `DEFAULT_MIN_NAME_SIMILARITY`.
+	MinNameSimilarity float64
+	// Workers is the number of goroutines used to compare places. If 0 then `runtime.NumCPU` will be used.
+	Workers int
+}
+
+// Member is a place in a `Cluster`.
+type Member struct {
+	// The Foursquare ID of the place.
+	Id string `json:"id"`
+	// The name of the place.
+	Name string `json:"name"`
+	// The latitude of the place.
+	Latitude float64 `json:"latitude"`
+	// The longitude of the place.
+	Longitude float64 `json:"longitude"`
+	// Whether the place has been closed.
+	Closed bool `json:"closed"`
+	// The highest score of the pairs which include the place.
+	Score float64 `json:"score"`
+}
+
+// Pair is a pair of places which are considered duplicates.
+type Pair struct {
+	// The Foursquare ID of the first place, which is always less than the ID of the second place.
+	A string `json:"a"`
+	// The Foursquare ID of the second place.
+	B string `json:"b"`
+	// The score for the pair.
+	Score *Score `json:"score"`
+}
+
+// Cluster is a group of places which are considered duplicates of each other.
+type Cluster struct {
+	// The Foursquare ID of the place suggested as the one to keep.
+	Survivor string `json:"survivor"`
+	// The average score of the pairs in the cluster.
+	Score float64 `json:"score"`
+	// The places in the cluster. The survivor is always first, followed by the other places ordered by ID.
+	Members []*Member `json:"members"`
+	// The pairs of places which link the members of the cluster, ordered by score (highest first).
+	Pairs []*Pair `json:"pairs"`
+}
+
+// Deduper accumulates places and finds clusters of duplicates among them.
+type Deduper struct {
+	max_distance        float64
+	threshold           float64
+	min_name_similarity float64
+	workers             int
+	builder             *spatial.Builder
+	// The records for each place, in the order they were added. Coordinates and IDs are also stored by the
+	// (columnar) spatial index so records are looked up by their position rather than by ID.
+	records []*Record
+}
+
+// NewDeduper returns a new `Deduper` instance configured by 'opts'.
+func NewDeduper(opts *Options) *Deduper {
+
+	d := &Deduper{
+		max_distance:        DEFAULT_MAX_DISTANCE,
+		threshold:           DEFAULT_THRESHOLD,
+		min_name_similarity: DEFAULT_MIN_NAME_SIMILARITY,
+		builder:             spatial.NewBuilder(nil),
+		records:             make([]*Record, 0),
+	}
+
+	if opts != nil {
+
+		if opts.MaxDistance > 0 {
+			d.max_distance = opts.MaxDistance
+		}
+
+		if opts.Threshold > 0 {
+			d.threshold = opts.Threshold
+		}
+
+		if opts.MinNameSimilarity > 0 {
+			d.min_name_similarity = opts.MinNameSimilarity
+		}
+
+		d.workers = opts.Workers
+	}
+
+	return d
+}
+
+// AddPlace adds 'pl' to the deduper. Places must have unique IDs but, rather than keeping a lookup table of every
+// ID, this is checked when clusters are derived.
+func (d *Deduper) AddPlace(pl *places.Place) error {
+
+	if d.builder == nil {
+		return fmt.Errorf("Places can not be added after clusters have been derived")
+	}
+
+	if pl.Id == "" {
+		return fmt.Errorf("Place is missing a Foursquare ID")
+	}
+
+	err := d.builder.Add(pl.Id, pl.Latitude, pl.Longitude)
+
+	if err != nil {
+		return err
+	}
+
+	d.records = append(d.records, NewRecord(pl))
+	return nil
+}
+
+// Len returns the number of places which have been added to the deduper.
+func (d *Deduper) Len() int {
+	return len(d.records)
+}
+
+// Clusters compares every place which has been added to the deduper with the places near it and returns the
+// clusters of duplicates, ordered by the ID of their survivor. Places without duplicates are not included. Once
+// this method has been called no more places can be added.
+func (d *Deduper) Clusters(ctx context.Context) ([]*Cluster, error) {
+
+	if d.builder == nil {
+		return nil, fmt.Errorf("Clusters have already been derived")
+	}
+
+	idx := d.builder.Build()
+	d.builder = nil
+
+	err := d.checkIds()
+
+	if err != nil {
+		return nil, err
+	}
+
+	points_seq := func(yield func(*spatial.Point, error) bool) {
+
+		for pt := range idx.Points() {
+
+			if !yield(pt, nil) {
+				return
+			}
+		}
+	}
+
+	pairs := make([]*Pair, 0)
+
+	// The number of pairs is read by the progress function while pairs are being added
+	pair_count := int64(0)
+
+	pipeline_opts := &pipeline.Options[*spatial.Point, []*Pair]{
+		Workers: d.workers,
+		Process: func(ctx context.Context, pt *spatial.Point) ([]*Pair, error) {
+			return d.comparePoint(idx, pt), nil
+		},
+		Sink: func(ctx context.Context, pt_pairs []*Pair) error {
+			pairs = append(pairs, pt_pairs...)
+			atomic.AddInt64(&pair_count, int64(len(pt_pairs)))
+			return nil
+		},
+		Progress: func(stats *pipeline.Stats) {
+			slog.Debug("Comparing places", "places", idx.Len(), "processed", stats.Processed, "pairs", atomic.LoadInt64(&pair_count), "elapsed", stats.Elapsed)
+		},
+	}
+
+	_, err = pipeline.Run(ctx, points_seq, pipeline_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compare places, %w", err)
+	}
+
+	return d.clusters(pairs), nil
+}
+
+// checkIds returns an error if more than one place with the same ID has been added to the deduper.
+func (d *Deduper) checkIds() error {
+
+	order := make([]uint32, len(d.records))
+
+	for i := range order {
+		order[i] = uint32(i)
+	}
+
+	slices.SortFunc(order, func(a uint32, b uint32) int {
+		return cmp.Compare(d.records[a].Id, d.records[b].Id)
+	})
+
+	for i := 1; i < len(order); i++ {
+
+		id := d.records[order[i]].Id
+
+		if id == d.records[order[i-1]].Id {
+			return fmt.Errorf("Place %s has been added more than once", id)
+		}
+	}
+
+	return nil
+}
+
+// comparePoint returns the pairs of duplicates for 'pt' and the places within the maximum distance of it which
+// follow it in 'idx', so that each pair is only compared once.
+func (d *Deduper) comparePoint(idx *spatial.Index, pt *spatial.Point) []*Pair {
+
+	pairs := make([]*Pair, 0)
+
+	a := d.records[idx.Added(pt.Index)]
+
+	for _, r := range idx.Radius(pt.Latitude, pt.Longitude, d.max_distance, nil) {
+
+		if r.Index <= pt.Index {
+			continue
+		}
+
+		b := d.records[idx.Added(r.Index)]
+
+		// Compare names first since they rule out most neighbours without scoring any other properties
+
+		if reversegeo.NameSimilarity(a.name, b.name) < d.min_name_similarity {
+			continue
+		}
+
+		s := Compare(a, b, d.max_distance)
+
+		if s.Score < d.threshold {
+			continue
+		}
+
+		p := &Pair{
+			A:     min(a.Id, b.Id),
+			B:     max(a.Id, b.Id),
+			Score: s,
+		}
+
+		pairs = append(pairs, p)
+	}
+
+	return pairs
+}
+
+// clusters groups the places linked by 'pairs' in to clusters.
+func (d *Deduper) clusters(pairs []*Pair) []*Cluster {
+
+	parents := make(map[string]string)
+
+	var find func(string) string
+
+	find = func(id string) string {
+
+		p, exists := parents[id]
+
+		if !exists || p == id {
+			return id
+		}
+
+		root := find(p)
+		parents[id] = root
+		return root
+	}
+
+	for _, p := range pairs {
+
+		a := find(p.A)
+		b := find(p.B)
+
+		if a != b {
+			parents[max(a, b)] = min(a, b)
+		}
+	}
+
+	members := make(map[string][]string)
+	cluster_pairs := make(map[string][]*Pair)
+	scores := make(map[string]float64)
+
+	for _, p := range pairs {
+
+		root := find(p.A)
+		cluster_pairs[root] = append(cluster_pairs[root], p)
+
+		scores[p.A] = max(scores[p.A], p.Score.Score)
+		scores[p.B] = max(scores[p.B], p.Score.Score)
+	}
+
+	for id, _ := range scores {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+
+	// Look up the records for the (relatively few) places which are members of a cluster
+
+	member_records := make(map[string]*Record, len(scores))
+
+	for _, r := range d.records {
+
+		_, exists := scores[r.Id]
+
+		if exists {
+			member_records[r.Id] = r
+		}
+	}
+
+	clusters := make([]*Cluster, 0, len(members))
+
+	for root, ids := range members {
+
+		records := make([]*Record, len(ids))
+
+		for i, id := range ids {
+			records[i] = member_records[id]
+		}
+
+		slices.SortFunc(records, compareSurvivors)
+
+		survivor := records[0]
+
+		slices.SortFunc(records[1:], func(a *Record, b *Record) int {
+			return cmp.Compare(a.Id, b.Id)
+		})
+
+		c := &Cluster{
+			Survivor: survivor.Id,
+			Members:  make([]*Member, len(records)),
+			Pairs:    cluster_pairs[root],
+		}
+
+		for i, r := range records {
+
+			c.Members[i] = &Member{
+				Id:        r.Id,
+				Name:      r.Name,
+				Latitude:  r.Latitude,
+				Longitude: r.Longitude,
+				Closed:    r.Closed,
+				Score:     scores[r.Id],
+			}
+		}
+
+		slices.SortFunc(c.Pairs, func(a *Pair, b *Pair) int {
+			return cmp.Or(cmp.Compare(b.Score.Score, a.Score.Score), cmp.Compare(a.A, b.A), cmp.Compare(a.B, b.B))
+		})
+
+		total := 0.0
+
+		for _, p := range c.Pairs {
+			total += p.Score.Score
+		}
+
+		c.Score = total / float64(len(c.Pairs))
+
+		clusters = append(clusters, c)
+	}
+
+	slices.SortFunc(clusters, func(a *Cluster, b *Cluster) int {
+		return cmp.Compare(a.Survivor, b.Survivor)
+	})
+
+	return clusters
+}
+
+// compareSurvivors orders 'a' and 'b' by their suitability as the survivor of a cluster: Places which are still
+// open come first, followed by the places with the most properties, the most recently refreshed places and the
+// oldest places. Any remaining ties are broken by ID.
+func compareSurvivors(a *Record, b *Record) int {
+
+	closed := func(r *Record) int {
+
+		if r.Closed {
+			return 1
+		}
+
+		return 0
+	}
+
+	return cmp.Or(
+		cmp.Compare(closed(a), closed(b)),
+		cmp.Compare(b.Completeness, a.Completeness),
+		cmp.Compare(b.DateRefreshed, a.DateRefreshed),
+		cmp.Compare(oldest(a.DateCreated), oldest(b.DateCreated)),
+		cmp.Compare(a.Id, b.Id),
+	)
+}
+
+// oldest returns 'date' or, if it is empty, a value which sorts after any date.
+func oldest(date string) string {
+
+	if date == "" {
+		return "~"
+	}
+
+	return date
+}
@@ -0,0 +1,289 @@
+package dedupe
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unique"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+)
+
+// The weights of each component of a `Score`. Components which are unknown are not counted.
+const (
+	name_weight       float64 = 0.35
+	distance_weight   float64 = 0.15
+	telephone_weight  float64 = 0.15
+	website_weight    float64 = 0.10
+	address_weight    float64 = 0.10
+	categories_weight float64 = 0.15
+)
+
+// UNKNOWN is the value of a `Score` component which can't be derived because one or both places are missing the
+// relevant property.
+const UNKNOWN float64 = -1.0
+
+// min_telephone_digits is the minimum number of digits two telephone numbers must share for one to be considered
+// the other with a different (or missing) country or area code.
+const min_telephone_digits int = 7
+
+// Record is the (normalized) subset of the properties of a place which are compared to score candidate pairs.
+type Record struct {
+	// The Foursquare ID of the place.
+	Id string
+	// The name of the place, as-is.
+	Name string
+	// The latitude of the place.
+	Latitude float64
+	// The longitude of the place.
+	Longitude float64
+	// Whether the place has been closed.
+	Closed bool
+	// The date the place was created in the Foursquare dataset.
+	DateCreated string
+	// The date the place was last refreshed in the Foursquare dataset.
+	DateRefreshed string
+	// The number of optional properties (address, telephone, website, etc.) the place has.
+	Completeness   int
+	name           string
+	address        string
+	telephone      string
+	website        string
+	categories     []string
+	category_roots []string
+}
+
+// Score is the score for a pair of places. Every component is a value between 0.0 and 1.0, or `UNKNOWN`.
+type Score struct {
+	// The distance, in metres, between the places.
+	Distance float64 `json:"distance"`
+	// The similarity of the (normalized) names of the places.
+	Name float64 `json:"name"`
+	// 1.0 if the places have the same telephone number, 0.0 if they don't.
+	Telephone float64 `json:"telephone"`
+	// 1.0 if the places have the same website, 0.5 if their websites have the same host and 0.0 otherwise.
+	Website float64 `json:"website"`
+	// The similarity of the (normalized) addresses of the places.
+	Address float64 `json:"address"`
+	// 1.0 if the places share a category, 0.5 if they share a top-level category and 0.0 otherwise.
+	Categories float64 `json:"categories"`
+	// The weighted average of the known components, including the distance which is scored relative to the
+	// maximum distance between places.
+	Score float64 `json:"score"`
+}
+
+// NewRecord returns a new `Record` instance for 'pl'. Values which are shared by many places, like categories and
+// dates, are interned so that records for large numbers of places share a single copy of them.
+func NewRecord(pl *places.Place) *Record {
+
+	r := &Record{
+		Id:             pl.Id,
+		Name:           pl.Name,
+		Latitude:       pl.Latitude,
+		Longitude:      pl.Longitude,
+		Closed:         pl.DateClosed != "",
+		DateCreated:    intern(pl.DateCreated),
+		DateRefreshed:  intern(pl.DateRefreshed),
+		name:           reversegeo.NormalizeName(pl.Name),
+		address:        reversegeo.NormalizeName(pl.Address),
+		telephone:      NormalizeTelephone(pl.Telephone),
+		website:        NormalizeWebsite(pl.Website),
+		categories:     make([]string, 0, len(pl.Categories)),
+		category_roots: make([]string, 0, len(pl.Categories)),
+	}
+
+	for _, c := range pl.Categories {
+
+		r.categories = append(r.categories, intern(c.Id))
+
+		if len(c.Labels) > 0 {
+			r.category_roots = append(r.category_roots, intern(c.Labels[0]))
+		}
+	}
+
+	for _, v := range []string{pl.Address, pl.PostCode, pl.Locality, pl.Telephone, pl.Website, pl.Email, pl.FacebookId, pl.Instagram, pl.Twitter} {
+
+		if v != "" {
+			r.Completeness += 1
+		}
+	}
+
+	if len(pl.Categories) > 0 {
+		r.Completeness += 1
+	}
+
+	return r
+}
+
+// Compare returns the `Score` for 'a' and 'b' where places 'max_distance' metres (or more) apart have a distance
+// score of 0.0.
+func Compare(a *Record, b *Record, max_distance float64) *Score {
+
+	s := &Score{
+		Distance:   geo.DistanceHaversine(orb.Point{a.Longitude, a.Latitude}, orb.Point{b.Longitude, b.Latitude}),
+		Name:       reversegeo.NameSimilarity(a.name, b.name),
+		Telephone:  UNKNOWN,
+		Website:    UNKNOWN,
+		Address:    UNKNOWN,
+		Categories: UNKNOWN,
+	}
+
+	if a.telephone != "" && b.telephone != "" {
+		s.Telephone = compareTelephones(a.telephone, b.telephone)
+	}
+
+	if a.website != "" && b.website != "" {
+		s.Website = compareWebsites(a.website, b.website)
+	}
+
+	if a.address != "" && b.address != "" {
+		s.Address = reversegeo.NameSimilarity(a.address, b.address)
+	}
+
+	if len(a.categories) > 0 && len(b.categories) > 0 {
+		s.Categories = compareCategories(a, b)
+	}
+
+	distance_score := 0.0
+
+	if max_distance > 0 {
+		distance_score = max(1.0-s.Distance/max_distance, 0.0)
+	}
+
+	total := name_weight*s.Name + distance_weight*distance_score
+	weights := name_weight + distance_weight
+
+	for _, c := range []struct {
+		value  float64
+		weight float64
+	}{
+		{s.Telephone, telephone_weight},
+		{s.Website, website_weight},
+		{s.Address, address_weight},
+		{s.Categories, categories_weight},
+	} {
+
+		if c.value == UNKNOWN {
+			continue
+		}
+
+		total += c.weight * c.value
+		weights += c.weight
+	}
+
+	s.Score = total / weights
+	return s
+}
+
+// intern returns the canonical copy of 's'.
+func intern(s string) string {
+	return unique.Make(s).Value()
+}
+
+// NormalizeTelephone returns the digits in 'tel'.
+func NormalizeTelephone(tel string) string {
+
+	var b strings.Builder
+
+	for _, r := range tel {
+
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// NormalizeWebsite returns 'website' as a lower-cased host and path, without a scheme, "www." prefix, query or
+// trailing slash, for example "example.com/store/123".
+func NormalizeWebsite(website string) string {
+
+	website = strings.ToLower(strings.TrimSpace(website))
+
+	if website == "" {
+		return ""
+	}
+
+	if !strings.Contains(website, "://") {
+		website = "http://" + website
+	}
+
+	u, err := url.Parse(website)
+
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(u.Host, "www.")
+	path := strings.TrimSuffix(u.Path, "/")
+
+	return host + path
+}
+
+// compareTelephones returns 1.0 if 'a' and 'b' are the same number, allowing for one of them to be missing a
+// country or area code, and 0.0 otherwise.
+func compareTelephones(a string, b string) float64 {
+
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	if len(a) >= min_telephone_digits && strings.HasSuffix(b, a) {
+		return 1.0
+	}
+
+	if a == b {
+		return 1.0
+	}
+
+	return 0.0
+}
+
+// compareWebsites returns 1.0 if 'a' and 'b' are the same website, 0.5 if they have the same host (for example
+// the pages for two branches of a chain) and 0.0 otherwise.
+func compareWebsites(a string, b string) float64 {
+
+	if a == b {
+		return 1.0
+	}
+
+	a_host, _, _ := strings.Cut(a, "/")
+	b_host, _, _ := strings.Cut(b, "/")
+
+	if a_host == b_host {
+		return 0.5
+	}
+
+	return 0.0
+}
+
+// compareCategories returns 1.0 if 'a' and 'b' share a category, 0.5 if they share a top-level category and
+// 0.0 otherwise.
+func compareCategories(a *Record, b *Record) float64 {
+
+	for _, c := range a.categories {
+
+		for _, other := range b.categories {
+
+			if c == other {
+				return 1.0
+			}
+		}
+	}
+
+	for _, c := range a.category_roots {
+
+		for _, other := range b.category_roots {
+
+			if c == other {
+				return 0.5
+			}
+		}
+	}
+
+	return 0.0
+}
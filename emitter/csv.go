@@ -10,9 +10,13 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sfomuseum/go-csvdict/v2"
 	"github.com/whosonfirst/go-foursquare-places"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CSVEmitter struct {
@@ -66,98 +70,135 @@ func (e *CSVEmitter) Emit(ctx context.Context) iter.Seq2[*places.Place, error] {
 			return
 		}
 
+		// Each row is traced from the time the previous row was yielded so that spans
+		// include the time spent reading (and decompressing) the row.
+
+		t_read := time.Now()
+
 		for row, err := range csv_r.Iterate() {
 
+			_, span := tracer.Start(ctx, "csv.Emit", trace.WithTimestamp(t_read))
+
+			if ctx.Err() != nil {
+				span.End()
+				yield(nil, ctx.Err())
+				return
+			}
+
 			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				span.End()
 				yield(nil, err)
 				break
 			}
 
-			lat, err := strconv.ParseFloat(row["latitude"], 64)
+			pl := NewPlaceFromRow(row)
 
-			if err != nil {
-				// slog.Warn("Failed to parse latitude", "id", row["fsq_place_id"], "name", row["name"], "latitude", row["latitude"], "error", err)
-				lat = 0.0
-			}
+			span.SetAttributes(attribute.String("4sq.id", pl.Id))
+			span.End()
 
-			lon, err := strconv.ParseFloat(row["longitude"], 64)
+			// The span is ended before the place is processed but it is recorded on the place so that
+			// spans for processing it (see ContextWithPlace) are nested under it.
 
-			if err != nil {
-				// slog.Warn("Failed to parse longitude", "id", row["fsq_place_id"], "name", row["name"], "longitude", row["longitude"], "error", err)
-				lon = 0.0
-			}
+			pl.SetSpanContext(span.SpanContext())
 
-			pl := &places.Place{
-				Id:            row["fsq_place_id"],
-				Name:          row["name"],
-				Address:       row["address"],
-				DateClosed:    row["date_closed"],
-				DateCreated:   row["date_created"],
-				DateRefreshed: row["date_refreshed"],
-				Email:         row["email"],
-				FacebookId:    row["facebook_id"],
-				Instagram:     row["instagram"],
-				PostBox:       row["po_box"],
-				PostTown:      row["post_town"],
-				PostCode:      row["post_code"],
-				Region:        row["region"],
-				Telephone:     row["telephone"],
-				Twitter:       row["twitter"],
-				Website:       row["website"],
-				Country:       row["country"],
-				Latitude:      lat,
-				Longitude:     lon,
+			if !yield(pl, nil) {
+				return
 			}
 
-			categories := make([]places.Category, 0)
+			t_read = time.Now()
+		}
+	}
+}
 
-			str_category_ids := row["fsq_category_ids"]
-			str_category_ids = strings.TrimLeft(str_category_ids, "[")
-			str_category_ids = strings.TrimLeft(str_category_ids, "]")
+// NewPlaceFromRow returns a new `places.Place` instance derived from 'row' which is expected to be a row of
+// Foursquare places CSV data keyed by column name.
+func NewPlaceFromRow(row map[string]string) *places.Place {
 
-			str_category_labels := row["fsq_category_labels"]
-			str_category_labels = strings.TrimLeft(str_category_labels, "[")
-			str_category_labels = strings.TrimLeft(str_category_labels, "]")
+	lat, err := strconv.ParseFloat(row["latitude"], 64)
 
-			category_ids := strings.Split(str_category_ids, ", ")
-			category_labels := strings.Split(str_category_labels, ", ")
+	if err != nil {
+		// slog.Warn("Failed to parse latitude", "id", row["fsq_place_id"], "name", row["name"], "latitude", row["latitude"], "error", err)
+		lat = 0.0
+	}
 
-			if len(category_ids) == len(category_labels) {
+	lon, err := strconv.ParseFloat(row["longitude"], 64)
 
-				for i, id := range category_ids {
+	if err != nil {
+		// slog.Warn("Failed to parse longitude", "id", row["fsq_place_id"], "name", row["name"], "longitude", row["longitude"], "error", err)
+		lon = 0.0
+	}
 
-					c := places.Category{
-						Id:     id,
-						Labels: strings.Split(category_labels[i], " > "),
-					}
+	pl := &places.Place{
+		Id:            row["fsq_place_id"],
+		Name:          row["name"],
+		Address:       row["address"],
+		DateClosed:    row["date_closed"],
+		DateCreated:   row["date_created"],
+		DateRefreshed: row["date_refreshed"],
+		Email:         row["email"],
+		FacebookId:    row["facebook_id"],
+		Instagram:     row["instagram"],
+		Locality:      row["locality"],
+		AdminRegion:   row["admin_region"],
+		PostBox:       row["po_box"],
+		PostTown:      row["post_town"],
+		PostCode:      row["postcode"],
+		Region:        row["region"],
+		Telephone:     row["tel"],
+		Twitter:       row["twitter"],
+		Website:       row["website"],
+		Country:       row["country"],
+		Latitude:      lat,
+		Longitude:     lon,
+	}
 
-					categories = append(categories, c)
-				}
+	categories := make([]places.Category, 0)
 
-			} else {
+	str_category_ids := row["fsq_category_ids"]
+	str_category_ids = strings.TrimLeft(str_category_ids, "[")
+	str_category_ids = strings.TrimRight(str_category_ids, "]")
 
-				// slog.Info("C", "c", category_ids)
-				// slog.Info("C", "l", row["fsq_category_labels"])
+	str_category_labels := row["fsq_category_labels"]
+	str_category_labels = strings.TrimLeft(str_category_labels, "[")
+	str_category_labels = strings.TrimRight(str_category_labels, "]")
 
-				for _, id := range category_ids {
+	category_ids := strings.Split(str_category_ids, ", ")
+	category_labels := strings.Split(str_category_labels, ", ")
 
-					c := places.Category{
-						Id: id,
-						// Labels: strings.Split(category_labels[i], " > "),
-					}
+	if len(category_ids) == len(category_labels) {
 
-					categories = append(categories, c)
-				}
+		for i, id := range category_ids {
 
+			c := places.Category{
+				Id:     id,
+				Labels: strings.Split(category_labels[i], " > "),
 			}
 
-			pl.Categories = categories
+			categories = append(categories, c)
+		}
 
-			if !yield(pl, nil) {
-				return
+	} else {
+
+		// slog.Info("C", "c", category_ids)
+		// slog.Info("C", "l", row["fsq_category_labels"])
+
+		for _, id := range category_ids {
+
+			c := places.Category{
+				Id: id,
+				// Labels: strings.Split(category_labels[i], " > "),
 			}
+
+			categories = append(categories, c)
 		}
+
 	}
+
+	pl.Categories = categories
+
+	return pl
 }
 
 func (e *CSVEmitter) Close() error {
@@ -0,0 +1,158 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// places.csv.bz2 contains two places with the columns, in the same order, of the Foursquare open source places
+// release files.
+const test_path string = "testdata/places.csv.bz2"
+
+func TestCSVEmitter(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs(test_path)
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path, %v", err)
+	}
+
+	e, err := NewEmitter(ctx, fmt.Sprintf("csv://%s", abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to create emitter, %v", err)
+	}
+
+	defer e.Close()
+
+	emitted := make([]*places.Place, 0)
+
+	for pl, err := range e.Emit(ctx) {
+
+		if err != nil {
+			t.Fatalf("Failed to emit place, %v", err)
+		}
+
+		emitted = append(emitted, pl)
+	}
+
+	if len(emitted) != 2 {
+		t.Fatalf("Expected 2 places, got %d", len(emitted))
+	}
+
+	pl := emitted[0]
+
+	tests := map[string][2]string{
+		"fsq_place_id": {pl.Id, "cb57d89eed29405b908b0b6e"},
+		"address":      {pl.Address, "Mickiewicza 8"},
+		"locality":     {pl.Locality, "Koło"},
+		"region":       {pl.Region, "Wielkopolskie"},
+		"postcode":     {pl.PostCode, "62-600"},
+		"country":      {pl.Country, "PL"},
+		"tel":          {pl.Telephone, "63 272 08 68"},
+		"email":        {pl.Email, "teresa.glowacka.fotos.kolo@neostrada.pl"},
+		"date_created": {pl.DateCreated, "2015-05-06"},
+	}
+
+	for col, v := range tests {
+
+		if v[0] != v[1] {
+			t.Fatalf("Unexpected value for %s column, expected '%s' but got '%s'", col, v[1], v[0])
+		}
+	}
+
+	if pl.Latitude != 52.19266718928583 || pl.Longitude != 18.63343577621856 {
+		t.Fatalf("Unexpected coordinates %f, %f", pl.Latitude, pl.Longitude)
+	}
+
+	pl = emitted[1]
+
+	if pl.PostCode != "94105" || pl.Telephone != "(415) 555-0100" || pl.DateClosed != "2023-01-15" {
+		t.Fatalf("Unexpected values for %s, %+v", pl.Id, *pl)
+	}
+
+	if len(pl.Categories) != 2 {
+		t.Fatalf("Expected 2 categories, got %d", len(pl.Categories))
+	}
+
+	c := pl.Categories[1]
+
+	if c.Id != "4bf58dd8d48988d1e0931735" || !slices.Equal(c.Labels, []string{"Dining and Drinking", "Cafe", "Coffee Shop"}) {
+		t.Fatalf("Unexpected category %+v", c)
+	}
+}
+
+func TestCSVEmitterSpans(t *testing.T) {
+
+	ctx := context.Background()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+
+	defer otel.SetTracerProvider(previous)
+
+	abs_path, err := filepath.Abs(test_path)
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path, %v", err)
+	}
+
+	e, err := NewEmitter(ctx, fmt.Sprintf("csv://%s", abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to create emitter, %v", err)
+	}
+
+	defer e.Close()
+
+	// Spans started while processing a place are nested under the span in which it was emitted
+
+	for pl, err := range e.Emit(ctx) {
+
+		if err != nil {
+			t.Fatalf("Failed to emit place, %v", err)
+		}
+
+		_, span := tp.Tracer("test").Start(ContextWithPlace(ctx, pl), "process")
+		span.End()
+	}
+
+	spans := recorder.Ended()
+
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, got %d", len(spans))
+	}
+
+	for i := 0; i < len(spans); i += 2 {
+
+		emit := spans[i]
+		process := spans[i+1]
+
+		if emit.Name() != "csv.Emit" || process.Name() != "process" {
+			t.Fatalf("Unexpected spans %s, %s", emit.Name(), process.Name())
+		}
+
+		if process.Parent().SpanID() != emit.SpanContext().SpanID() || process.SpanContext().TraceID() != emit.SpanContext().TraceID() {
+			t.Fatalf("Expected process span to be nested under emit span")
+		}
+	}
+
+	// Places which were not traced leave the context unchanged
+
+	if ContextWithPlace(ctx, &places.Place{}) != ctx {
+		t.Fatalf("Expected context to be unchanged")
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/aaronland/go-roster"
 	"github.com/whosonfirst/go-foursquare-places"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Emitter interface {
@@ -19,6 +21,23 @@ type Emitter interface {
 
 var emitter_roster roster.Roster
 
+// tracer is the OpenTelemetry tracer used to trace emitting places. It does nothing unless a global tracer
+// provider has been configured (see the tracing package).
+var tracer = otel.Tracer("github.com/whosonfirst/go-foursquare-places/emitter")
+
+// ContextWithPlace returns a copy of 'ctx' whose parent span is the span in which 'pl' was emitted so that
+// spans started while processing 'pl' are nested under it. If 'pl' was not traced 'ctx' is returned as-is.
+func ContextWithPlace(ctx context.Context, pl *places.Place) context.Context {
+
+	sc := pl.SpanContext()
+
+	if !sc.IsValid() {
+		return ctx
+	}
+
+	return trace.ContextWithSpanContext(ctx, sc)
+}
+
 // EmitterInitializationFunc is a function defined by individual emitter package and used to create
 // an instance of that emitter
 type EmitterInitializationFunc func(ctx context.Context, uri string) (Emitter, error)
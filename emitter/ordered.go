@@ -0,0 +1,119 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/url"
+	"strconv"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/order"
+)
+
+// OrderedEmitter implements the `Emitter` interface and wraps another `Emitter` instance, yielding its places
+// ordered along a space-filling curve. Sorting happens out-of-core so the underlying emitter may contain more
+// places than can be held in memory.
+type OrderedEmitter struct {
+	Emitter
+	emitter      Emitter
+	sort_options *order.SortOptions
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterEmitter(ctx, "ordered", NewOrderedEmitter)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewOrderedEmitter returns a new `OrderedEmitter` instance configured by 'uri' which is expected to take the form of:
+//
+//	ordered://?emitter-uri={EMITTER_URI}&{PARAMETERS}
+//
+// Where {EMITTER_URI} is a (URL-escaped) registered `Emitter` URI and {PARAMETERS} may be:
+// * `curve` – The space-filling curve to order places along. Valid options are: hilbert, zorder, tile. Default is hilbert.
+// * `level` – The number of bits per dimension for the hilbert and zorder curves or the zoom level for the tile curve.
+// * `chunk-size` – The maximum number of places to sort in memory before writing them to disk.
+// * `tmpdir` – The directory where sorted chunks are written. Default is the operating system's temporary directory.
+func NewOrderedEmitter(ctx context.Context, uri string) (Emitter, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+
+	emitter_uri := q.Get("emitter-uri")
+
+	if emitter_uri == "" {
+		return nil, fmt.Errorf("Missing ?emitter-uri= parameter")
+	}
+
+	curve := order.HILBERT
+
+	if q.Has("curve") {
+		curve = q.Get("curve")
+	}
+
+	level := order.DefaultLevel(curve)
+
+	if q.Has("level") {
+
+		v, err := strconv.Atoi(q.Get("level"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?level= parameter, %w", err)
+		}
+
+		level = v
+	}
+
+	key_func, err := order.NewKeyFunc(curve, level)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create key function, %w", err)
+	}
+
+	sort_opts := &order.SortOptions{
+		KeyFunc: key_func,
+		TempDir: q.Get("tmpdir"),
+	}
+
+	if q.Has("chunk-size") {
+
+		v, err := strconv.Atoi(q.Get("chunk-size"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?chunk-size= parameter, %w", err)
+		}
+
+		sort_opts.ChunkSize = v
+	}
+
+	wrapped, err := NewEmitter(ctx, emitter_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create emitter, %w", err)
+	}
+
+	e := &OrderedEmitter{
+		emitter:      wrapped,
+		sort_options: sort_opts,
+	}
+
+	return e, nil
+}
+
+func (e *OrderedEmitter) Emit(ctx context.Context) iter.Seq2[*places.Place, error] {
+	return order.Sort(ctx, e.emitter.Emit(ctx), e.sort_options)
+}
+
+func (e *OrderedEmitter) Close() error {
+	return e.emitter.Close()
+}
@@ -0,0 +1,476 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/whosonfirst/go-foursquare-places/pipeline"
+)
+
+// DEFAULT_BATCH_SIZE is the default number of places to index in a single database transaction.
+const DEFAULT_BATCH_SIZE int = 100000
+
+// BuildOptions defines configuration options for the `Build` method.
+type BuildOptions struct {
+	// Workers is the number of bzip2 blocks to decompress concurrently. If 0 then `runtime.NumCPU` will be used.
+	Workers int
+	// BatchSize is the number of places to index in a single database transaction. Default is `DEFAULT_BATCH_SIZE`.
+	BatchSize int
+	// Progress is an optional function called after each batch of places has been indexed with the total
+	// number of places indexed so far.
+	Progress func(int64)
+}
+
+// indexedRow is the location of a single row in a CSV file.
+type indexedRow struct {
+	id     string
+	block  int64
+	offset int64
+}
+
+// Build adds the places in the CSV file at 'path', which may be bzip2-compressed, to the index returning the number
+// of places indexed. If 'path' has already been indexed it is re-indexed. Places which have the same ID as places in
+// another file are updated to point to 'path'.
+func (idx *Index) Build(ctx context.Context, path string, opts *BuildOptions) (int64, error) {
+
+	abs_path, err := absPath(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	batch_size := opts.BatchSize
+
+	if batch_size <= 0 {
+		batch_size = DEFAULT_BATCH_SIZE
+	}
+
+	fh, err := os.Open(abs_path)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to open %s, %w", abs_path, err)
+	}
+
+	defer fh.Close()
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to stat %s, %w", abs_path, err)
+	}
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(fh, magic)
+
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, fmt.Errorf("Failed to read %s, %w", abs_path, err)
+	}
+
+	_, err = fh.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to rewind %s, %w", abs_path, err)
+	}
+
+	if isParquet(magic) {
+		return 0, fmt.Errorf("Can not index %s, Parquet files are not supported, %w", abs_path, ErrUnsupportedFormat)
+	}
+
+	format := FORMAT_CSV
+
+	// The decompressed data and, for bzip2 files, the function used to derive the block for an offset
+	var data io.Reader
+	var block_for func(int64) (int64, int64)
+
+	var blocks []*bzBlock
+	var blocks_mu = new(sync.Mutex)
+
+	// The first error reading the decompressed data which is not the result of parsing finishing early
+	var decompress_err error
+	var decompress_wg = new(sync.WaitGroup)
+
+	if isBzip2(magic) {
+
+		format = FORMAT_BZIP2
+
+		slog.Debug("Scan bzip2 blocks", "path", abs_path)
+
+		blocks, err = scanBzip2Blocks(ctx, fh)
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to scan bzip2 blocks in %s, %w", abs_path, err)
+		}
+
+		slog.Debug("Found bzip2 blocks", "path", abs_path, "count", len(blocks))
+
+		// Decompress blocks concurrently, in order, and record the offset where each
+		// block's decompressed data starts as it is consumed
+
+		pr, pw := io.Pipe()
+
+		// Closing the pipe causes any pending writes by the sink to fail which stops the pipeline if
+		// parsing finishes early
+
+		defer func() {
+			pr.Close()
+			decompress_wg.Wait()
+		}()
+
+		// The number of blocks whose start offset is known
+		decompressed := 0
+		start := int64(0)
+
+		blocks_seq := func(yield func(*bzBlock, error) bool) {
+
+			for _, b := range blocks {
+
+				if !yield(b, nil) {
+					return
+				}
+			}
+		}
+
+		pipeline_opts := &pipeline.Options[*bzBlock, []byte]{
+			Workers: opts.Workers,
+			Ordered: true,
+			Process: func(ctx context.Context, b *bzBlock) ([]byte, error) {
+				return readBzip2Block(fh, b)
+			},
+			Sink: func(ctx context.Context, body []byte) error {
+
+				blocks_mu.Lock()
+				blocks[decompressed].Start = start
+				decompressed += 1
+				start += int64(len(body))
+				blocks_mu.Unlock()
+
+				_, err := pw.Write(body)
+				return err
+			},
+			ProcessErrors: pipeline.ErrorPolicy{
+				Halt: true,
+			},
+			SinkErrors: pipeline.ErrorPolicy{
+				Halt: true,
+			},
+			OnError: func(ctx context.Context, stage pipeline.Stage, err error) {
+
+				if !errors.Is(err, io.ErrClosedPipe) {
+					slog.Error("Failed to decompress block", "path", abs_path, "stage", stage, "error", err)
+				}
+			},
+		}
+
+		decompress_wg.Add(1)
+
+		go func() {
+
+			defer decompress_wg.Done()
+
+			_, err := pipeline.Run(ctx, blocks_seq, pipeline_opts)
+
+			if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				decompress_err = err
+			}
+
+			pw.CloseWithError(err)
+		}()
+
+		block_for = func(offset int64) (int64, int64) {
+
+			blocks_mu.Lock()
+			defer blocks_mu.Unlock()
+
+			// The block containing 'offset' is the last block which starts at or before it. Since
+			// data is only read after its block's start has been recorded it will always be found.
+
+			i := sort.Search(decompressed, func(i int) bool {
+				return blocks[i].Start > offset
+			})
+
+			b := int64(i - 1)
+			return b, offset - blocks[b].Start
+		}
+
+		data = pr
+
+	} else {
+
+		data = bufio.NewReaderSize(fh, 1024*1024)
+
+		block_for = func(offset int64) (int64, int64) {
+			return 0, offset
+		}
+	}
+
+	csv_r := csv.NewReader(data)
+	csv_r.ReuseRecord = true
+
+	header, err := csv_r.Read()
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read header for %s, %w", abs_path, err)
+	}
+
+	header = slices.Clone(header)
+
+	id_idx := slices.Index(header, ID_COLUMN)
+
+	if id_idx == -1 {
+		return 0, fmt.Errorf("%s is missing the %s column", abs_path, ID_COLUMN)
+	}
+
+	source_id, err := idx.resetSource(ctx, abs_path, format, header, info)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(0)
+	batch := make([]*indexedRow, 0, batch_size)
+
+	for {
+
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+
+		offset := csv_r.InputOffset()
+		record, err := csv_r.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return count, fmt.Errorf("Failed to read row at offset %d of %s, %w", offset, abs_path, err)
+		}
+
+		block, block_offset := block_for(offset)
+
+		batch = append(batch, &indexedRow{
+			id:     record[id_idx],
+			block:  block,
+			offset: block_offset,
+		})
+
+		if len(batch) < batch_size {
+			continue
+		}
+
+		err = idx.insertRows(ctx, source_id, batch)
+
+		if err != nil {
+			return count, err
+		}
+
+		count += int64(len(batch))
+		batch = batch[:0]
+
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+	}
+
+	err = idx.insertRows(ctx, source_id, batch)
+
+	if err != nil {
+		return count, err
+	}
+
+	count += int64(len(batch))
+
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+
+	if format == FORMAT_BZIP2 {
+
+		decompress_wg.Wait()
+
+		if decompress_err != nil {
+			return count, fmt.Errorf("Failed to decompress %s, %w", abs_path, decompress_err)
+		}
+
+		err = idx.insertBlocks(ctx, source_id, blocks)
+
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// isParquet returns true if 'header' starts with the magic bytes of a Parquet file.
+func isParquet(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PAR1"))
+}
+
+// resetSource creates or updates the record for the source at 'path' removing any places and blocks
+// previously indexed for it, returning the source's ID.
+func (idx *Index) resetSource(ctx context.Context, path string, format string, header []string, info os.FileInfo) (int64, error) {
+
+	enc_header, err := json.Marshal(header)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to marshal header for %s, %w", path, err)
+	}
+
+	tx, err := idx.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	var source_id int64
+
+	row := tx.QueryRowContext(ctx, "SELECT id FROM sources WHERE path = ?", path)
+	err = row.Scan(&source_id)
+
+	switch {
+	case err == sql.ErrNoRows:
+
+		q := "INSERT INTO sources (path, format, header, size, lastmodified) VALUES (?, ?, ?, ?, ?)"
+		rsp, err := tx.ExecContext(ctx, q, path, format, string(enc_header), info.Size(), info.ModTime().Unix())
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to add source %s, %w", path, err)
+		}
+
+		source_id, err = rsp.LastInsertId()
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to derive ID for source %s, %w", path, err)
+		}
+
+	case err != nil:
+		return 0, fmt.Errorf("Failed to query source %s, %w", path, err)
+	default:
+
+		q := "UPDATE sources SET format = ?, header = ?, size = ?, lastmodified = ? WHERE id = ?"
+		_, err := tx.ExecContext(ctx, q, format, string(enc_header), info.Size(), info.ModTime().Unix(), source_id)
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to update source %s, %w", path, err)
+		}
+
+		for _, q := range []string{"DELETE FROM places WHERE source_id = ?", "DELETE FROM blocks WHERE source_id = ?"} {
+
+			_, err := tx.ExecContext(ctx, q, source_id)
+
+			if err != nil {
+				return 0, fmt.Errorf("Failed to remove previously indexed data for %s, %w", path, err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	idx.mu.Lock()
+
+	src, exists := idx.sources[source_id]
+
+	if exists {
+		src.fh.Close()
+		delete(idx.sources, source_id)
+	}
+
+	idx.mu.Unlock()
+
+	return source_id, nil
+}
+
+func (idx *Index) insertRows(ctx context.Context, source_id int64, rows []*indexedRow) error {
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := idx.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO places (id, source_id, block, offset) VALUES (?, ?, ?, ?)")
+
+	if err != nil {
+		return fmt.Errorf("Failed to prepare statement, %w", err)
+	}
+
+	defer stmt.Close()
+
+	for _, r := range rows {
+
+		_, err := stmt.ExecContext(ctx, r.id, source_id, r.block, r.offset)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index place %s, %w", r.id, err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return nil
+}
+
+func (idx *Index) insertBlocks(ctx context.Context, source_id int64, blocks []*bzBlock) error {
+
+	tx, err := idx.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO blocks (source_id, block, bit_offset, bit_length, start) VALUES (?, ?, ?, ?, ?)")
+
+	if err != nil {
+		return fmt.Errorf("Failed to prepare statement, %w", err)
+	}
+
+	defer stmt.Close()
+
+	for i, b := range blocks {
+
+		_, err := stmt.ExecContext(ctx, source_id, i, b.Offset, b.Length, b.Start)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index block %d, %w", i, err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The bzip2 format is a sequence of (one or more) streams each of which is a sequence of compressed blocks.
+// Blocks are not byte-aligned but each one starts with a 48-bit magic number and the end of each stream is
+// marked by a different 48-bit magic number. Since blocks are compressed independently of one another any
+// block can be decompressed on its own by wrapping it in a new single-block stream. This is how places are
+// read from the middle of a large bzip2-compressed CSV file without decompressing everything before them.
+
+const bz_block_magic uint64 = 0x314159265359
+
+const bz_eos_magic uint64 = 0x177245385090
+
+const bz_magic_mask uint64 = 0xffffffffffff
+
+// bzBlock is the position of a single compressed block in a bzip2 file.
+type bzBlock struct {
+	// The offset, in bits, of the block's magic number from the start of the file.
+	Offset int64
+	// The length, in bits, of the block including its magic number and checksum.
+	Length int64
+	// The offset, in bytes, of the block's decompressed data from the start of the decompressed file.
+	Start int64
+}
+
+// isBzip2 returns true if 'header' (the first bytes of a file) is a bzip2 stream header.
+func isBzip2(header []byte) bool {
+	return len(header) >= 4 && bytes.Equal(header[0:3], []byte("BZh")) && header[3] >= '1' && header[3] <= '9'
+}
+
+// scanBzip2Blocks returns the position of every compressed block in the (possibly multi-stream) bzip2 data in 'r'.
+// The `Start` property of each block is not set since that requires decompressing the preceding blocks.
+func scanBzip2Blocks(ctx context.Context, r io.Reader) ([]*bzBlock, error) {
+
+	br := bufio.NewReaderSize(r, 1024*1024)
+
+	blocks := make([]*bzBlock, 0)
+
+	var current *bzBlock
+
+	// The most recent 64 bits read and the total number of bits read
+	var window uint64
+	var count int64
+
+	for {
+
+		b, err := br.ReadByte()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read byte at offset %d, %w", count/8, err)
+		}
+
+		if count%(1024*1024*8) == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		window = window<<8 | uint64(b)
+		count += 8
+
+		// Check every bit alignment ending in this byte, earliest first
+
+		for shift := 7; shift >= 0; shift-- {
+
+			pos := count - int64(shift) - 48
+
+			if pos < 0 {
+				continue
+			}
+
+			switch (window >> shift) & bz_magic_mask {
+			case bz_block_magic:
+
+				if current != nil {
+					current.Length = pos - current.Offset
+				}
+
+				current = &bzBlock{
+					Offset: pos,
+				}
+
+				blocks = append(blocks, current)
+
+			case bz_eos_magic:
+
+				if current != nil {
+					current.Length = pos - current.Offset
+					current = nil
+				}
+			}
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("Missing end of stream marker, data may be truncated")
+	}
+
+	return blocks, nil
+}
+
+// readBzip2Block returns the decompressed data for 'b' read from 'ra'.
+func readBzip2Block(ra io.ReaderAt, b *bzBlock) ([]byte, error) {
+
+	start := b.Offset / 8
+	end := (b.Offset + b.Length + 7) / 8
+
+	// The extra byte means there is always a next byte to shift bits in from
+	buf := make([]byte, end-start+1)
+
+	_, err := ra.ReadAt(buf[0:end-start], start)
+
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Failed to read compressed block at offset %d, %w", start, err)
+	}
+
+	wr := &bitWriter{
+		buf: make([]byte, 0, len(buf)+16),
+	}
+
+	// Stream header for the largest block size, since it's only used to size buffers
+
+	wr.WriteBits(uint64(binary.BigEndian.Uint32([]byte("BZh9"))), 32)
+
+	shift := uint(b.Offset % 8)
+	remaining := b.Length
+
+	i := 0
+
+	for remaining > 0 {
+
+		v := buf[i]<<shift | buf[i+1]>>(8-shift)
+
+		if remaining < 8 {
+			wr.WriteBits(uint64(v>>(8-remaining)), uint(remaining))
+			break
+		}
+
+		wr.WriteBits(uint64(v), 8)
+
+		remaining -= 8
+		i += 1
+	}
+
+	// The stream header and the block magic number are byte-aligned so the block's checksum is too. The
+	// checksum for a stream with a single block is the same as the block's checksum.
+
+	if len(wr.buf) < 14 {
+		return nil, fmt.Errorf("Invalid block at bit offset %d", b.Offset)
+	}
+
+	crc := binary.BigEndian.Uint32(wr.buf[10:14])
+
+	wr.WriteBits(bz_eos_magic, 48)
+	wr.WriteBits(uint64(crc), 32)
+	wr.Flush()
+
+	data, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(wr.buf)))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress block at bit offset %d, %w", b.Offset, err)
+	}
+
+	return data, nil
+}
+
+// bitWriter writes values of an arbitrary number of bits, most significant bit first, to a byte slice.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// WriteBits writes the lowest 'n' bits of 'v', where 'n' is no more than 56.
+func (wr *bitWriter) WriteBits(v uint64, n uint) {
+
+	wr.acc = wr.acc<<n | v&(1<<n-1)
+	wr.nbits += n
+
+	for wr.nbits >= 8 {
+		wr.buf = append(wr.buf, byte(wr.acc>>(wr.nbits-8)))
+		wr.nbits -= 8
+	}
+
+	wr.acc &= 1<<wr.nbits - 1
+}
+
+// Flush pads any remaining bits with zeros to a whole byte.
+func (wr *bitWriter) Flush() {
+
+	if wr.nbits > 0 {
+		wr.buf = append(wr.buf, byte(wr.acc<<(8-wr.nbits)))
+		wr.nbits = 0
+		wr.acc = 0
+	}
+}
+
+// blocksReader is an `io.Reader` for the decompressed data of a sequence of bzip2 blocks.
+type blocksReader struct {
+	ra     io.ReaderAt
+	blocks []*bzBlock
+	next   int
+	buf    []byte
+}
+
+func (r *blocksReader) Read(p []byte) (int, error) {
+
+	for len(r.buf) == 0 {
+
+		if r.next >= len(r.blocks) {
+			return 0, io.EOF
+		}
+
+		data, err := readBzip2Block(r.ra, r.blocks[r.next])
+
+		if err != nil {
+			return 0, err
+		}
+
+		r.buf = data
+		r.next += 1
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
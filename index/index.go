@@ -0,0 +1,406 @@
+// Package index provides methods for building and querying an index of the location of individual Foursquare
+// places in (bzip2-compressed or uncompressed) CSV files so that they can be read by ID without scanning the
+// entire file.
+package index
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+)
+
+// FORMAT_CSV is the format of uncompressed CSV sources.
+const FORMAT_CSV string = "csv"
+
+// FORMAT_BZIP2 is the format of bzip2-compressed CSV sources.
+const FORMAT_BZIP2 string = "bzip2"
+
+// ID_COLUMN is the name of the column containing the Foursquare ID of each place.
+const ID_COLUMN string = "fsq_place_id"
+
+// ErrNotFound is returned when there is no indexed place for a given ID.
+var ErrNotFound = errors.New("Place not found")
+
+// ErrUnsupportedFormat is returned when building an index for a file which is not a (possibly bzip2-compressed)
+// CSV file. Parquet files are detected and rejected with this error since there is no Parquet reader in this package.
+var ErrUnsupportedFormat = errors.New("Unsupported format")
+
+const index_schema string = `CREATE TABLE IF NOT EXISTS sources (
+	id INTEGER PRIMARY KEY,
+	path TEXT UNIQUE,
+	format TEXT,
+	header TEXT,
+	size INTEGER,
+	lastmodified INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+	source_id INTEGER,
+	block INTEGER,
+	bit_offset INTEGER,
+	bit_length INTEGER,
+	start INTEGER,
+	PRIMARY KEY (source_id, block)
+);
+
+CREATE TABLE IF NOT EXISTS places (
+	id TEXT PRIMARY KEY,
+	source_id INTEGER,
+	block INTEGER,
+	offset INTEGER
+) WITHOUT ROWID`
+
+// Location is the position of a place in an indexed CSV file.
+type Location struct {
+	// The Foursquare ID of the place.
+	Id string `json:"fsq_place_id"`
+	// The absolute path of the CSV file containing the place.
+	Path string `json:"path"`
+	// The format of the CSV file (FORMAT_CSV or FORMAT_BZIP2).
+	Format string `json:"format"`
+	// The index of the compressed block where the place's row starts. Always 0 for uncompressed files.
+	Block int64 `json:"block"`
+	// The offset, in bytes, of the place's row from the start of the block's decompressed data (or the start of
+	// the file for uncompressed files).
+	Offset int64 `json:"offset"`
+}
+
+// Index is an index of the location of Foursquare places in one or more CSV files, stored in a SQLite database.
+type Index struct {
+	db      *sql.DB
+	mu      *sync.Mutex
+	sources map[int64]*source
+}
+
+// source is an indexed CSV file.
+type source struct {
+	id           int64
+	path         string
+	format       string
+	header       []string
+	size         int64
+	lastmodified int64
+	blocks       []*bzBlock
+	fh           *os.File
+}
+
+// NewIndex returns a new `Index` instance for the SQLite database at 'path'. The database will be created
+// if it does not already exist.
+func NewIndex(ctx context.Context, path string) (*Index, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, index_schema)
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create schema, %w", err)
+	}
+
+	idx := &Index{
+		db:      db,
+		mu:      new(sync.Mutex),
+		sources: make(map[int64]*source),
+	}
+
+	return idx, nil
+}
+
+// Location returns the `Location` of the place with Foursquare ID 'id' or `ErrNotFound`.
+func (idx *Index) Location(ctx context.Context, id string) (*Location, error) {
+
+	src, block, offset, err := idx.lookup(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	loc := &Location{
+		Id:     id,
+		Path:   src.path,
+		Format: src.format,
+		Block:  block,
+		Offset: offset,
+	}
+
+	return loc, nil
+}
+
+// GetPlace returns the place with Foursquare ID 'id' or `ErrNotFound`.
+func (idx *Index) GetPlace(ctx context.Context, id string) (*places.Place, error) {
+
+	row, err := idx.GetRow(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return emitter.NewPlaceFromRow(row), nil
+}
+
+// GetRow returns the CSV row, keyed by column name, for the place with Foursquare ID 'id' or `ErrNotFound`.
+func (idx *Index) GetRow(ctx context.Context, id string) (map[string]string, error) {
+
+	src, block, offset, err := idx.lookup(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := src.readerAt(block, offset)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read place %s from %s, %w", id, src.path, err)
+	}
+
+	csv_r := csv.NewReader(r)
+	csv_r.FieldsPerRecord = -1
+
+	record, err := csv_r.Read()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse place %s from %s, %w", id, src.path, err)
+	}
+
+	if len(record) != len(src.header) {
+		return nil, fmt.Errorf("Unexpected number of columns for place %s in %s, the index may be out of date", id, src.path)
+	}
+
+	dict := make(map[string]string, len(record))
+
+	for i, v := range record {
+		dict[src.header[i]] = v
+	}
+
+	if dict[ID_COLUMN] != id {
+		return nil, fmt.Errorf("Unexpected place %s at the location of %s in %s, the index may be out of date", dict[ID_COLUMN], id, src.path)
+	}
+
+	return dict, nil
+}
+
+// Count returns the number of indexed places.
+func (idx *Index) Count(ctx context.Context) (int64, error) {
+
+	var count int64
+
+	row := idx.db.QueryRowContext(ctx, "SELECT COUNT(id) FROM places")
+	err := row.Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to count places, %w", err)
+	}
+
+	return count, nil
+}
+
+// Close closes the underlying database and any open CSV files.
+func (idx *Index) Close() error {
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, src := range idx.sources {
+		src.fh.Close()
+	}
+
+	idx.sources = make(map[int64]*source)
+
+	return idx.db.Close()
+}
+
+// lookup returns the source, block and offset for the place with Foursquare ID 'id' or `ErrNotFound`.
+func (idx *Index) lookup(ctx context.Context, id string) (*source, int64, int64, error) {
+
+	var source_id int64
+	var block int64
+	var offset int64
+
+	q := "SELECT source_id, block, offset FROM places WHERE id = ?"
+	row := idx.db.QueryRowContext(ctx, q, id)
+
+	err := row.Scan(&source_id, &block, &offset)
+
+	if err == sql.ErrNoRows {
+		return nil, 0, 0, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("Failed to query place %s, %w", id, err)
+	}
+
+	src, err := idx.source(ctx, source_id)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return src, block, offset, nil
+}
+
+// source returns the (open) source with ID 'id', loading it from the database if necessary.
+func (idx *Index) source(ctx context.Context, id int64) (*source, error) {
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	src, exists := idx.sources[id]
+
+	if exists {
+		return src, nil
+	}
+
+	src = &source{
+		id: id,
+	}
+
+	var str_header string
+
+	q := "SELECT path, format, header, size, lastmodified FROM sources WHERE id = ?"
+	row := idx.db.QueryRowContext(ctx, q, id)
+
+	err := row.Scan(&src.path, &src.format, &str_header, &src.size, &src.lastmodified)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query source %d, %w", id, err)
+	}
+
+	err = json.Unmarshal([]byte(str_header), &src.header)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal header for %s, %w", src.path, err)
+	}
+
+	if src.format == FORMAT_BZIP2 {
+
+		blocks, err := idx.blocks(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		src.blocks = blocks
+	}
+
+	fh, err := os.Open(src.path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", src.path, err)
+	}
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("Failed to stat %s, %w", src.path, err)
+	}
+
+	if info.Size() != src.size || info.ModTime().Unix() != src.lastmodified {
+		fh.Close()
+		return nil, fmt.Errorf("%s has been modified since it was indexed", src.path)
+	}
+
+	src.fh = fh
+	idx.sources[id] = src
+
+	return src, nil
+}
+
+func (idx *Index) blocks(ctx context.Context, source_id int64) ([]*bzBlock, error) {
+
+	q := "SELECT bit_offset, bit_length, start FROM blocks WHERE source_id = ? ORDER BY block ASC"
+	rows, err := idx.db.QueryContext(ctx, q, source_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query blocks for source %d, %w", source_id, err)
+	}
+
+	defer rows.Close()
+
+	blocks := make([]*bzBlock, 0)
+
+	for rows.Next() {
+
+		b := new(bzBlock)
+
+		err := rows.Scan(&b.Offset, &b.Length, &b.Start)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan block for source %d, %w", source_id, err)
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate blocks for source %d, %w", source_id, err)
+	}
+
+	return blocks, nil
+}
+
+// readerAt returns an `io.Reader` for the decompressed data of 'src' starting at 'offset' bytes from the
+// start of 'block'.
+func (src *source) readerAt(block int64, offset int64) (io.Reader, error) {
+
+	switch src.format {
+	case FORMAT_BZIP2:
+
+		if block < 0 || block >= int64(len(src.blocks)) {
+			return nil, fmt.Errorf("Invalid block %d", block)
+		}
+
+		r := &blocksReader{
+			ra:     src.fh,
+			blocks: src.blocks[block:],
+		}
+
+		_, err := io.CopyN(io.Discard, r, offset)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to seek to offset %d of block %d, %w", offset, block, err)
+		}
+
+		return r, nil
+
+	case FORMAT_CSV:
+		return io.NewSectionReader(src.fh, offset, src.size-offset), nil
+	default:
+		return nil, fmt.Errorf("Unsupported format '%s'", src.format)
+	}
+}
+
+// absPath returns the absolute path for 'path'.
+func absPath(path string) (string, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive absolute path for %s, %w", path, err)
+	}
+
+	return abs_path, nil
+}
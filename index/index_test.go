@@ -0,0 +1,212 @@
+package index
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// places.csv.bz2 is two concatenated bzip2 streams, each compressed with 100k blocks (bzip2 -1), containing
+// 8000 places whose rows regularly span block boundaries.
+const test_path string = "testdata/places.csv.bz2"
+
+// readTestRows returns the header and rows of the test file, decompressed in full.
+func readTestRows(t *testing.T) ([]string, [][]string) {
+
+	fh, err := os.Open(test_path)
+
+	if err != nil {
+		t.Fatalf("Failed to open %s, %v", test_path, err)
+	}
+
+	defer fh.Close()
+
+	csv_r := csv.NewReader(bzip2.NewReader(fh))
+
+	records, err := csv_r.ReadAll()
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", test_path, err)
+	}
+
+	return records[0], records[1:]
+}
+
+func TestReadBzip2Blocks(t *testing.T) {
+
+	ctx := context.Background()
+
+	fh, err := os.Open(test_path)
+
+	if err != nil {
+		t.Fatalf("Failed to open %s, %v", test_path, err)
+	}
+
+	defer fh.Close()
+
+	blocks, err := scanBzip2Blocks(ctx, fh)
+
+	if err != nil {
+		t.Fatalf("Failed to scan blocks, %v", err)
+	}
+
+	if len(blocks) != 6 {
+		t.Fatalf("Expected 6 blocks, got %d", len(blocks))
+	}
+
+	_, err = fh.Seek(0, 0)
+
+	if err != nil {
+		t.Fatalf("Failed to reset file handle, %v", err)
+	}
+
+	expected, err := io.ReadAll(bzip2.NewReader(fh))
+
+	if err != nil {
+		t.Fatalf("Failed to decompress %s, %v", test_path, err)
+	}
+
+	// Decompressing each block on its own, and in any order, should yield the same data as decompressing the whole file
+
+	data := make([][]byte, len(blocks))
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+
+		body, err := readBzip2Block(fh, blocks[i])
+
+		if err != nil {
+			t.Fatalf("Failed to read block %d, %v", i, err)
+		}
+
+		data[i] = body
+	}
+
+	if !bytes.Equal(bytes.Join(data, nil), expected) {
+		t.Fatalf("Decompressed blocks do not match decompressed file")
+	}
+}
+
+func TestBuildBzip2(t *testing.T) {
+
+	ctx := context.Background()
+
+	header, rows := readTestRows(t)
+
+	idx, err := NewIndex(ctx, filepath.Join(t.TempDir(), "index.db"))
+
+	if err != nil {
+		t.Fatalf("Failed to create index, %v", err)
+	}
+
+	defer idx.Close()
+
+	opts := &BuildOptions{
+		Workers:   2,
+		BatchSize: 1000,
+	}
+
+	count, err := idx.Build(ctx, test_path, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to build index, %v", err)
+	}
+
+	if count != int64(len(rows)) {
+		t.Fatalf("Expected %d places to be indexed, got %d", len(rows), count)
+	}
+
+	// Read the first and last place, every 500th place and the places either side of each block boundary
+
+	var previous *Location
+	checked := make(map[int]bool)
+
+	for i, row := range rows {
+
+		loc, err := idx.Location(ctx, row[0])
+
+		if err != nil {
+			t.Fatalf("Failed to derive location for %s, %v", row[0], err)
+		}
+
+		if loc.Format != FORMAT_BZIP2 {
+			t.Fatalf("Unexpected format for %s, %s", row[0], loc.Format)
+		}
+
+		if previous != nil && loc.Block < previous.Block {
+			t.Fatalf("Unexpected block %d for %s, which follows a place in block %d", loc.Block, row[0], previous.Block)
+		}
+
+		if i == 0 || i == len(rows)-1 || i%500 == 0 {
+			checked[i] = true
+		}
+
+		if previous != nil && loc.Block != previous.Block {
+			checked[i-1] = true
+			checked[i] = true
+		}
+
+		previous = loc
+	}
+
+	if previous.Block != 5 {
+		t.Fatalf("Expected the last place to be in block 5, got %d", previous.Block)
+	}
+
+	for i := range checked {
+
+		id := rows[i][0]
+
+		dict, err := idx.GetRow(ctx, id)
+
+		if err != nil {
+			t.Fatalf("Failed to get row for %s, %v", id, err)
+		}
+
+		for j, k := range header {
+
+			if dict[k] != rows[i][j] {
+				t.Fatalf("Unexpected value for %s of %s, expected '%s' but got '%s'", k, id, rows[i][j], dict[k])
+			}
+		}
+	}
+
+	_, err = idx.GetRow(ctx, "missing")
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBuildParquet(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "places.parquet")
+
+	err := os.WriteFile(path, []byte("PAR1\x15\x04PAR1"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", path, err)
+	}
+
+	idx, err := NewIndex(ctx, filepath.Join(dir, "index.db"))
+
+	if err != nil {
+		t.Fatalf("Failed to create index, %v", err)
+	}
+
+	defer idx.Close()
+
+	_, err = idx.Build(ctx, path, &BuildOptions{})
+
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Expected Parquet file to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-reader"
+)
+
+// FoursquareReader implements the `whosonfirst/go-reader.Reader` interface for reading Foursquare places,
+// encoded as GeoJSON Features, from an `Index`.
+type FoursquareReader struct {
+	reader.Reader
+	index *Index
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := reader.RegisterReader(ctx, "fsq", NewFoursquareReader)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewFoursquareReader returns a new `FoursquareReader` instance configured by 'uri' which is expected to
+// take the form of:
+//
+//	fsq://{PATH_TO_INDEX_DATABASE}
+//
+// The scheme is "fsq" rather than "4sq" because URL schemes may not start with a digit.
+func NewFoursquareReader(ctx context.Context, uri string) (reader.Reader, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Account for relative paths like fsq://index.db
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing index path")
+	}
+
+	idx, err := NewIndex(ctx, path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create index, %w", err)
+	}
+
+	r := &FoursquareReader{
+		index: idx,
+	}
+
+	return r, nil
+}
+
+// Read returns the place whose Foursquare ID is 'path', encoded as a GeoJSON Feature. For compatibility with
+// other readers 'path' may also be a filename like "{ID}.geojson".
+func (r *FoursquareReader) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	id := strings.TrimSuffix(filepath.Base(path), ".geojson")
+
+	pl, err := r.index.GetPlace(ctx, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read place %s, %w", id, err)
+	}
+
+	f, err := pl.AsFeature()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive feature for place %s, %w", id, err)
+	}
+
+	body, err := f.MarshalJSON()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal feature for place %s, %w", id, err)
+	}
+
+	return ioutil.NewReadSeekCloser(bytes.NewReader(body))
+}
+
+// ReaderURI returns the value of 'path'.
+func (r *FoursquareReader) ReaderURI(ctx context.Context, path string) string {
+	return path
+}
+
+// Close closes the underlying index.
+func (r *FoursquareReader) Close() error {
+	return r.index.Close()
+}
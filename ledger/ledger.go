@@ -0,0 +1,155 @@
+// Package ledger provides methods for persistently mapping Foursquare place IDs to Who's On First (WOF) IDs so
+// that places are assigned the same WOF ID every time they are exported, across releases of the Foursquare data.
+//
+// New WOF IDs are allocated by an `aaronland/go-uid` provider (for example Brooklyn Integers, which is what WOF
+// itself uses) or, for offline use, by the ledger itself. IDs are never reallocated: places which have been merged
+// in to another place or retired keep their entry (and ID) in the ledger.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aaronland/go-roster"
+)
+
+// ErrNotFound is returned when there is no entry for a given ID.
+var ErrNotFound = errors.New("Entry not found")
+
+// ErrConflict is returned when recording a mapping which conflicts with an existing entry.
+var ErrConflict = errors.New("Conflicting entry")
+
+// Status is the status of an entry in a ledger.
+type Status string
+
+const (
+	// ACTIVE is the status of places which are still exported.
+	ACTIVE Status = "active"
+	// MERGED is the status of places which have been merged in to another place.
+	MERGED Status = "merged"
+	// RETIRED is the status of places which should no longer be exported.
+	RETIRED Status = "retired"
+)
+
+// Entry is the mapping between a Foursquare place ID and a Who's On First ID.
+type Entry struct {
+	// The Foursquare ID of the place.
+	FoursquareId string `json:"4sq:id"`
+	// The Who's On First ID assigned to the place.
+	WOFId int64 `json:"wof:id"`
+	// The status of the entry.
+	Status Status `json:"status"`
+	// The Who's On First ID of the place this place was merged in to, if it has been merged.
+	SupersededBy int64 `json:"superseded_by,omitempty"`
+	// The Unix timestamp when the entry was created.
+	Created int64 `json:"created"`
+	// The Unix timestamp when the entry was last modified.
+	LastModified int64 `json:"lastmodified"`
+}
+
+// Ledger is an interface for persistently mapping Foursquare place IDs to Who's On First IDs.
+type Ledger interface {
+	// Lookup returns the entry for the place with Foursquare ID 'fsq_id' or `ErrNotFound`.
+	Lookup(context.Context, string) (*Entry, error)
+	// LookupWOFId returns the entry for the place which was assigned the Who's On First ID 'wof_id' or `ErrNotFound`.
+	LookupWOFId(context.Context, int64) (*Entry, error)
+	// Assign returns the entry for the place with Foursquare ID 'fsq_id', allocating a new Who's On First ID
+	// and recording it if there isn't one. Entries which have been merged or retired are returned as-is.
+	Assign(context.Context, string) (*Entry, error)
+	// Record records that the place with Foursquare ID 'fsq_id' is the Who's On First record with ID 'wof_id',
+	// for example because it has been matched with an existing venue. It returns `ErrConflict` if either ID has
+	// already been recorded with a different ID.
+	Record(context.Context, string, int64) (*Entry, error)
+	// Merge records that the place with Foursquare ID 'fsq_id' has been merged in to the place with Foursquare
+	// ID 'into_fsq_id', assigning the latter a Who's On First ID if necessary, and returns the updated entry.
+	Merge(context.Context, string, string) (*Entry, error)
+	// Retire records that the place with Foursquare ID 'fsq_id' should no longer be exported and returns the
+	// updated entry.
+	Retire(context.Context, string) (*Entry, error)
+	// Close closes the ledger.
+	Close() error
+}
+
+var ledger_roster roster.Roster
+
+// LedgerInitializationFunc is a function defined by individual ledger package and used to create
+// an instance of that ledger
+type LedgerInitializationFunc func(ctx context.Context, uri string) (Ledger, error)
+
+// RegisterLedger registers 'scheme' as a key pointing to 'init_func' in an internal lookup table
+// used to create new `Ledger` instances by the `NewLedger` method.
+func RegisterLedger(ctx context.Context, scheme string, init_func LedgerInitializationFunc) error {
+
+	err := ensureLedgerRoster()
+
+	if err != nil {
+		return err
+	}
+
+	return ledger_roster.Register(ctx, scheme, init_func)
+}
+
+func ensureLedgerRoster() error {
+
+	if ledger_roster == nil {
+
+		r, err := roster.NewDefaultRoster()
+
+		if err != nil {
+			return err
+		}
+
+		ledger_roster = r
+	}
+
+	return nil
+}
+
+// NewLedger returns a new `Ledger` instance configured by 'uri'. The value of 'uri' is parsed
+// as a `url.URL` and its scheme is used as the key for a corresponding `LedgerInitializationFunc`
+// function used to instantiate the new `Ledger`. It is assumed that the scheme (and initialization
+// function) have been registered by the `RegisterLedger` method.
+func NewLedger(ctx context.Context, uri string) (Ledger, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := u.Scheme
+
+	i, err := ledger_roster.Driver(ctx, scheme)
+
+	if err != nil {
+		return nil, err
+	}
+
+	init_func := i.(LedgerInitializationFunc)
+	return init_func(ctx, uri)
+}
+
+// LedgerSchemes returns the list of schemes that have been registered.
+func LedgerSchemes() []string {
+
+	ctx := context.Background()
+	schemes := []string{}
+
+	err := ensureLedgerRoster()
+
+	if err != nil {
+		return schemes
+	}
+
+	for _, dr := range ledger_roster.Drivers(ctx) {
+		scheme := fmt.Sprintf("%s://", strings.ToLower(dr))
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,434 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/whosonfirst/go-whosonfirst-id"
+)
+
+// DEFAULT_PROVIDER_URI is the default `aaronland/go-uid` provider URI used to allocate new Who's On First IDs. It is
+// the same provider used by the whosonfirst/go-whosonfirst-id package: Brooklyn Integers, fetched using a pool.
+const DEFAULT_PROVIDER_URI string = "proxy://?provider=whosonfirst://"
+
+// LOCAL_PROVIDER_SCHEME is the scheme of the provider URI used to allocate new Who's On First IDs locally,
+// without any network access.
+const LOCAL_PROVIDER_SCHEME string = "local"
+
+// DEFAULT_LOCAL_START is the default first ID allocated locally. It is far larger than any ID minted by Brooklyn
+// Integers but still small enough to be represented exactly by JavaScript numbers.
+const DEFAULT_LOCAL_START int64 = 1 << 52
+
+const sqlite_schema string = `CREATE TABLE IF NOT EXISTS entries (
+	fsq_place_id TEXT PRIMARY KEY,
+	wof_id INTEGER NOT NULL UNIQUE,
+	status TEXT NOT NULL,
+	superseded_by INTEGER NOT NULL DEFAULT 0,
+	created INTEGER NOT NULL,
+	lastmodified INTEGER NOT NULL
+)`
+
+const entries_columns string = "fsq_place_id, wof_id, status, superseded_by, created, lastmodified"
+
+// queryer is implemented by both `sql.DB` and `sql.Tx`.
+type queryer interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// SQLiteLedger implements the `Ledger` interface using a SQLite database.
+type SQLiteLedger struct {
+	Ledger
+	db *sql.DB
+	// The provider used to allocate new IDs, or nil if they are allocated locally
+	provider    id.Provider
+	local_start int64
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterLedger(ctx, "sqlite", NewSQLiteLedger)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewSQLiteLedger returns a new `SQLiteLedger` instance configured by 'uri' which is expected to take the form of:
+//
+//	sqlite://{PATH_TO_SQLITE_DATABASE}?{PARAMETERS}
+//
+// Where {PARAMETERS} may be:
+// * `?provider=` A registered `aaronland/go-uid` provider URI used to allocate new IDs, or `local://` to allocate
+// them from the ledger itself. Local IDs start at `DEFAULT_LOCAL_START` (or the value of the `?start=` parameter of
+// the provider URI, for example `local://?start=1000`) and are one more than the largest local ID in the ledger.
+// Default is `DEFAULT_PROVIDER_URI`.
+//
+// The database will be created if it does not already exist. Allocating and recording IDs is safe for concurrent
+// use, including by multiple processes.
+func NewSQLiteLedger(ctx context.Context, uri string) (Ledger, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Account for relative paths like sqlite://ledger.db
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	l := &SQLiteLedger{
+		local_start: DEFAULT_LOCAL_START,
+	}
+
+	provider_uri := DEFAULT_PROVIDER_URI
+
+	q := u.Query()
+
+	if q.Has("provider") {
+		provider_uri = q.Get("provider")
+	}
+
+	provider_u, err := url.Parse(provider_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?provider= parameter, %w", err)
+	}
+
+	switch provider_u.Scheme {
+	case LOCAL_PROVIDER_SCHEME:
+
+		provider_q := provider_u.Query()
+
+		if provider_q.Has("start") {
+
+			v, err := strconv.ParseInt(provider_q.Get("start"), 10, 64)
+
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Invalid ?start= parameter for local provider")
+			}
+
+			l.local_start = v
+		}
+
+	default:
+
+		pr, err := id.NewProviderWithURI(ctx, provider_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create ID provider, %w", err)
+		}
+
+		l.provider = pr
+	}
+
+	// Transactions acquire a write lock when they begin so that IDs can't be allocated twice by concurrent
+	// processes.
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_txlock=immediate", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, sqlite_schema)
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create schema, %w", err)
+	}
+
+	l.db = db
+	return l, nil
+}
+
+func (l *SQLiteLedger) Lookup(ctx context.Context, fsq_id string) (*Entry, error) {
+	return getEntry(ctx, l.db, "fsq_place_id", fsq_id)
+}
+
+func (l *SQLiteLedger) LookupWOFId(ctx context.Context, wof_id int64) (*Entry, error) {
+	return getEntry(ctx, l.db, "wof_id", wof_id)
+}
+
+func (l *SQLiteLedger) Assign(ctx context.Context, fsq_id string) (*Entry, error) {
+
+	if fsq_id == "" {
+		return nil, fmt.Errorf("Missing Foursquare ID")
+	}
+
+	e, err := l.Lookup(ctx, fsq_id)
+
+	if err != ErrNotFound {
+		return e, err
+	}
+
+	// IDs from remote providers are fetched before the write lock is acquired. If another process assigns an
+	// ID to the same place in the meantime this one is discarded.
+
+	wof_id := int64(-1)
+
+	if l.provider != nil {
+
+		wof_id, err = l.provider.NewID(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to allocate ID for %s, %w", fsq_id, err)
+		}
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	e, err = getEntry(ctx, tx, "fsq_place_id", fsq_id)
+
+	if err != ErrNotFound {
+		return e, err
+	}
+
+	if l.provider == nil {
+
+		wof_id, err = l.nextLocalId(ctx, tx)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e, err = insertEntry(ctx, tx, fsq_id, wof_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return e, nil
+}
+
+func (l *SQLiteLedger) Record(ctx context.Context, fsq_id string, wof_id int64) (*Entry, error) {
+
+	if fsq_id == "" {
+		return nil, fmt.Errorf("Missing Foursquare ID")
+	}
+
+	if wof_id <= 0 {
+		return nil, fmt.Errorf("Invalid Who's On First ID")
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	e, err := getEntry(ctx, tx, "fsq_place_id", fsq_id)
+
+	switch {
+	case err == nil && e.WOFId == wof_id:
+		return e, nil
+	case err == nil:
+		return nil, fmt.Errorf("%w, %s has already been assigned %d", ErrConflict, fsq_id, e.WOFId)
+	case err != ErrNotFound:
+		return nil, err
+	}
+
+	e, err = getEntry(ctx, tx, "wof_id", wof_id)
+
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("%w, %d has already been assigned to %s", ErrConflict, wof_id, e.FoursquareId)
+	case err != ErrNotFound:
+		return nil, err
+	}
+
+	e, err = insertEntry(ctx, tx, fsq_id, wof_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return e, nil
+}
+
+func (l *SQLiteLedger) Merge(ctx context.Context, fsq_id string, into_fsq_id string) (*Entry, error) {
+
+	if fsq_id == into_fsq_id {
+		return nil, fmt.Errorf("Can not merge %s in to itself", fsq_id)
+	}
+
+	into, err := l.Assign(ctx, into_fsq_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to assign ID for %s, %w", into_fsq_id, err)
+	}
+
+	if into.Status != ACTIVE {
+		return nil, fmt.Errorf("Can not merge in to %s because it has been %s", into_fsq_id, into.Status)
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	e, err := getEntry(ctx, tx, "fsq_place_id", fsq_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.Status == MERGED && e.SupersededBy == into.WOFId:
+		return e, nil
+	case e.Status != ACTIVE:
+		return nil, fmt.Errorf("Can not merge %s because it has been %s", fsq_id, e.Status)
+	}
+
+	return updateEntry(ctx, tx, fsq_id, MERGED, into.WOFId)
+}
+
+func (l *SQLiteLedger) Retire(ctx context.Context, fsq_id string) (*Entry, error) {
+
+	tx, err := l.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	e, err := getEntry(ctx, tx, "fsq_place_id", fsq_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Status {
+	case RETIRED:
+		return e, nil
+	case MERGED:
+		return nil, fmt.Errorf("Can not retire %s because it has been %s", fsq_id, e.Status)
+	}
+
+	return updateEntry(ctx, tx, fsq_id, RETIRED, 0)
+}
+
+func (l *SQLiteLedger) Close() error {
+	return l.db.Close()
+}
+
+// nextLocalId returns one more than the largest locally-allocated ID in the ledger, or the first local ID.
+func (l *SQLiteLedger) nextLocalId(ctx context.Context, tx *sql.Tx) (int64, error) {
+
+	var max_id int64
+
+	row := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(wof_id), 0) FROM entries WHERE wof_id >= ?", l.local_start)
+	err := row.Scan(&max_id)
+
+	if err != nil {
+		return -1, fmt.Errorf("Failed to query largest local ID, %w", err)
+	}
+
+	return max(max_id+1, l.local_start), nil
+}
+
+// getEntry returns the entry whose 'column' is 'value' or `ErrNotFound`.
+func getEntry(ctx context.Context, db queryer, column string, value any) (*Entry, error) {
+
+	e := new(Entry)
+
+	q := fmt.Sprintf("SELECT %s FROM entries WHERE %s = ?", entries_columns, column)
+	row := db.QueryRowContext(ctx, q, value)
+
+	err := row.Scan(&e.FoursquareId, &e.WOFId, &e.Status, &e.SupersededBy, &e.Created, &e.LastModified)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query entry for %v, %w", value, err)
+	}
+
+	return e, nil
+}
+
+// insertEntry records a new active entry mapping 'fsq_id' to 'wof_id' and returns it.
+func insertEntry(ctx context.Context, tx *sql.Tx, fsq_id string, wof_id int64) (*Entry, error) {
+
+	now := time.Now().Unix()
+
+	e := &Entry{
+		FoursquareId: fsq_id,
+		WOFId:        wof_id,
+		Status:       ACTIVE,
+		Created:      now,
+		LastModified: now,
+	}
+
+	q := fmt.Sprintf("INSERT INTO entries (%s) VALUES (?, ?, ?, ?, ?, ?)", entries_columns)
+	_, err := tx.ExecContext(ctx, q, e.FoursquareId, e.WOFId, e.Status, e.SupersededBy, e.Created, e.LastModified)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to record %d for %s, %w", wof_id, fsq_id, err)
+	}
+
+	return e, nil
+}
+
+// updateEntry updates the status of the entry for 'fsq_id', commits 'tx' and returns the updated entry.
+func updateEntry(ctx context.Context, tx *sql.Tx, fsq_id string, status Status, superseded_by int64) (*Entry, error) {
+
+	q := "UPDATE entries SET status = ?, superseded_by = ?, lastmodified = ? WHERE fsq_place_id = ?"
+	_, err := tx.ExecContext(ctx, q, status, superseded_by, time.Now().Unix(), fsq_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update %s, %w", fsq_id, err)
+	}
+
+	e, err := getEntry(ctx, tx, "fsq_place_id", fsq_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return e, nil
+}
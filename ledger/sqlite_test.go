@@ -0,0 +1,301 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testLedger returns a new `Ledger` backed by a temporary SQLite database which allocates IDs locally, starting
+// at 1000.
+func testLedger(t *testing.T) Ledger {
+
+	ctx := context.Background()
+
+	uri := fmt.Sprintf("sqlite://%s?provider=local://?start=1000", filepath.Join(t.TempDir(), "ledger.db"))
+
+	l, err := NewLedger(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create ledger, %v", err)
+	}
+
+	t.Cleanup(func() {
+		l.Close()
+	})
+
+	return l
+}
+
+func TestAssign(t *testing.T) {
+
+	ctx := context.Background()
+	l := testLedger(t)
+
+	_, err := l.Lookup(ctx, "a")
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	a, err := l.Assign(ctx, "a")
+
+	if err != nil {
+		t.Fatalf("Failed to assign ID, %v", err)
+	}
+
+	if a.WOFId != 1000 || a.Status != ACTIVE {
+		t.Fatalf("Unexpected entry %+v", *a)
+	}
+
+	// Assigning an ID again returns the existing entry
+
+	again, err := l.Assign(ctx, "a")
+
+	if err != nil {
+		t.Fatalf("Failed to assign ID again, %v", err)
+	}
+
+	if again.WOFId != a.WOFId {
+		t.Fatalf("Expected %d to be assigned again, got %d", a.WOFId, again.WOFId)
+	}
+
+	// Local IDs follow the largest local ID, including ones which were recorded rather than assigned
+
+	_, err = l.Record(ctx, "b", 2000)
+
+	if err != nil {
+		t.Fatalf("Failed to record ID, %v", err)
+	}
+
+	c, err := l.Assign(ctx, "c")
+
+	if err != nil {
+		t.Fatalf("Failed to assign ID, %v", err)
+	}
+
+	if c.WOFId != 2001 {
+		t.Fatalf("Expected 2001 to be assigned, got %d", c.WOFId)
+	}
+
+	e, err := l.LookupWOFId(ctx, 2001)
+
+	if err != nil {
+		t.Fatalf("Failed to look up ID, %v", err)
+	}
+
+	if e.FoursquareId != "c" {
+		t.Fatalf("Expected 2001 to be assigned to c, got %s", e.FoursquareId)
+	}
+}
+
+func TestAssignConcurrent(t *testing.T) {
+
+	ctx := context.Background()
+	l := testLedger(t)
+
+	count := 50
+
+	ids := make([]int64, count)
+	errs := make([]error, count)
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < count; i++ {
+
+		wg.Add(1)
+
+		go func(i int) {
+
+			defer wg.Done()
+
+			// Every other place is assigned twice at the same time
+
+			e, err := l.Assign(ctx, fmt.Sprintf("place%d", i/2))
+
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			ids[i] = e.WOFId
+		}(i)
+	}
+
+	wg.Wait()
+
+	seen := make(map[int64]int)
+
+	for i := 0; i < count; i++ {
+
+		if errs[i] != nil {
+			t.Fatalf("Failed to assign ID, %v", errs[i])
+		}
+
+		seen[ids[i]] += 1
+	}
+
+	if len(seen) != count/2 {
+		t.Fatalf("Expected %d distinct IDs, got %d", count/2, len(seen))
+	}
+
+	for i := 0; i < count; i += 2 {
+
+		if ids[i] != ids[i+1] {
+			t.Fatalf("Expected place%d to be assigned a single ID, got %d and %d", i/2, ids[i], ids[i+1])
+		}
+	}
+}
+
+func TestRecordConflicts(t *testing.T) {
+
+	ctx := context.Background()
+	l := testLedger(t)
+
+	_, err := l.Record(ctx, "a", 100)
+
+	if err != nil {
+		t.Fatalf("Failed to record ID, %v", err)
+	}
+
+	// Recording the same mapping again is not a conflict
+
+	e, err := l.Record(ctx, "a", 100)
+
+	if err != nil {
+		t.Fatalf("Failed to record ID again, %v", err)
+	}
+
+	if e.WOFId != 100 {
+		t.Fatalf("Expected 100 to be recorded, got %d", e.WOFId)
+	}
+
+	tests := []struct {
+		fsq_id string
+		wof_id int64
+	}{
+		// The Foursquare ID has already been recorded with a different ID
+		{"a", 101},
+		// The Who's On First ID has already been recorded for a different place
+		{"b", 100},
+	}
+
+	for _, test := range tests {
+
+		_, err := l.Record(ctx, test.fsq_id, test.wof_id)
+
+		if !errors.Is(err, ErrConflict) {
+			t.Fatalf("Expected ErrConflict recording %d for %s, got %v", test.wof_id, test.fsq_id, err)
+		}
+	}
+
+	_, err = l.Lookup(ctx, "b")
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected conflicting entry not to be recorded, got %v", err)
+	}
+
+	_, err = l.Record(ctx, "c", 0)
+
+	if err == nil {
+		t.Fatalf("Expected invalid ID to be rejected")
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	ctx := context.Background()
+	l := testLedger(t)
+
+	_, err := l.Merge(ctx, "missing", "a")
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound merging a place which is not in the ledger, got %v", err)
+	}
+
+	for _, id := range []string{"b", "c", "d"} {
+
+		_, err := l.Assign(ctx, id)
+
+		if err != nil {
+			t.Fatalf("Failed to assign ID for %s, %v", id, err)
+		}
+	}
+
+	_, err = l.Merge(ctx, "b", "b")
+
+	if err == nil {
+		t.Fatalf("Expected merging a place in to itself to fail")
+	}
+
+	// Places are merged in to places which are not yet in the ledger by assigning them an ID
+
+	e, err := l.Merge(ctx, "b", "new")
+
+	if err != nil {
+		t.Fatalf("Failed to merge place, %v", err)
+	}
+
+	into, err := l.Lookup(ctx, "new")
+
+	if err != nil {
+		t.Fatalf("Failed to look up place merged in to, %v", err)
+	}
+
+	if e.Status != MERGED || e.SupersededBy != into.WOFId {
+		t.Fatalf("Unexpected entry %+v", *e)
+	}
+
+	// Merging the same places again is not a conflict but merging in to a different place is
+
+	_, err = l.Merge(ctx, "b", "new")
+
+	if err != nil {
+		t.Fatalf("Failed to merge place again, %v", err)
+	}
+
+	_, err = l.Merge(ctx, "b", "c")
+
+	if err == nil {
+		t.Fatalf("Expected merging a merged place in to a different place to fail")
+	}
+
+	// Places can not be merged in to places which have been merged or retired, nor can they be retired once merged
+
+	_, err = l.Merge(ctx, "c", "b")
+
+	if err == nil {
+		t.Fatalf("Expected merging in to a merged place to fail")
+	}
+
+	_, err = l.Retire(ctx, "d")
+
+	if err != nil {
+		t.Fatalf("Failed to retire place, %v", err)
+	}
+
+	_, err = l.Merge(ctx, "c", "d")
+
+	if err == nil {
+		t.Fatalf("Expected merging in to a retired place to fail")
+	}
+
+	_, err = l.Retire(ctx, "b")
+
+	if err == nil {
+		t.Fatalf("Expected retiring a merged place to fail")
+	}
+
+	e, err = l.Lookup(ctx, "c")
+
+	if err != nil {
+		t.Fatalf("Failed to look up place, %v", err)
+	}
+
+	if e.Status != ACTIVE {
+		t.Fatalf("Expected failed merges to leave place active, got %s", e.Status)
+	}
+}
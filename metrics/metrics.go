@@ -0,0 +1,115 @@
+// Package metrics defines the Prometheus metrics recorded while processing Foursquare places and an HTTP handler
+// for serving them, along with `net/http/pprof` profiles, to monitor long-running commands.
+package metrics
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NAMESPACE is the namespace for all the metrics defined by this package.
+const NAMESPACE string = "foursquare_places"
+
+// CACHE_PARENT is the "cache" label for the cache of parent record properties.
+const CACHE_PARENT string = "parent"
+
+// CACHE_CELL is the "cache" label for the cache of point-in-polygon results for geohash cells.
+const CACHE_CELL string = "cell"
+
+var (
+	// PlacesEmitted is the number of places yielded by an emitter (or rows read from a file) and dispatched for processing.
+	PlacesEmitted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "emitted_total",
+		Help:      "The number of places dispatched for processing.",
+	})
+
+	// PlacesProcessed is the number of places which have been processed, successfully or not.
+	PlacesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "processed_total",
+		Help:      "The number of places processed, successfully or not.",
+	})
+
+	// PlacesFailed is the number of places which failed to be processed.
+	PlacesFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "failed_total",
+		Help:      "The number of places which failed to be processed.",
+	})
+
+	// ParseErrors is the number of errors yielded while reading places (or rows) from their source.
+	ParseErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "parse_errors_total",
+		Help:      "The number of errors reading places from their source.",
+	})
+
+	// ProcessDuration is the time taken to process a single place.
+	ProcessDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "process_duration_seconds",
+		Help:      "The time taken to process a single place.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	})
+
+	// PointInPolygonDuration is the time taken to perform a single point-in-polygon query.
+	PointInPolygonDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "pip_duration_seconds",
+		Help:      "The time taken to perform a single point-in-polygon query.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	// CacheHits is the number of cache hits, labeled by cache.
+	CacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cache_hits_total",
+		Help:      "The number of cache hits.",
+	}, []string{"cache"})
+
+	// CacheMisses is the number of cache misses, labeled by cache.
+	CacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cache_misses_total",
+		Help:      "The number of cache misses.",
+	}, []string{"cache"})
+
+	// Throughput is the average number of places processed per second since processing started.
+	Throughput = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "throughput",
+		Help:      "The average number of places processed per second since processing started.",
+	})
+)
+
+// ObserveCache records a hit or a miss for 'cache'.
+func ObserveCache(cache string, hit bool) {
+
+	if hit {
+		CacheHits.WithLabelValues(cache).Inc()
+	} else {
+		CacheMisses.WithLabelValues(cache).Inc()
+	}
+}
+
+// NewServeMux returns a new `http.ServeMux` instance serving Prometheus metrics from "/metrics" and
+// `net/http/pprof` profiles from "/debug/pprof/".
+func NewServeMux() *http.ServeMux {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PMTILES_DIR_CACHE_REQUESTS is the name of the counter, labeled by archive, kind and status ("hit" or "miss"),
+// which the protomaps/go-pmtiles package uses to record requests to its directory cache.
+const PMTILES_DIR_CACHE_REQUESTS string = "pmtiles_dir_cache_requests"
+
+// PMTilesCacheStats returns the number of hits and misses recorded by the directory cache of any PMTiles
+// databases (as used by whosonfirst/go-whosonfirst-spatial-pmtiles) in the current process. These are read from
+// the counters the protomaps/go-pmtiles package registers with the default Prometheus registry. Note that the
+// PMTiles cache holds tile directories rather than the tiles themselves and that its size, as defined by the
+// ?pmtiles-cache-size= parameter, is measured in megabytes.
+func PMTilesCacheStats() (int64, int64, error) {
+
+	families, err := prometheus.DefaultGatherer.Gather()
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to gather metrics, %w", err)
+	}
+
+	hits := int64(0)
+	misses := int64(0)
+
+	for _, mf := range families {
+
+		if mf.GetName() != PMTILES_DIR_CACHE_REQUESTS {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+
+			count := int64(m.GetCounter().GetValue())
+
+			for _, l := range m.GetLabel() {
+
+				if l.GetName() != "status" {
+					continue
+				}
+
+				switch l.GetValue() {
+				case "hit":
+					hits += count
+				case "miss":
+					misses += count
+				}
+			}
+		}
+	}
+
+	return hits, misses, nil
+}
+
+// PMTilesCacheHitRate returns the ratio of hits to total requests recorded by the directory cache of any
+// PMTiles databases in the current process.
+func PMTilesCacheHitRate() (float64, error) {
+
+	hits, misses, err := PMTilesCacheStats()
+
+	if err != nil {
+		return 0.0, err
+	}
+
+	total := hits + misses
+
+	if total == 0 {
+		return 0.0, nil
+	}
+
+	return float64(hits) / float64(total), nil
+}
@@ -0,0 +1,62 @@
+package order
+
+// HilbertIndex returns the distance along a Hilbert curve, covering a (2^level x 2^level) grid, for the cell at 'x' and 'y'.
+func HilbertIndex(x uint32, y uint32, level int) uint64 {
+
+	n := uint64(1) << level
+
+	rx := uint64(0)
+	ry := uint64(0)
+	d := uint64(0)
+
+	ux := uint64(x)
+	uy := uint64(y)
+
+	for s := n / 2; s > 0; s /= 2 {
+
+		rx = 0
+		ry = 0
+
+		if ux&s > 0 {
+			rx = 1
+		}
+
+		if uy&s > 0 {
+			ry = 1
+		}
+
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant
+
+		if ry == 0 {
+
+			if rx == 1 {
+				ux = n - 1 - ux
+				uy = n - 1 - uy
+			}
+
+			ux, uy = uy, ux
+		}
+	}
+
+	return d
+}
+
+// ZOrderIndex returns the Z-order (Morton) index for the cell at 'x' and 'y' by interleaving their bits.
+func ZOrderIndex(x uint32, y uint32) uint64 {
+	return spreadBits(x) | (spreadBits(y) << 1)
+}
+
+func spreadBits(v uint32) uint64 {
+
+	x := uint64(v)
+
+	x = (x | (x << 16)) & 0x0000FFFF0000FFFF
+	x = (x | (x << 8)) & 0x00FF00FF00FF00FF
+	x = (x | (x << 4)) & 0x0F0F0F0F0F0F0F0F
+	x = (x | (x << 2)) & 0x3333333333333333
+	x = (x | (x << 1)) & 0x5555555555555555
+
+	return x
+}
@@ -0,0 +1,117 @@
+// Package order provides methods for (re)ordering Foursquare places along a space-filling curve so that
+// places which are close to each other in space are also close to each other in processing order.
+package order
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+const (
+	// HILBERT orders places along a Hilbert curve.
+	HILBERT string = "hilbert"
+	// ZORDER orders places along a Z-order (Morton) curve.
+	ZORDER string = "zorder"
+	// TILE buckets places by (Web Mercator) map tile, in row-major order.
+	TILE string = "tile"
+)
+
+// MAX_LEVEL is the maximum level (number of bits per dimension, or zoom level) supported by any curve.
+const MAX_LEVEL int = 31
+
+// KeyFunc is a function which derives a sort key for a `places.Place` record.
+type KeyFunc func(*places.Place) uint64
+
+// DefaultLevel returns the default level for 'curve'. For the Hilbert and Z-order curves this is the number
+// of bits per dimension. For the tile curve it is the zoom level.
+func DefaultLevel(curve string) int {
+
+	switch curve {
+	case TILE:
+		return 13
+	default:
+		return 16
+	}
+}
+
+// NewKeyFunc returns a `KeyFunc` for 'curve' at 'level'.
+func NewKeyFunc(curve string, level int) (KeyFunc, error) {
+
+	switch curve {
+	case HILBERT:
+
+		if level < 1 || level > MAX_LEVEL {
+			return nil, fmt.Errorf("Invalid level for %s curve, must be between 1 and %d", curve, MAX_LEVEL)
+		}
+
+		f := func(pl *places.Place) uint64 {
+			x, y := gridCoordinates(pl, level)
+			return HilbertIndex(x, y, level)
+		}
+
+		return f, nil
+
+	case ZORDER:
+
+		if level < 1 || level > MAX_LEVEL {
+			return nil, fmt.Errorf("Invalid level for %s curve, must be between 1 and %d", curve, MAX_LEVEL)
+		}
+
+		f := func(pl *places.Place) uint64 {
+			x, y := gridCoordinates(pl, level)
+			return ZOrderIndex(x, y)
+		}
+
+		return f, nil
+
+	case TILE:
+
+		if level < 0 || level > MAX_LEVEL {
+			return nil, fmt.Errorf("Invalid zoom level for %s curve, must be between 0 and %d", curve, MAX_LEVEL)
+		}
+
+		z := maptile.Zoom(level)
+
+		f := func(pl *places.Place) uint64 {
+			t := PlaceTile(pl, z)
+			return (uint64(t.Y) << z) | uint64(t.X)
+		}
+
+		return f, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported curve '%s'", curve)
+	}
+}
+
+// PlaceTile returns the map tile at zoom level 'z' containing 'pl'.
+func PlaceTile(pl *places.Place, z maptile.Zoom) maptile.Tile {
+	pt := orb.Point{pl.Longitude, pl.Latitude}
+	return maptile.At(pt, z)
+}
+
+// gridCoordinates maps the longitude and latitude of 'pl' on to a (2^level x 2^level) grid.
+func gridCoordinates(pl *places.Place, level int) (uint32, uint32) {
+
+	n := float64(uint64(1) << level)
+
+	x := (clamp(pl.Longitude, -180.0, 180.0) + 180.0) / 360.0 * n
+	y := (clamp(pl.Latitude, -90.0, 90.0) + 90.0) / 180.0 * n
+
+	max := n - 1
+
+	return uint32(math.Min(x, max)), uint32(math.Min(y, max))
+}
+
+func clamp(v float64, min float64, max float64) float64 {
+
+	if math.IsNaN(v) {
+		return 0.0
+	}
+
+	return math.Max(min, math.Min(max, v))
+}
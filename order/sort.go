@@ -0,0 +1,385 @@
+package order
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// DEFAULT_CHUNK_SIZE is the default number of places to sort in memory before spilling them to disk.
+const DEFAULT_CHUNK_SIZE int = 1000000
+
+// SortOptions defines configuration options for the `Sort` method.
+type SortOptions struct {
+	// KeyFunc is the `KeyFunc` used to derive the sort key for each place.
+	KeyFunc KeyFunc
+	// ChunkSize is the maximum number of places to sort in memory. If the total number of places exceeds
+	// this value places are sorted in chunks which are written to disk and then merged. Default is `DEFAULT_CHUNK_SIZE`.
+	ChunkSize int
+	// TempDir is the directory where sorted chunks are written. Default is the value of `os.TempDir()`.
+	TempDir string
+}
+
+// SortRowsOptions defines configuration options for the `SortRows` method.
+type SortRowsOptions struct {
+	// Column is the name of the column whose (string) values rows are sorted by.
+	Column string
+	// ChunkSize is the maximum number of rows to sort in memory. If the total number of rows exceeds
+	// this value rows are sorted in chunks which are written to disk and then merged. Default is `DEFAULT_CHUNK_SIZE`.
+	ChunkSize int
+	// TempDir is the directory where sorted chunks are written. Default is the value of `os.TempDir()`.
+	TempDir string
+}
+
+// keyedRecord is a record and the key it is sorted by.
+type keyedRecord[T any, K cmp.Ordered] struct {
+	Key    K `json:"k"`
+	Record T `json:"r"`
+}
+
+// Sort returns an iterator yielding the places in 'seq' ordered by the value of 'opts.KeyFunc'. Places with the
+// same key are yielded in the order they were received. Sorting is performed out-of-core so 'seq' may contain
+// more places than can be held in memory. Errors yielded by 'seq' are passed through as they are encountered
+// which means they will be yielded before any places.
+func Sort(ctx context.Context, seq iter.Seq2[*places.Place, error], opts *SortOptions) iter.Seq2[*places.Place, error] {
+
+	if opts.KeyFunc == nil {
+		return func(yield func(*places.Place, error) bool) {
+			yield(nil, fmt.Errorf("Missing key function"))
+		}
+	}
+
+	key_func := func(pl *places.Place) uint64 {
+		return opts.KeyFunc(pl)
+	}
+
+	return sortRecords(ctx, seq, key_func, opts.ChunkSize, opts.TempDir)
+}
+
+// SortRows returns an iterator yielding the (CSV) rows in 'seq' ordered by the value of the 'opts.Column' column.
+// Like `Sort`, rows with the same key are yielded in the order they were received, sorting is performed out-of-core
+// and errors yielded by 'seq' are passed through as they are encountered.
+func SortRows(ctx context.Context, seq iter.Seq2[map[string]string, error], opts *SortRowsOptions) iter.Seq2[map[string]string, error] {
+
+	if opts.Column == "" {
+		return func(yield func(map[string]string, error) bool) {
+			yield(nil, fmt.Errorf("Missing column"))
+		}
+	}
+
+	key_func := func(row map[string]string) string {
+		return row[opts.Column]
+	}
+
+	return sortRecords(ctx, seq, key_func, opts.ChunkSize, opts.TempDir)
+}
+
+// sortRecords returns an iterator yielding the records in 'seq' ordered by the value of 'key_func', spilling
+// sorted chunks of 'chunk_size' records to 'tmp_root' as necessary.
+func sortRecords[T any, K cmp.Ordered](ctx context.Context, seq iter.Seq2[T, error], key_func func(T) K, chunk_size int, tmp_root string) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+
+		var zero T
+
+		if chunk_size <= 0 {
+			chunk_size = DEFAULT_CHUNK_SIZE
+		}
+
+		var tmpdir string
+
+		chunk := make([]*keyedRecord[T, K], 0)
+		chunks := make([]string, 0)
+
+		defer func() {
+			if tmpdir != "" {
+				os.RemoveAll(tmpdir)
+			}
+		}()
+
+		for r, err := range seq {
+
+			// Sorting can take a long time before anything is yielded so check
+			// for cancellation here rather than relying on the consumer.
+
+			if ctx.Err() != nil {
+				yield(zero, ctx.Err())
+				return
+			}
+
+			if err != nil {
+
+				if !yield(zero, err) {
+					return
+				}
+
+				continue
+			}
+
+			chunk = append(chunk, &keyedRecord[T, K]{Key: key_func(r), Record: r})
+
+			if len(chunk) < chunk_size {
+				continue
+			}
+
+			if tmpdir == "" {
+
+				d, err := os.MkdirTemp(tmp_root, "foursquare-places-order-")
+
+				if err != nil {
+					yield(zero, fmt.Errorf("Failed to create temporary directory, %w", err))
+					return
+				}
+
+				tmpdir = d
+			}
+
+			chunk_path, err := writeChunk(ctx, tmpdir, len(chunks), chunk)
+
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			chunks = append(chunks, chunk_path)
+			chunk = make([]*keyedRecord[T, K], 0)
+		}
+
+		// Everything fit in memory so there's no need to merge anything
+
+		if len(chunks) == 0 {
+
+			sortChunk(chunk)
+
+			for _, kr := range chunk {
+
+				if !yield(kr.Record, nil) {
+					return
+				}
+			}
+
+			return
+		}
+
+		if len(chunk) > 0 {
+
+			chunk_path, err := writeChunk(ctx, tmpdir, len(chunks), chunk)
+
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			chunks = append(chunks, chunk_path)
+			chunk = nil
+		}
+
+		mergeChunks[T, K](ctx, chunks, yield)
+	}
+}
+
+func sortChunk[T any, K cmp.Ordered](chunk []*keyedRecord[T, K]) {
+
+	sort.SliceStable(chunk, func(i, j int) bool {
+		return chunk[i].Key < chunk[j].Key
+	})
+}
+
+func writeChunk[T any, K cmp.Ordered](ctx context.Context, tmpdir string, idx int, chunk []*keyedRecord[T, K]) (string, error) {
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	sortChunk(chunk)
+
+	chunk_path := filepath.Join(tmpdir, fmt.Sprintf("chunk-%06d.jsonl.gz", idx))
+
+	fh, err := os.Create(chunk_path)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create chunk %s, %w", chunk_path, err)
+	}
+
+	defer fh.Close()
+
+	buf := bufio.NewWriter(fh)
+
+	gz, err := gzip.NewWriterLevel(buf, gzip.BestSpeed)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create gzip writer for %s, %w", chunk_path, err)
+	}
+
+	enc := json.NewEncoder(gz)
+
+	for i, kr := range chunk {
+
+		err := enc.Encode(kr)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to encode record %d, %w", i, err)
+		}
+	}
+
+	err = gz.Close()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to close gzip writer for %s, %w", chunk_path, err)
+	}
+
+	err = buf.Flush()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to flush %s, %w", chunk_path, err)
+	}
+
+	return chunk_path, fh.Close()
+}
+
+// chunkCursor is the current position in a sorted chunk file.
+type chunkCursor[T any, K cmp.Ordered] struct {
+	idx     int
+	head    *keyedRecord[T, K]
+	decoder *json.Decoder
+	closer  io.Closer
+}
+
+func (c *chunkCursor[T, K]) next() error {
+
+	var kr *keyedRecord[T, K]
+	err := c.decoder.Decode(&kr)
+
+	if err != nil {
+		c.head = nil
+		return err
+	}
+
+	c.head = kr
+	return nil
+}
+
+// chunkHeap is a `container/heap.Interface` of chunk cursors ordered by the key of their current record
+// and then by the order in which the chunks were written.
+type chunkHeap[T any, K cmp.Ordered] []*chunkCursor[T, K]
+
+func (h chunkHeap[T, K]) Len() int {
+	return len(h)
+}
+
+func (h chunkHeap[T, K]) Less(i, j int) bool {
+
+	if h[i].head.Key == h[j].head.Key {
+		return h[i].idx < h[j].idx
+	}
+
+	return h[i].head.Key < h[j].head.Key
+}
+
+func (h chunkHeap[T, K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *chunkHeap[T, K]) Push(x any) {
+	*h = append(*h, x.(*chunkCursor[T, K]))
+}
+
+func (h *chunkHeap[T, K]) Pop() any {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	*h = old[0 : n-1]
+	return c
+}
+
+func mergeChunks[T any, K cmp.Ordered](ctx context.Context, chunks []string, yield func(T, error) bool) {
+
+	var zero T
+
+	h := make(chunkHeap[T, K], 0, len(chunks))
+
+	defer func() {
+		for _, c := range h {
+			c.closer.Close()
+		}
+	}()
+
+	for idx, chunk_path := range chunks {
+
+		fh, err := os.Open(chunk_path)
+
+		if err != nil {
+			yield(zero, fmt.Errorf("Failed to open chunk %s, %w", chunk_path, err))
+			return
+		}
+
+		gz, err := gzip.NewReader(bufio.NewReader(fh))
+
+		if err != nil {
+			fh.Close()
+			yield(zero, fmt.Errorf("Failed to create gzip reader for %s, %w", chunk_path, err))
+			return
+		}
+
+		c := &chunkCursor[T, K]{
+			idx:     idx,
+			decoder: json.NewDecoder(gz),
+			closer:  fh,
+		}
+
+		err = c.next()
+
+		if err == io.EOF {
+			fh.Close()
+			continue
+		}
+
+		if err != nil {
+			fh.Close()
+			yield(zero, fmt.Errorf("Failed to read chunk %s, %w", chunk_path, err))
+			return
+		}
+
+		h = append(h, c)
+	}
+
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+
+		if ctx.Err() != nil {
+			yield(zero, ctx.Err())
+			return
+		}
+
+		c := h[0]
+
+		if !yield(c.head.Record, nil) {
+			return
+		}
+
+		err := c.next()
+
+		switch {
+		case err == io.EOF:
+			heap.Pop(&h)
+			c.closer.Close()
+		case err != nil:
+			yield(zero, fmt.Errorf("Failed to read chunk %s, %w", chunks[c.idx], err))
+			return
+		default:
+			heap.Fix(&h, 0)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"testing"
+
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// testRows returns an iterator yielding a row, with "key" and "id" columns, for each key in 'keys'. Each
+// row's ID is its position in 'keys'.
+func testRows(keys ...string) iter.Seq2[map[string]string, error] {
+
+	return func(yield func(map[string]string, error) bool) {
+
+		for i, k := range keys {
+
+			row := map[string]string{
+				"key": k,
+				"id":  fmt.Sprintf("%d", i),
+			}
+
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// assertEmptyDir fails if 'path' contains any files or directories.
+func assertEmptyDir(t *testing.T, path string) {
+
+	entries, err := os.ReadDir(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", path, err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("Expected temporary directory to be removed, found %s", entries[0].Name())
+	}
+}
+
+func TestSortRows(t *testing.T) {
+
+	ctx := context.Background()
+
+	keys := []string{"d", "b", "a", "c", "b", "a", "e", "b", "a"}
+
+	// Rows with equal keys are yielded in the order they were received, whether or not they are in the same chunk
+	expected := []string{"2", "5", "8", "1", "4", "7", "3", "0", "6"}
+
+	for _, chunk_size := range []int{0, 1, 2, 3, len(keys), len(keys) + 1} {
+
+		tmpdir := t.TempDir()
+
+		opts := &SortRowsOptions{
+			Column:    "key",
+			ChunkSize: chunk_size,
+			TempDir:   tmpdir,
+		}
+
+		ids := make([]string, 0)
+
+		for row, err := range SortRows(ctx, testRows(keys...), opts) {
+
+			if err != nil {
+				t.Fatalf("Failed to sort rows with chunk size %d, %v", chunk_size, err)
+			}
+
+			ids = append(ids, row["id"])
+		}
+
+		if fmt.Sprintf("%v", ids) != fmt.Sprintf("%v", expected) {
+			t.Fatalf("Unexpected order with chunk size %d, expected %v but got %v", chunk_size, expected, ids)
+		}
+
+		assertEmptyDir(t, tmpdir)
+	}
+}
+
+func TestSortRowsEmpty(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpdir := t.TempDir()
+
+	opts := &SortRowsOptions{
+		Column:    "key",
+		ChunkSize: 2,
+		TempDir:   tmpdir,
+	}
+
+	for _, err := range SortRows(ctx, testRows(), opts) {
+		t.Fatalf("Expected nothing to be yielded, got %v", err)
+	}
+
+	assertEmptyDir(t, tmpdir)
+}
+
+func TestSortRowsErrors(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Errors yielded by the input are passed through before any rows
+
+	input_err := errors.New("Invalid row")
+
+	seq := func(yield func(map[string]string, error) bool) {
+
+		for row, err := range testRows("c", "b", "a") {
+
+			if !yield(row, err) {
+				return
+			}
+		}
+
+		yield(nil, input_err)
+	}
+
+	tmpdir := t.TempDir()
+
+	opts := &SortRowsOptions{
+		Column:    "key",
+		ChunkSize: 2,
+		TempDir:   tmpdir,
+	}
+
+	results := make([]string, 0)
+
+	for row, err := range SortRows(ctx, seq, opts) {
+
+		if err != nil {
+			results = append(results, err.Error())
+		} else {
+			results = append(results, row["key"])
+		}
+	}
+
+	expected := []string{"Invalid row", "a", "b", "c"}
+
+	if fmt.Sprintf("%v", results) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected %v, got %v", expected, results)
+	}
+
+	assertEmptyDir(t, tmpdir)
+
+	// Missing columns
+
+	for _, err := range SortRows(ctx, testRows("a"), &SortRowsOptions{}) {
+
+		if err == nil {
+			t.Fatalf("Expected missing column to fail")
+		}
+	}
+}
+
+func TestSortRowsCleanup(t *testing.T) {
+
+	keys := []string{"f", "e", "d", "c", "b", "a"}
+
+	// Chunks are removed when the context is cancelled after they have been written
+
+	tmpdir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seq := func(yield func(map[string]string, error) bool) {
+
+		for i, k := range keys {
+
+			if i == 5 {
+				cancel()
+			}
+
+			if !yield(map[string]string{"key": k}, nil) {
+				return
+			}
+		}
+	}
+
+	opts := &SortRowsOptions{
+		Column:    "key",
+		ChunkSize: 2,
+		TempDir:   tmpdir,
+	}
+
+	var sort_err error
+
+	for _, err := range SortRows(ctx, seq, opts) {
+
+		if err != nil {
+			sort_err = err
+			break
+		}
+	}
+
+	if !errors.Is(sort_err, context.Canceled) {
+		t.Fatalf("Expected context to be cancelled, got %v", sort_err)
+	}
+
+	assertEmptyDir(t, tmpdir)
+
+	// Chunks are removed when the consumer stops while they are being merged
+
+	tmpdir = t.TempDir()
+	opts.TempDir = tmpdir
+
+	for row, err := range SortRows(context.Background(), testRows(keys...), opts) {
+
+		if err != nil {
+			t.Fatalf("Failed to sort rows, %v", err)
+		}
+
+		if row["key"] != "a" {
+			t.Fatalf("Expected first row to be 'a', got '%s'", row["key"])
+		}
+
+		break
+	}
+
+	assertEmptyDir(t, tmpdir)
+
+	// Failing to create the temporary directory is an error
+
+	opts.TempDir = tmpdir + "/missing"
+
+	var mkdir_err error
+
+	for _, err := range SortRows(context.Background(), testRows(keys...), opts) {
+
+		if err != nil {
+			mkdir_err = err
+		}
+	}
+
+	if mkdir_err == nil {
+		t.Fatalf("Expected missing temporary directory to fail")
+	}
+}
+
+func TestSort(t *testing.T) {
+
+	ctx := context.Background()
+
+	lats := []float64{3.0, 1.0, 2.0, 1.0, 0.0}
+
+	seq := func(yield func(*places.Place, error) bool) {
+
+		for i, lat := range lats {
+
+			pl := &places.Place{
+				Id:       fmt.Sprintf("%d", i),
+				Latitude: lat,
+			}
+
+			if !yield(pl, nil) {
+				return
+			}
+		}
+	}
+
+	tmpdir := t.TempDir()
+
+	opts := &SortOptions{
+		KeyFunc: func(pl *places.Place) uint64 {
+			return uint64(pl.Latitude)
+		},
+		ChunkSize: 2,
+		TempDir:   tmpdir,
+	}
+
+	ids := make([]string, 0)
+
+	for pl, err := range Sort(ctx, seq, opts) {
+
+		if err != nil {
+			t.Fatalf("Failed to sort places, %v", err)
+		}
+
+		ids = append(ids, pl.Id)
+	}
+
+	expected := []string{"4", "1", "3", "2", "0"}
+
+	if fmt.Sprintf("%v", ids) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+
+	assertEmptyDir(t, tmpdir)
+
+	for _, err := range Sort(ctx, seq, &SortOptions{}) {
+
+		if err == nil {
+			t.Fatalf("Expected missing key function to fail")
+		}
+	}
+}
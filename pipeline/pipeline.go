@@ -0,0 +1,373 @@
+// Package pipeline provides a bounded worker pool for processing the records yielded by an iterator, for example
+// the places yielded by an `emitter.Emitter` instance, and passing the results to a sink.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is the name of a stage in a pipeline.
+type Stage string
+
+const (
+	// EMIT is the stage where records are read from an iterator.
+	EMIT Stage = "emit"
+	// PROCESS is the stage where records are processed by workers.
+	PROCESS Stage = "process"
+	// SINK is the stage where the results of processing records are consumed.
+	SINK Stage = "sink"
+)
+
+// ErrTooManyErrors is returned by `Run` when the number of errors in a stage exceeds the threshold defined by its `ErrorPolicy`.
+var ErrTooManyErrors = errors.New("Too many errors")
+
+// ErrorPolicy defines how errors in a pipeline stage are handled. The zero value logs errors and continues processing.
+type ErrorPolicy struct {
+	// Halt stops the pipeline at the first error.
+	Halt bool
+	// MaxErrors is the maximum number of errors tolerated before the pipeline is stopped. If 0 there is no limit.
+	MaxErrors int64
+}
+
+// ProcessFunc is a function which processes a single record.
+type ProcessFunc[In any, Out any] func(context.Context, In) (Out, error)
+
+// SinkFunc is a function which consumes the result of processing a single record. Sink functions are never
+// called concurrently.
+type SinkFunc[Out any] func(context.Context, Out) error
+
+// ProgressFunc is a function which is called periodically with a snapshot of a pipeline's statistics.
+type ProgressFunc func(*Stats)
+
+// ErrorFunc is a function which is called for every error encountered by a pipeline. If nil errors are logged
+// using the default `slog.Logger`.
+type ErrorFunc func(context.Context, Stage, error)
+
+// Options defines configuration options for the `Run` method.
+type Options[In any, Out any] struct {
+	// Workers is the number of goroutines used to process records. If 0 then `runtime.NumCPU` will be used.
+	Workers int
+	// BufferSize is the maximum number of records which have been read but not yet consumed by the sink. Once it
+	// is reached no more records are read until the sink catches up. It is also the size of the reorder buffer when
+	// `Ordered` is true. If less than `Workers` then twice the number of workers will be used.
+	BufferSize int
+	// Ordered ensures that results are passed to the sink in the same order that records were read.
+	Ordered bool
+	// Process is the function used to process each record.
+	Process ProcessFunc[In, Out]
+	// Sink is an optional function used to consume the result of processing each record.
+	Sink SinkFunc[Out]
+	// SinkFailures causes the results of records which failed to be processed to still be passed to the sink.
+	SinkFailures bool
+	// EmitErrors is the error policy for errors yielded by the iterator.
+	EmitErrors ErrorPolicy
+	// ProcessErrors is the error policy for errors returned by the process function.
+	ProcessErrors ErrorPolicy
+	// SinkErrors is the error policy for errors returned by the sink function.
+	SinkErrors ErrorPolicy
+	// OnError is an optional function called for every error.
+	OnError ErrorFunc
+	// Progress is an optional function called every `ProgressInterval` while the pipeline is running.
+	Progress ProgressFunc
+	// ProgressInterval is the interval at which `Progress` is called. If 0 then 10 seconds will be used.
+	ProgressInterval time.Duration
+}
+
+// Stats are the statistics for a pipeline.
+type Stats struct {
+	// The number of records read from the iterator and dispatched to workers.
+	Emitted int64
+	// The number of errors yielded by the iterator.
+	EmitErrors int64
+	// The number of records processed, successfully or not.
+	Processed int64
+	// The number of records which failed to be processed.
+	ProcessErrors int64
+	// The number of results consumed by the sink, successfully or not.
+	Sunk int64
+	// The number of results which the sink failed to consume.
+	SinkErrors int64
+	// The cumulative time spent processing records.
+	ProcessTime time.Duration
+	// The time since the pipeline started.
+	Elapsed time.Duration
+}
+
+// Throughput returns the number of records processed per second.
+func (s *Stats) Throughput() float64 {
+
+	if s.Elapsed <= 0 {
+		return 0.0
+	}
+
+	return float64(s.Processed) / s.Elapsed.Seconds()
+}
+
+// AverageProcessTime returns the average time spent processing a record.
+func (s *Stats) AverageProcessTime() time.Duration {
+
+	if s.Processed == 0 {
+		return 0
+	}
+
+	return s.ProcessTime / time.Duration(s.Processed)
+}
+
+type item[In any, Out any] struct {
+	seq int64
+	in  In
+	out Out
+	err error
+}
+
+type pipeline struct {
+	start          time.Time
+	emitted        int64
+	emit_errors    int64
+	processed      int64
+	process_errors int64
+	sunk           int64
+	sink_errors    int64
+	process_time   int64
+	on_error       ErrorFunc
+	stop           context.CancelCauseFunc
+}
+
+// Run reads records from 'seq', processes them using a fixed pool of workers and passes the results to a sink
+// as defined by 'opts'. It returns once all the records have been consumed or the pipeline is stopped. When 'ctx'
+// is cancelled, or an error policy is exceeded, no more records are read but records which have already been
+// dispatched are processed and sunk before `Run` returns (the process and sink functions are passed a context
+// which is not cancelled). In that case the final statistics are returned along with the reason the pipeline
+// was stopped.
+func Run[In any, Out any](ctx context.Context, seq iter.Seq2[In, error], opts *Options[In, Out]) (*Stats, error) {
+
+	if opts.Process == nil {
+		return nil, fmt.Errorf("Missing process function")
+	}
+
+	workers := opts.Workers
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	buffer_size := opts.BufferSize
+
+	if buffer_size < workers {
+		buffer_size = workers * 2
+	}
+
+	progress_interval := opts.ProgressInterval
+
+	if progress_interval <= 0 {
+		progress_interval = 10 * time.Second
+	}
+
+	on_error := opts.OnError
+
+	if on_error == nil {
+		on_error = func(ctx context.Context, stage Stage, err error) {
+			slog.Error("Pipeline error", "stage", stage, "error", err)
+		}
+	}
+
+	stop_ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	work_ctx := context.WithoutCancel(ctx)
+
+	p := &pipeline{
+		start:    time.Now(),
+		on_error: on_error,
+		stop:     stop,
+	}
+
+	// Each record holds a token from the time it is read until it is sunk
+	tokens := make(chan bool, buffer_size)
+
+	jobs := make(chan *item[In, Out])
+	results := make(chan *item[In, Out], buffer_size)
+
+	workers_wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		workers_wg.Add(1)
+
+		go func() {
+
+			defer workers_wg.Done()
+
+			for it := range jobs {
+
+				t1 := time.Now()
+				it.out, it.err = opts.Process(work_ctx, it.in)
+
+				atomic.AddInt64(&p.process_time, int64(time.Since(t1)))
+				atomic.AddInt64(&p.processed, 1)
+
+				if it.err != nil {
+					p.fail(work_ctx, PROCESS, it.err, &p.process_errors, opts.ProcessErrors)
+				}
+
+				results <- it
+			}
+		}()
+	}
+
+	sink_done := make(chan bool)
+
+	go func() {
+
+		defer close(sink_done)
+
+		sink := func(it *item[In, Out]) {
+
+			defer func() {
+				<-tokens
+			}()
+
+			if it.err != nil && !opts.SinkFailures {
+				return
+			}
+
+			if opts.Sink == nil {
+				return
+			}
+
+			err := opts.Sink(work_ctx, it.out)
+			atomic.AddInt64(&p.sunk, 1)
+
+			if err != nil {
+				p.fail(work_ctx, SINK, err, &p.sink_errors, opts.SinkErrors)
+			}
+		}
+
+		pending := make(map[int64]*item[In, Out])
+		next := int64(0)
+
+		for it := range results {
+
+			if !opts.Ordered {
+				sink(it)
+				continue
+			}
+
+			pending[it.seq] = it
+
+			for {
+
+				next_it, exists := pending[next]
+
+				if !exists {
+					break
+				}
+
+				delete(pending, next)
+				sink(next_it)
+				next += 1
+			}
+		}
+	}()
+
+	progress_done := make(chan bool)
+	progress_wg := new(sync.WaitGroup)
+
+	if opts.Progress != nil {
+
+		progress_wg.Add(1)
+
+		go func() {
+
+			defer progress_wg.Done()
+
+			ticker := time.NewTicker(progress_interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-progress_done:
+					return
+				case <-ticker.C:
+					opts.Progress(p.stats())
+				}
+			}
+		}()
+	}
+
+	seq_idx := int64(0)
+
+	for in, err := range seq {
+
+		if stop_ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			p.fail(stop_ctx, EMIT, err, &p.emit_errors, opts.EmitErrors)
+			continue
+		}
+
+		select {
+		case <-stop_ctx.Done():
+		case tokens <- true:
+		}
+
+		if stop_ctx.Err() != nil {
+			break
+		}
+
+		atomic.AddInt64(&p.emitted, 1)
+
+		jobs <- &item[In, Out]{seq: seq_idx, in: in}
+		seq_idx += 1
+	}
+
+	close(jobs)
+	workers_wg.Wait()
+
+	close(results)
+	<-sink_done
+
+	close(progress_done)
+	progress_wg.Wait()
+
+	return p.stats(), context.Cause(stop_ctx)
+}
+
+// fail records 'err' for 'stage', incrementing 'counter', and stops the pipeline if 'policy' has been exceeded.
+func (p *pipeline) fail(ctx context.Context, stage Stage, err error, counter *int64, policy ErrorPolicy) {
+
+	p.on_error(ctx, stage, err)
+
+	count := atomic.AddInt64(counter, 1)
+
+	if policy.Halt || (policy.MaxErrors > 0 && count > policy.MaxErrors) {
+		p.stop(fmt.Errorf("%w (%s), %w", ErrTooManyErrors, stage, err))
+	}
+}
+
+// stats returns a snapshot of the pipeline's statistics.
+func (p *pipeline) stats() *Stats {
+
+	s := &Stats{
+		Emitted:       atomic.LoadInt64(&p.emitted),
+		EmitErrors:    atomic.LoadInt64(&p.emit_errors),
+		Processed:     atomic.LoadInt64(&p.processed),
+		ProcessErrors: atomic.LoadInt64(&p.process_errors),
+		Sunk:          atomic.LoadInt64(&p.sunk),
+		SinkErrors:    atomic.LoadInt64(&p.sink_errors),
+		ProcessTime:   time.Duration(atomic.LoadInt64(&p.process_time)),
+		Elapsed:       time.Since(p.start),
+	}
+
+	return s
+}
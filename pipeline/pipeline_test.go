@@ -0,0 +1,341 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+)
+
+// numbers returns an iterator yielding the numbers from 0 to 'count' - 1. If 'fail' is not nil it yields an
+// error instead of the numbers for which it returns true.
+func numbers(count int, fail func(int) bool) iter.Seq2[int, error] {
+
+	return func(yield func(int, error) bool) {
+
+		for i := 0; i < count; i++ {
+
+			if fail != nil && fail(i) {
+
+				if !yield(0, fmt.Errorf("Failed to emit %d", i)) {
+					return
+				}
+
+				continue
+			}
+
+			if !yield(i, nil) {
+				return
+			}
+		}
+	}
+}
+
+func ignoreErrors(ctx context.Context, stage Stage, err error) {}
+
+func TestRunOrdered(t *testing.T) {
+
+	ctx := context.Background()
+	count := 100
+
+	sunk := make([]int, 0, count)
+
+	opts := &Options[int, int]{
+		Workers: 8,
+		Ordered: true,
+		// Process earlier records more slowly than later ones so that results arrive out of order
+		Process: func(ctx context.Context, i int) (int, error) {
+			time.Sleep(time.Duration((count-i)%10) * time.Millisecond)
+			return i * 2, nil
+		},
+		Sink: func(ctx context.Context, i int) error {
+			sunk = append(sunk, i)
+			return nil
+		},
+	}
+
+	stats, err := Run(ctx, numbers(count, nil), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to run pipeline, %v", err)
+	}
+
+	if stats.Processed != int64(count) || stats.Sunk != int64(count) {
+		t.Fatalf("Unexpected stats, processed %d and sunk %d", stats.Processed, stats.Sunk)
+	}
+
+	for i, v := range sunk {
+
+		if v != i*2 {
+			t.Fatalf("Result %d is out of order, expected %d but got %d", i, i*2, v)
+		}
+	}
+}
+
+func TestRunOrderedWithFailures(t *testing.T) {
+
+	ctx := context.Background()
+	count := 50
+
+	sunk := make([]int, 0, count)
+
+	opts := &Options[int, int]{
+		Workers: 4,
+		Ordered: true,
+		Process: func(ctx context.Context, i int) (int, error) {
+
+			if i%5 == 0 {
+				return -1, fmt.Errorf("Failed to process %d", i)
+			}
+
+			return i, nil
+		},
+		Sink: func(ctx context.Context, i int) error {
+			sunk = append(sunk, i)
+			return nil
+		},
+		OnError: ignoreErrors,
+	}
+
+	stats, err := Run(ctx, numbers(count, nil), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to run pipeline, %v", err)
+	}
+
+	if stats.ProcessErrors != 10 {
+		t.Fatalf("Expected 10 process errors, got %d", stats.ProcessErrors)
+	}
+
+	// Failures are skipped, rather than blocking the results which follow them
+
+	if len(sunk) != 40 {
+		t.Fatalf("Expected 40 results, got %d", len(sunk))
+	}
+
+	for i := 1; i < len(sunk); i++ {
+
+		if sunk[i] <= sunk[i-1] || sunk[i]%5 == 0 {
+			t.Fatalf("Unexpected result %d at position %d", sunk[i], i)
+		}
+	}
+}
+
+func TestRunSinkFailures(t *testing.T) {
+
+	ctx := context.Background()
+
+	sunk := 0
+
+	opts := &Options[int, int]{
+		Workers:      2,
+		SinkFailures: true,
+		Process: func(ctx context.Context, i int) (int, error) {
+
+			if i%2 == 0 {
+				return i, fmt.Errorf("Failed to process %d", i)
+			}
+
+			return i, nil
+		},
+		Sink: func(ctx context.Context, i int) error {
+			sunk += 1
+			return nil
+		},
+		OnError: ignoreErrors,
+	}
+
+	_, err := Run(ctx, numbers(10, nil), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to run pipeline, %v", err)
+	}
+
+	if sunk != 10 {
+		t.Fatalf("Expected 10 results, including failures, got %d", sunk)
+	}
+}
+
+func TestRunErrorPolicies(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		opts *Options[int, int]
+		seq  iter.Seq2[int, error]
+		// The stage which is expected to stop the pipeline, or "" if it should run to completion
+		stage Stage
+		check func(*Stats) error
+	}{
+		{
+			name: "default policy continues",
+			seq:  numbers(20, func(i int) bool { return i%4 == 0 }),
+			opts: &Options[int, int]{
+				Process: func(ctx context.Context, i int) (int, error) {
+					return i, fmt.Errorf("Failed to process %d", i)
+				},
+			},
+			check: func(s *Stats) error {
+
+				if s.EmitErrors != 5 || s.Processed != 15 || s.ProcessErrors != 15 {
+					return fmt.Errorf("Unexpected stats %+v", *s)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "emit errors halt",
+			seq:  numbers(20, func(i int) bool { return i == 10 }),
+			opts: &Options[int, int]{
+				EmitErrors: ErrorPolicy{Halt: true},
+				Process: func(ctx context.Context, i int) (int, error) {
+					return i, nil
+				},
+			},
+			stage: EMIT,
+			check: func(s *Stats) error {
+
+				if s.EmitErrors != 1 || s.Emitted != 10 {
+					return fmt.Errorf("Expected 10 records to be emitted before stopping, got %+v", *s)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "process errors exceed maximum",
+			seq:  numbers(1000, nil),
+			opts: &Options[int, int]{
+				Workers:       1,
+				ProcessErrors: ErrorPolicy{MaxErrors: 3},
+				Process: func(ctx context.Context, i int) (int, error) {
+					return i, fmt.Errorf("Failed to process %d", i)
+				},
+			},
+			stage: PROCESS,
+			check: func(s *Stats) error {
+
+				if s.ProcessErrors < 4 || s.Processed == 1000 {
+					return fmt.Errorf("Expected processing to stop after 4 errors, got %+v", *s)
+				}
+
+				if s.Processed != s.Emitted {
+					return fmt.Errorf("Expected every emitted record to be processed, got %+v", *s)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "sink errors halt",
+			seq:  numbers(1000, nil),
+			opts: &Options[int, int]{
+				Workers:    1,
+				SinkErrors: ErrorPolicy{Halt: true},
+				Process: func(ctx context.Context, i int) (int, error) {
+					return i, nil
+				},
+				Sink: func(ctx context.Context, i int) error {
+
+					if i == 5 {
+						return fmt.Errorf("Failed to sink %d", i)
+					}
+
+					return nil
+				},
+			},
+			stage: SINK,
+			check: func(s *Stats) error {
+
+				if s.SinkErrors != 1 || s.Sunk == 1000 {
+					return fmt.Errorf("Expected sinking to stop after 1 error, got %+v", *s)
+				}
+
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			test.opts.OnError = ignoreErrors
+
+			stats, err := Run(ctx, test.seq, test.opts)
+
+			switch {
+			case test.stage == "" && err != nil:
+				t.Fatalf("Failed to run pipeline, %v", err)
+			case test.stage != "" && !errors.Is(err, ErrTooManyErrors):
+				t.Fatalf("Expected ErrTooManyErrors, got %v", err)
+			case test.stage != "" && !strings.Contains(err.Error(), fmt.Sprintf("(%s)", test.stage)):
+				t.Fatalf("Expected pipeline to be stopped by the %s stage, got %v", test.stage, err)
+			}
+
+			if stats == nil {
+				t.Fatalf("Missing stats")
+			}
+
+			err = test.check(stats)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sunk := 0
+
+	opts := &Options[int, int]{
+		Workers: 2,
+		Process: func(ctx context.Context, i int) (int, error) {
+
+			// The context passed to the process function is never cancelled
+			if ctx.Err() != nil {
+				return i, ctx.Err()
+			}
+
+			return i, nil
+		},
+		Sink: func(ctx context.Context, i int) error {
+
+			sunk += 1
+
+			if i == 10 {
+				cancel()
+			}
+
+			return nil
+		},
+	}
+
+	stats, err := Run(ctx, numbers(1000, nil), opts)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if stats.ProcessErrors != 0 {
+		t.Fatalf("Expected no process errors, got %d", stats.ProcessErrors)
+	}
+
+	if stats.Sunk != stats.Emitted || int64(sunk) != stats.Emitted {
+		t.Fatalf("Expected every emitted record to be sunk, got %+v", *stats)
+	}
+
+	if stats.Emitted == 1000 {
+		t.Fatalf("Expected the pipeline to stop early")
+	}
+}
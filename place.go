@@ -1,7 +1,12 @@
 package places
 
 import (
+	"encoding/json"
 	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // 2024/11/21 19:33:43 INFO ROW row="map[address:Mickiewicza 8 admin_region: country:PL date_closed: date_created:2015-05-06 date_refreshed:2024-06-27 dt:2024-11-19 email:teresa.glowacka.fotos.kolo@neostrada.pl facebook_id: fsq_category_ids:[4d4b7105d754a06378d81259] fsq_category_labels:[Retail] fsq_place_id:cb57d89eed29405b908b0b6e instagram: latitude:52.19266718928583 locality:Koło longitude:18.63343577621856 name:Fotos. Zakład fotograficzny. Głowacka T. po_box: post_town: postcode:62-600 region:Wielkopolskie tel:63 272 08 68 twitter: website:]
@@ -19,6 +24,7 @@ type Place struct {
 	Instagram     string     `json:"instagram"`
 	Latitude      float64    `json:"latitude"`
 	Longitude     float64    `json:"longitude"`
+	Locality      string     `json:"locality"`
 	Name          string     `json:"name"`
 	PostBox       string     `json:"po_box"`
 	PostTown      string     `json:"post_town"`
@@ -28,12 +34,52 @@ type Place struct {
 	Twitter       string     `json:"twitter"`
 	Website       string     `json:"website"`
 	Categories    []Category `json:"categories"`
+	// The context of the span in which the place was emitted, if any. It is not encoded.
+	span_context trace.SpanContext
 }
 
 func (pl *Place) String() string {
 	return fmt.Sprintf("%s %s", pl.Name, pl.Id)
 }
 
+// SpanContext returns the context of the (OpenTelemetry) span in which 'pl' was emitted. The span context is
+// invalid if 'pl' was not traced.
+func (pl *Place) SpanContext() trace.SpanContext {
+	return pl.span_context
+}
+
+// SetSpanContext records 'sc' as the context of the (OpenTelemetry) span in which 'pl' was emitted.
+func (pl *Place) SetSpanContext(sc trace.SpanContext) {
+	pl.span_context = sc
+}
+
+// AsFeature returns 'pl' as a GeoJSON Feature with a Point geometry. The feature's properties are the
+// JSON-encoded properties of 'pl'.
+func (pl *Place) AsFeature() (*geojson.Feature, error) {
+
+	enc, err := json.Marshal(pl)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal place, %w", err)
+	}
+
+	var props map[string]any
+
+	err = json.Unmarshal(enc, &props)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal place properties, %w", err)
+	}
+
+	pt := orb.Point([2]float64{pl.Longitude, pl.Latitude})
+
+	f := geojson.NewFeature(pt)
+	f.ID = pl.Id
+	f.Properties = props
+
+	return f, nil
+}
+
 type Category struct {
 	Id     string   `json:"id"`
 	Labels []string `json:"labels"`
@@ -0,0 +1,131 @@
+// Package repair provides methods for selecting the rows in the output of the reverse-geocode tool which should
+// be reverse-geocoded again, for example because they failed or were not matched to a parent.
+package repair
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/whosonfirst/go-foursquare-places/reversegeo"
+)
+
+// Predicate matches rows whose value for a column is (or is not) equal to a given value.
+type Predicate struct {
+	// Column is the name of the column to compare.
+	Column string
+	// Value is the value to compare the column's value with.
+	Value string
+	// Negate causes the predicate to match rows whose value for the column is not equal to `Value`.
+	Negate bool
+}
+
+// ParsePredicate parses 'str' in the form of "{COLUMN}={VALUE}" or "{COLUMN}!={VALUE}" returning a new `Predicate` instance.
+func ParsePredicate(str string) (*Predicate, error) {
+
+	negate := false
+
+	column, value, ok := strings.Cut(str, "!=")
+
+	if ok {
+		negate = true
+	} else {
+
+		column, value, ok = strings.Cut(str, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid predicate '%s', expected {COLUMN}={VALUE} or {COLUMN}!={VALUE}", str)
+		}
+	}
+
+	column = strings.TrimSpace(column)
+
+	if column == "" {
+		return nil, fmt.Errorf("Invalid predicate '%s', missing column", str)
+	}
+
+	p := &Predicate{
+		Column: column,
+		Value:  value,
+		Negate: negate,
+	}
+
+	return p, nil
+}
+
+// Match returns true if 'row' matches the predicate. Rows which do not have a value for the predicate's column
+// are treated as though the value were empty.
+func (p *Predicate) Match(row map[string]string) bool {
+
+	eq := row[p.Column] == p.Value
+
+	if p.Negate {
+		return !eq
+	}
+
+	return eq
+}
+
+// String returns the string representation of the predicate.
+func (p *Predicate) String() string {
+
+	if p.Negate {
+		return fmt.Sprintf("%s!=%s", p.Column, p.Value)
+	}
+
+	return fmt.Sprintf("%s=%s", p.Column, p.Value)
+}
+
+// Selector selects the rows to repair. A row is selected if it matches any of the selector's criteria.
+type Selector struct {
+	// Unmatched selects rows which were not matched to a parent (their `wof:parent_id` column is -1).
+	Unmatched bool
+	// Failed selects rows which failed to be reverse-geocoded (their `reversegeo:reason` column is "error"). This
+	// column is only present if the reverse-geocode tool was run with the -with-metadata flag.
+	Failed bool
+	// Predicates are additional criteria for selecting rows.
+	Predicates []*Predicate
+}
+
+// IsZero returns true if the selector has no criteria and will not select any rows.
+func (s *Selector) IsZero() bool {
+	return !s.Unmatched && !s.Failed && len(s.Predicates) == 0
+}
+
+// Match returns true if 'row' should be repaired.
+func (s *Selector) Match(row map[string]string) bool {
+
+	if s.Unmatched && row["wof:parent_id"] == "-1" {
+		return true
+	}
+
+	if s.Failed && row["reversegeo:reason"] == reversegeo.REASON_ERROR {
+		return true
+	}
+
+	for _, p := range s.Predicates {
+
+		if p.Match(row) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fill returns a copy of 'row' with an empty string for any column in 'columns' which is not present in 'row'. Since
+// CSV files have a fixed set of columns this is used to ensure that repaired rows, which may have been produced with
+// different flags, and the original rows can be written to the same file.
+func Fill(row map[string]string, columns []string) map[string]string {
+
+	out := make(map[string]string, len(columns))
+
+	for _, k := range columns {
+		out[k] = ""
+	}
+
+	for k, v := range row {
+		out[k] = v
+	}
+
+	return out
+}
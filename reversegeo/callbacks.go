@@ -0,0 +1,82 @@
+package reversegeo
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// SmallestAreaSPRResultsFunc returns the record in 'possible' with the smallest area or nil if 'possible' is empty.
+// Areas are derived from the `geom:area` property of each record, as read from 'r', or calculated from its geometry
+// if that property is absent. Records which can not be read are ignored. If no records can be read the first record
+// in 'possible' is returned.
+func SmallestAreaSPRResultsFunc(ctx context.Context, r reader.Reader, body []byte, possible []spr.StandardPlacesResult) (spr.StandardPlacesResult, error) {
+
+	switch len(possible) {
+	case 0:
+		return nil, nil
+	case 1:
+		return possible[0], nil
+	}
+
+	var smallest spr.StandardPlacesResult
+	smallest_area := math.MaxFloat64
+
+	for _, s := range possible {
+
+		area, err := resultArea(ctx, r, s)
+
+		if err != nil {
+			slog.Debug("Failed to derive area for candidate", "id", s.Id(), "error", err)
+			continue
+		}
+
+		if area < smallest_area {
+			smallest = s
+			smallest_area = area
+		}
+	}
+
+	if smallest == nil {
+		return possible[0], nil
+	}
+
+	return smallest, nil
+}
+
+// resultArea returns the area (in square degrees) for 's'.
+func resultArea(ctx context.Context, r reader.Reader, s spr.StandardPlacesResult) (float64, error) {
+
+	id, err := strconv.ParseInt(s.Id(), 10, 64)
+
+	if err != nil {
+		return 0.0, err
+	}
+
+	body, err := loadBytes(ctx, r, id, "properties_reader")
+
+	if err != nil {
+		return 0.0, err
+	}
+
+	area_rsp := gjson.GetBytes(body, "properties.geom:area")
+
+	if area_rsp.Exists() {
+		return area_rsp.Float(), nil
+	}
+
+	f, err := geojson.UnmarshalFeature(body)
+
+	if err != nil {
+		return 0.0, err
+	}
+
+	return planar.Area(f.Geometry), nil
+}
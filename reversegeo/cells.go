@@ -0,0 +1,225 @@
+package reversegeo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/whosonfirst/go-foursquare-places/metrics"
+)
+
+// DEFAULT_CELL_PRECISION is the default number of geohash characters used to derive cells. A precision of 7 yields
+// cells of approximately 150 x 150 metres.
+const DEFAULT_CELL_PRECISION int = 7
+
+// UNCACHEABLE_CELL is the parent ID stored for cells which are known not to be contained by a single parent polygon.
+const UNCACHEABLE_CELL int64 = -2
+
+// CellEntry is the parent recorded for a geohash cell.
+type CellEntry struct {
+	// The Who's On First ID of the parent record or `UNCACHEABLE_CELL`.
+	ParentId int64 `json:"wof:parent_id"`
+	// The last modified time of the parent record when the cell was cached or -1 if unknown.
+	LastModified int64 `json:"wof:lastmodified"`
+}
+
+// CellCache memoizes the results of point-in-polygon operations for geohash cells. A cell is only cached
+// when it is known to lie entirely inside a single parent polygon, and no other candidate intersects it, so
+// it is safe to reuse the result for any point inside that cell. Cells which are known to straddle two or
+// more polygons are also recorded so that the (expensive) containment tests aren't performed more than once.
+//
+// A cache is only valid for the spatial database, and filter criteria, it was populated with. These are
+// identified by a fingerprint (see `CellCacheFingerprint`) which is persisted by the `Save` method and
+// checked by the `Load` method.
+type CellCache struct {
+	precision   int
+	fingerprint string
+	mu          *sync.RWMutex
+	cells       map[string]CellEntry
+	hits        int64
+	misses      int64
+}
+
+// cellCacheFile is the JSON-encoded representation of a `CellCache` written by the `Save` method.
+type cellCacheFile struct {
+	Fingerprint string               `json:"fingerprint"`
+	Precision   int                  `json:"precision"`
+	Cells       map[string]CellEntry `json:"cells"`
+}
+
+// CellCacheFingerprint returns a hash of 'filter_opts' and 'uris', the URIs of the spatial database and any
+// database used to determine which records intersect a cell, used to identify the databases, and filter
+// criteria, a `CellCache` was populated with.
+func CellCacheFingerprint(filter_opts *FilterOptions, uris ...string) (string, error) {
+
+	enc_opts, err := json.Marshal(filter_opts)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal filter options, %w", err)
+	}
+
+	h := sha256.New()
+
+	for _, uri := range uris {
+		h.Write([]byte(uri))
+		h.Write([]byte{0})
+	}
+
+	h.Write(enc_opts)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewCellCache returns a new, empty, `CellCache` instance for geohash cells with 'precision' characters
+// populated using the spatial database, and filter criteria, identified by 'fingerprint'.
+func NewCellCache(precision int, fingerprint string) (*CellCache, error) {
+
+	if precision < 1 || precision > 12 {
+		return nil, fmt.Errorf("Invalid precision, must be between 1 and 12")
+	}
+
+	c := &CellCache{
+		precision:   precision,
+		fingerprint: fingerprint,
+		mu:          new(sync.RWMutex),
+		cells:       make(map[string]CellEntry),
+	}
+
+	return c, nil
+}
+
+// Cell returns the geohash cell containing 'lat' and 'lon'.
+func (c *CellCache) Cell(lat float64, lon float64) string {
+	return EncodeGeohash(lat, lon, c.precision)
+}
+
+// Get returns the entry associated with 'cell' and a boolean flag signaling whether 'cell' has been recorded.
+// The entry's parent ID will be `UNCACHEABLE_CELL` for cells which are not contained by a single polygon.
+func (c *CellCache) Get(cell string) (CellEntry, bool) {
+
+	c.mu.RLock()
+	entry, exists := c.cells[cell]
+	c.mu.RUnlock()
+
+	hit := exists && entry.ParentId != UNCACHEABLE_CELL
+
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+
+	metrics.ObserveCache(metrics.CACHE_CELL, hit)
+
+	return entry, exists
+}
+
+// Set associates 'entry' with 'cell'.
+func (c *CellCache) Set(cell string, entry CellEntry) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cells[cell] = entry
+}
+
+// Delete removes 'cell' from the cache.
+func (c *CellCache) Delete(cell string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.cells, cell)
+}
+
+// Stats returns the number of cache hits and misses recorded so far.
+func (c *CellCache) Stats() (int64, int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+// HitRate returns the ratio of cache hits to total lookups recorded so far.
+func (c *CellCache) HitRate() float64 {
+
+	hits, misses := c.Stats()
+	total := hits + misses
+
+	if total == 0 {
+		return 0.0
+	}
+
+	return float64(hits) / float64(total)
+}
+
+// Load reads cells previously written by the `Save` method from 'path'. If the file was written by a cache
+// with a different fingerprint or precision it is discarded. It is not an error if 'path' does not exist.
+func (c *CellCache) Load(path string) error {
+
+	r, err := os.Open(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer r.Close()
+
+	var f cellCacheFile
+
+	err = json.NewDecoder(r).Decode(&f)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	if f.Fingerprint != c.fingerprint || f.Precision != c.precision {
+		slog.Info("Discarding cell cache created with a different spatial database, filters or precision", "path", path)
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cell, entry := range f.Cells {
+		c.cells[cell] = entry
+	}
+
+	return nil
+}
+
+// Save writes all the cells in the cache, and its fingerprint and precision, to 'path' as JSON data.
+func (c *CellCache) Save(path string) error {
+
+	wr, err := os.Create(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create %s, %w", path, err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	f := cellCacheFile{
+		Fingerprint: c.fingerprint,
+		Precision:   c.precision,
+		Cells:       c.cells,
+	}
+
+	err = json.NewEncoder(wr).Encode(f)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to encode %s, %w", path, err)
+	}
+
+	return wr.Close()
+}
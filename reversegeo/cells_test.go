@@ -0,0 +1,326 @@
+package reversegeo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+func TestCellCacheFingerprint(t *testing.T) {
+
+	opts := DefaultFilterOptions()
+
+	fp, err := CellCacheFingerprint(opts, "sqlite://sqlite3?dsn=a.db")
+
+	if err != nil {
+		t.Fatalf("Failed to derive fingerprint, %v", err)
+	}
+
+	again, _ := CellCacheFingerprint(opts, "sqlite://sqlite3?dsn=a.db")
+
+	if again != fp {
+		t.Fatalf("Expected fingerprints for the same inputs to match")
+	}
+
+	other_opts := DefaultFilterOptions()
+	other_opts.Placetypes = []string{"locality"}
+
+	others := [][]string{
+		{"sqlite://sqlite3?dsn=b.db"},
+		{"sqlite://sqlite3?dsn=a.db", "sqlite://sqlite3?dsn=rtree.db"},
+		// Each URI is delimited so that they can't run together
+		{"sqlite://sqlite3?dsn=a.d", "b"},
+	}
+
+	for _, uris := range others {
+
+		other, _ := CellCacheFingerprint(opts, uris...)
+
+		if other == fp {
+			t.Fatalf("Expected fingerprint for %v to differ", uris)
+		}
+	}
+
+	other, _ := CellCacheFingerprint(other_opts, "sqlite://sqlite3?dsn=a.db")
+
+	if other == fp {
+		t.Fatalf("Expected fingerprint for different filter options to differ")
+	}
+}
+
+func TestCellCacheSaveLoad(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "cells.json")
+
+	c, _ := NewCellCache(7, "abc")
+
+	c.Set("9q8yyk8", CellEntry{ParentId: 85922583, LastModified: 1700000000})
+	c.Set("9q8yyk9", CellEntry{ParentId: UNCACHEABLE_CELL, LastModified: -1})
+
+	err := c.Save(path)
+
+	if err != nil {
+		t.Fatalf("Failed to save cell cache, %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		precision   int
+		fingerprint string
+		expected    bool
+	}{
+		{"same fingerprint", 7, "abc", true},
+		{"different fingerprint", 7, "def", false},
+		{"different precision", 6, "abc", false},
+	}
+
+	for _, test := range tests {
+
+		loaded, _ := NewCellCache(test.precision, test.fingerprint)
+
+		err := loaded.Load(path)
+
+		if err != nil {
+			t.Fatalf("%s: Failed to load cell cache, %v", test.name, err)
+		}
+
+		entry, exists := loaded.Get("9q8yyk8")
+
+		if exists != test.expected {
+			t.Fatalf("%s: expected cell to be loaded: %t", test.name, test.expected)
+		}
+
+		if !test.expected {
+			continue
+		}
+
+		if entry.ParentId != 85922583 || entry.LastModified != 1700000000 {
+			t.Fatalf("%s: unexpected entry %+v", test.name, entry)
+		}
+
+		entry, exists = loaded.Get("9q8yyk9")
+
+		if !exists || entry.ParentId != UNCACHEABLE_CELL {
+			t.Fatalf("%s: expected uncacheable cell to be loaded, got %+v", test.name, entry)
+		}
+	}
+
+	// Missing files are not an error
+
+	err = c.Load(filepath.Join(t.TempDir(), "missing.json"))
+
+	if err != nil {
+		t.Fatalf("Expected missing file to be ignored, %v", err)
+	}
+}
+
+// testRecord is a Who's On First record used to populate the spatial database and properties reader for tests.
+type testRecord struct {
+	id           int64
+	lastmodified int64
+	geom         orb.Polygon
+}
+
+// testResolver returns a new `Resolver` whose spatial database and properties reader contain 'records', as
+// localities, and which uses 'cell_cache'.
+func testResolver(t *testing.T, records []testRecord, cell_cache *CellCache) *Resolver {
+
+	ctx := context.Background()
+
+	spatial_db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	root := t.TempDir()
+
+	for _, rec := range records {
+
+		f := geojson.NewFeature(rec.geom)
+
+		f.Properties = map[string]any{
+			"wof:id":           rec.id,
+			"wof:name":         fmt.Sprintf("Locality %d", rec.id),
+			"wof:placetype":    "locality",
+			"wof:parent_id":    -1,
+			"wof:country":      "XY",
+			"wof:repo":         "whosonfirst-data-admin-xy",
+			"wof:lastmodified": rec.lastmodified,
+			"wof:belongsto":    []int64{},
+			"wof:hierarchy":    []map[string]int64{{"locality_id": rec.id}},
+			"mz:is_current":    1,
+		}
+
+		body, err := f.MarshalJSON()
+
+		if err != nil {
+			t.Fatalf("Failed to marshal %d, %v", rec.id, err)
+		}
+
+		err = spatial_db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index %d, %v", rec.id, err)
+		}
+
+		rel_path, _ := uri.Id2RelPath(rec.id)
+		path := filepath.Join(root, rel_path)
+
+		os.MkdirAll(filepath.Dir(path), 0755)
+
+		err = os.WriteFile(path, body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %d, %v", rec.id, err)
+		}
+	}
+
+	properties_reader, err := reader.NewReader(ctx, "fs://"+root)
+
+	if err != nil {
+		t.Fatalf("Failed to create properties reader, %v", err)
+	}
+
+	// The records whose bounding boxes intersect a cell
+
+	intersects := func(ctx context.Context, b orb.Bound, inputs *filter.SPRInputs) ([]int64, error) {
+
+		ids := make([]int64, 0)
+
+		for _, rec := range records {
+
+			if rec.geom.Bound().Intersects(b) {
+				ids = append(ids, rec.id)
+			}
+		}
+
+		return ids, nil
+	}
+
+	opts := &ResolverOptions{
+		SpatialDatabase:  spatial_db,
+		PropertiesReader: properties_reader,
+		Inputs: &filter.SPRInputs{
+			Placetypes: []string{"locality"},
+		},
+		CellCache:      cell_cache,
+		CellIntersects: intersects,
+	}
+
+	r, err := NewResolver(ctx, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create resolver, %v", err)
+	}
+
+	return r
+}
+
+func TestResolvePlaceCellCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	records := []testRecord{
+		{101, 1000, orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}},
+		{102, 600, orb.Polygon{{{2, 0}, {3, 0}, {3, 1}, {2, 1}, {2, 0}}}},
+	}
+
+	c, _ := NewCellCache(6, "test")
+	r := testResolver(t, records, c)
+
+	resolve := func(lat float64, lon float64) *Result {
+
+		pl := &places.Place{
+			Id:        fmt.Sprintf("%f,%f", lat, lon),
+			Latitude:  lat,
+			Longitude: lon,
+		}
+
+		rsp, err := r.ResolvePlace(ctx, pl)
+
+		if err != nil {
+			t.Fatalf("Failed to resolve %s, %v", pl.Id, err)
+		}
+
+		return rsp
+	}
+
+	// Cells inside a single parent are cached with the parent's last modified time
+
+	rsp := resolve(0.5, 0.5)
+
+	if rsp.ParentId != 101 {
+		t.Fatalf("Expected parent 101, got %d", rsp.ParentId)
+	}
+
+	entry, exists := c.Get(c.Cell(0.5, 0.5))
+
+	if !exists || entry.ParentId != 101 || entry.LastModified != 1000 {
+		t.Fatalf("Expected cell to be cached, got %+v", entry)
+	}
+
+	hits, _ := c.Stats()
+
+	rsp = resolve(0.5001, 0.5001)
+
+	if rsp.ParentId != 101 {
+		t.Fatalf("Expected parent 101 from cached cell, got %d", rsp.ParentId)
+	}
+
+	if h, _ := c.Stats(); h != hits+1 {
+		t.Fatalf("Expected cached cell to be used")
+	}
+
+	// Cells which straddle the edge of a parent are not
+
+	rsp = resolve(0.5, 0.00001)
+
+	if rsp.ParentId != 101 {
+		t.Fatalf("Expected parent 101, got %d", rsp.ParentId)
+	}
+
+	entry, exists = c.Get(c.Cell(0.5, 0.00001))
+
+	if !exists || entry.ParentId != UNCACHEABLE_CELL {
+		t.Fatalf("Expected cell to be uncacheable, got %+v", entry)
+	}
+
+	// Cells whose parent has not been modified since they were cached are used as-is
+
+	cell := c.Cell(0.25, 0.25)
+	c.Set(cell, CellEntry{ParentId: 102, LastModified: 600})
+
+	rsp = resolve(0.25, 0.25)
+
+	if rsp.ParentId != 102 {
+		t.Fatalf("Expected parent 102 from cached cell, got %d", rsp.ParentId)
+	}
+
+	// Cells whose parent has been modified since they were cached are resolved (and cached) again
+
+	cell = c.Cell(0.75, 0.75)
+	c.Set(cell, CellEntry{ParentId: 102, LastModified: 500})
+
+	rsp = resolve(0.75, 0.75)
+
+	if rsp.ParentId != 101 {
+		t.Fatalf("Expected stale cell to be resolved again, got parent %d", rsp.ParentId)
+	}
+
+	entry, exists = c.Get(cell)
+
+	if !exists || entry.ParentId != 101 || entry.LastModified != 1000 {
+		t.Fatalf("Expected stale cell to be replaced, got %+v", entry)
+	}
+}
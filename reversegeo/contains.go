@@ -0,0 +1,120 @@
+package reversegeo
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// BoundWithin returns true if 'b' is entirely contained by 'geom' which is expected to be a `orb.Polygon` or a
+// `orb.MultiPolygon`. This errs on the side of caution so a bounding box which only touches the edge of 'geom'
+// is not considered to be contained.
+func BoundWithin(b orb.Bound, geom orb.Geometry) bool {
+
+	switch g := geom.(type) {
+	case orb.Polygon:
+		return boundWithinPolygon(b, g)
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+
+			if boundWithinPolygon(b, poly) {
+				return true
+			}
+		}
+
+		return false
+
+	default:
+		return false
+	}
+}
+
+func boundWithinPolygon(b orb.Bound, poly orb.Polygon) bool {
+
+	if len(poly) == 0 {
+		return false
+	}
+
+	if !poly.Bound().Contains(b.Min) || !poly.Bound().Contains(b.Max) {
+		return false
+	}
+
+	corners := []orb.Point{
+		b.Min,
+		orb.Point{b.Max.X(), b.Min.Y()},
+		b.Max,
+		orb.Point{b.Min.X(), b.Max.Y()},
+	}
+
+	for _, pt := range corners {
+
+		if !planar.PolygonContains(poly, pt) {
+			return false
+		}
+	}
+
+	// All the corners are inside the polygon but that doesn't mean the polygon (or one of its holes)
+	// doesn't cut through the bounding box so check every edge.
+
+	edges := [][2]orb.Point{
+		{corners[0], corners[1]},
+		{corners[1], corners[2]},
+		{corners[2], corners[3]},
+		{corners[3], corners[0]},
+	}
+
+	for _, ring := range poly {
+
+		for i := 0; i < len(ring)-1; i++ {
+
+			a := ring[i]
+			z := ring[i+1]
+
+			if b.Contains(a) {
+				return false
+			}
+
+			for _, e := range edges {
+
+				if segmentsIntersect(a, z, e[0], e[1]) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func segmentsIntersect(p1 orb.Point, p2 orb.Point, p3 orb.Point, p4 orb.Point) bool {
+
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	switch {
+	case d1 == 0 && onSegment(p3, p4, p1):
+		return true
+	case d2 == 0 && onSegment(p3, p4, p2):
+		return true
+	case d3 == 0 && onSegment(p1, p2, p3):
+		return true
+	case d4 == 0 && onSegment(p1, p2, p4):
+		return true
+	}
+
+	return false
+}
+
+func orientation(a orb.Point, b orb.Point, c orb.Point) float64 {
+	return (b.X()-a.X())*(c.Y()-a.Y()) - (b.Y()-a.Y())*(c.X()-a.X())
+}
+
+func onSegment(a orb.Point, b orb.Point, c orb.Point) bool {
+	return c.X() >= min(a.X(), b.X()) && c.X() <= max(a.X(), b.X()) && c.Y() >= min(a.Y(), b.Y()) && c.Y() <= max(a.Y(), b.Y())
+}
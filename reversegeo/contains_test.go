@@ -0,0 +1,93 @@
+package reversegeo
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestBoundWithin(t *testing.T) {
+
+	square := orb.Ring{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := orb.Ring{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+
+	// A "U" shaped polygon whose arms contain the corners, but not the middle, of a box between them
+	u := orb.Polygon{{{0, 0}, {10, 0}, {10, 10}, {7, 10}, {7, 3}, {3, 3}, {3, 10}, {0, 10}, {0, 0}}}
+
+	// Who's On First records which cross the antimeridian are split in to polygons on either side of it
+	fiji := orb.MultiPolygon{
+		{{{177, -19}, {180, -19}, {180, -16}, {177, -16}, {177, -19}}},
+		{{{-180, -19}, {-179, -19}, {-179, -16}, {-180, -16}, {-180, -19}}},
+	}
+
+	tests := []struct {
+		name     string
+		bound    orb.Bound
+		geom     orb.Geometry
+		expected bool
+	}{
+		{"inside", orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}, orb.Polygon{square}, true},
+		{"outside", orb.Bound{Min: orb.Point{11, 11}, Max: orb.Point{12, 12}}, orb.Polygon{square}, false},
+		{"crosses edge", orb.Bound{Min: orb.Point{9, 8}, Max: orb.Point{11, 9}}, orb.Polygon{square}, false},
+		{"touches edge", orb.Bound{Min: orb.Point{9, 8}, Max: orb.Point{10, 9}}, orb.Polygon{square}, false},
+		{"touches corner", orb.Bound{Min: orb.Point{9, 9}, Max: orb.Point{10, 10}}, orb.Polygon{square}, false},
+		{"same as polygon", orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}, orb.Polygon{square}, false},
+		{"contains polygon", orb.Bound{Min: orb.Point{-1, -1}, Max: orb.Point{11, 11}}, orb.Polygon{square}, false},
+		{"away from hole", orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}, orb.Polygon{square, hole}, true},
+		{"inside hole", orb.Bound{Min: orb.Point{4.5, 4.5}, Max: orb.Point{5.5, 5.5}}, orb.Polygon{square, hole}, false},
+		{"overlaps hole", orb.Bound{Min: orb.Point{3, 3}, Max: orb.Point{5, 5}}, orb.Polygon{square, hole}, false},
+		{"touches hole", orb.Bound{Min: orb.Point{3, 3}, Max: orb.Point{4, 4}}, orb.Polygon{square, hole}, false},
+		{"contains hole", orb.Bound{Min: orb.Point{3, 3}, Max: orb.Point{7, 7}}, orb.Polygon{square, hole}, false},
+		{"between arms", orb.Bound{Min: orb.Point{2, 8}, Max: orb.Point{8, 9}}, u, false},
+		{"inside arm", orb.Bound{Min: orb.Point{1, 8}, Max: orb.Point{2, 9}}, u, true},
+		{"east of antimeridian", orb.Bound{Min: orb.Point{179.5, -18}, Max: orb.Point{179.9, -17.5}}, fiji, true},
+		{"west of antimeridian", orb.Bound{Min: orb.Point{-179.9, -18}, Max: orb.Point{-179.5, -17.5}}, fiji, true},
+		// Cells which touch the antimeridian touch the edge of one of the polygons so are (conservatively) not contained
+		{"touches antimeridian", orb.Bound{Min: orb.Point{179.9, -18}, Max: orb.Point{180, -17.5}}, fiji, false},
+		{"between polygons", orb.Bound{Min: orb.Point{-178, -18}, Max: orb.Point{-177.5, -17.5}}, fiji, false},
+		{"multipolygon", orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}, orb.MultiPolygon{{hole}, {square}}, true},
+		{"empty polygon", orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}, orb.Polygon{}, false},
+		{"point", orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{1, 1}}, orb.Point{1, 1}, false},
+	}
+
+	for _, test := range tests {
+
+		if BoundWithin(test.bound, test.geom) != test.expected {
+			t.Fatalf("%s: expected %t", test.name, test.expected)
+		}
+	}
+}
+
+func TestBoundWithinGeohashCells(t *testing.T) {
+
+	poly := orb.Polygon{{{-122.5, 37.7}, {-122.35, 37.7}, {-122.35, 37.83}, {-122.5, 37.83}, {-122.5, 37.7}}}
+
+	tests := []struct {
+		lat      float64
+		lon      float64
+		expected bool
+	}{
+		// The middle of the polygon
+		{37.7749, -122.4194, true},
+		// Cells which straddle the polygon's edges
+		{37.7001, -122.4194, false},
+		{37.7749, -122.3501, false},
+		// Outside the polygon
+		{37.69, -122.4194, false},
+	}
+
+	for _, test := range tests {
+
+		cell := EncodeGeohash(test.lat, test.lon, 6)
+
+		b, err := DecodeGeohash(cell)
+
+		if err != nil {
+			t.Fatalf("Failed to decode %s, %v", cell, err)
+		}
+
+		if BoundWithin(b, poly) != test.expected {
+			t.Fatalf("Expected cell %s for %f,%f to be within polygon: %t", cell, test.lat, test.lon, test.expected)
+		}
+	}
+}
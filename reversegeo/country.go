@@ -0,0 +1,153 @@
+package reversegeo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+)
+
+const (
+	// COUNTRY_AGREE signals that the country of a place matches one of its parent's country ancestors.
+	COUNTRY_AGREE string = "agree"
+	// COUNTRY_DISAGREE signals that the country of a place does not match any of its parent's country ancestors.
+	COUNTRY_DISAGREE string = "disagree"
+	// COUNTRY_UNKNOWN signals that a place has no country or that its parent has no (determinate) country ancestors.
+	COUNTRY_UNKNOWN string = "unknown"
+	// COUNTRY_ERROR signals that the country ancestors of a place's parent could not be read.
+	COUNTRY_ERROR string = "error"
+)
+
+// checkCountry compares the country of 'pl' with the `wof:country` property of the country ancestors of the
+// parent in 'rsp', updating its `Countries` and `CountryAgreement` properties. If the resolver was created with
+// the `RejectCountryMismatches` option then parents which disagree will be discarded. This check is advisory so
+// if the country ancestors can not be read the `CountryAgreement` property is set to `COUNTRY_ERROR` and the
+// parent is left as-is.
+func (r *Resolver) checkCountry(ctx context.Context, pl *places.Place, rsp *Result) {
+
+	rsp.Countries = make([]string, 0)
+
+	if rsp.ParentId < 0 {
+		return
+	}
+
+	for _, h := range rsp.Hierarchies {
+
+		country_id, exists := h["country_id"]
+
+		if !exists || country_id < 0 {
+			continue
+		}
+
+		code, err := r.countryCode(ctx, country_id)
+
+		if err != nil {
+			slog.Warn("Failed to derive country code", "id", pl.Id, "country id", country_id, "error", err)
+			rsp.CountryAgreement = COUNTRY_ERROR
+			return
+		}
+
+		if !slices.Contains(rsp.Countries, code) {
+			rsp.Countries = append(rsp.Countries, code)
+		}
+	}
+
+	rsp.CountryAgreement = compareCountries(pl.Country, rsp.Countries)
+
+	if rsp.CountryAgreement == COUNTRY_DISAGREE && r.reject_mismatches {
+
+		slog.Debug("Reject parent with mismatched country", "id", pl.Id, "parent id", rsp.ParentId, "country", pl.Country, "countries", rsp.Countries)
+
+		rsp.Alternates = append([]int64{rsp.ParentId}, rsp.Alternates...)
+		rsp.ParentId = -1
+		rsp.BelongsTo = make([]int64, 0)
+		rsp.Hierarchies = make([]map[string]int64, 0)
+		rsp.BoundaryDistance = -1.0
+		rsp.Reason = REASON_COUNTRY_MISMATCH
+	}
+}
+
+// countryCode returns the `wof:country` property for 'country_id', as read from the properties reader. Results
+// are cached for the lifetime of the resolver since there are only a few hundred countries.
+func (r *Resolver) countryCode(ctx context.Context, country_id int64) (string, error) {
+
+	v, exists := r.countries.Load(country_id)
+
+	if exists {
+		return v.(string), nil
+	}
+
+	body, err := loadBytes(ctx, r.properties_reader, country_id, "properties_reader")
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read country %d, %w", country_id, err)
+	}
+
+	code := properties.Country(body)
+	r.countries.Store(country_id, code)
+
+	return code, nil
+}
+
+// compareCountries compares the (ISO 3166-1 alpha-2) country code 'country' with 'codes' returning one
+// of the COUNTRY_ constants. Who's On First codes for disputed, undetermined or "complicated" places are
+// not considered.
+func compareCountries(country string, codes []string) string {
+
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	if country == "" {
+		return COUNTRY_UNKNOWN
+	}
+
+	determinate := 0
+
+	for _, code := range codes {
+
+		switch code {
+		case properties.COUNTRY_DISPUTED, properties.COUNTRY_UNKNOWN, properties.COUNTRY_COMPLICATED, "":
+			continue
+		}
+
+		determinate += 1
+
+		if strings.ToUpper(code) == country {
+			return COUNTRY_AGREE
+		}
+	}
+
+	if determinate == 0 {
+		return COUNTRY_UNKNOWN
+	}
+
+	return COUNTRY_DISAGREE
+}
+
+// CountryMismatchRow returns a dictionary of strings, suitable for writing as CSV data, describing the disagreement
+// between the country of 'pl' and the country ancestors of the parent in 'rsp'. If the parent was discarded (see
+// `ResolverOptions.RejectCountryMismatches`) the "wof:parent_id" value will be the ID of the discarded parent.
+func CountryMismatchRow(pl *places.Place, rsp *Result) map[string]string {
+
+	parent_id := rsp.ParentId
+
+	if rsp.Reason == REASON_COUNTRY_MISMATCH && len(rsp.Alternates) > 0 {
+		parent_id = rsp.Alternates[0]
+	}
+
+	row := map[string]string{
+		"4sq:id":               pl.Id,
+		"4sq:name":             pl.Name,
+		"4sq:country":          pl.Country,
+		"4sq:latitude":         strconv.FormatFloat(pl.Latitude, 'f', -1, 64),
+		"4sq:longitude":        strconv.FormatFloat(pl.Longitude, 'f', -1, 64),
+		"wof:parent_id":        strconv.FormatInt(parent_id, 10),
+		"reversegeo:countries": strings.Join(rsp.Countries, ","),
+	}
+
+	return row
+}
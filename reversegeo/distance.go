@@ -0,0 +1,63 @@
+package reversegeo
+
+import (
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// BoundaryDistance returns the distance, in metres, from 'pt' to the nearest edge (including holes) of 'geom'
+// which is expected to be a `orb.Polygon` or a `orb.MultiPolygon`. The nearest point on each edge is derived
+// in planar (longitude, latitude) space and the distance to it is calculated using the haversine formula so the
+// result should be understood as a close approximation. Returns -1 if 'geom' has no edges.
+func BoundaryDistance(pt orb.Point, geom orb.Geometry) float64 {
+
+	rings := make([]orb.Ring, 0)
+
+	switch g := geom.(type) {
+	case orb.Polygon:
+		rings = append(rings, g...)
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+			rings = append(rings, poly...)
+		}
+	}
+
+	distance := math.MaxFloat64
+
+	for _, ring := range rings {
+
+		for i := 0; i < len(ring)-1; i++ {
+
+			nearest := nearestPointOnSegment(ring[i], ring[i+1], pt)
+			d := geo.DistanceHaversine(pt, nearest)
+
+			if d < distance {
+				distance = d
+			}
+		}
+	}
+
+	if distance == math.MaxFloat64 {
+		return -1.0
+	}
+
+	return distance
+}
+
+func nearestPointOnSegment(a orb.Point, b orb.Point, pt orb.Point) orb.Point {
+
+	dx := b.X() - a.X()
+	dy := b.Y() - a.Y()
+
+	if dx == 0 && dy == 0 {
+		return a
+	}
+
+	t := ((pt.X()-a.X())*dx + (pt.Y()-a.Y())*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+
+	return orb.Point{a.X() + t*dx, a.Y() + t*dy}
+}
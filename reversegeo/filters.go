@@ -0,0 +1,167 @@
+package reversegeo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	hierarchy_filter "github.com/whosonfirst/go-whosonfirst-spatial/hierarchy/filter"
+)
+
+const (
+	// FIRST_BUT_FORGIVING_RESULTS selects the first point-in-polygon result, if present.
+	FIRST_BUT_FORGIVING_RESULTS string = "first-but-forgiving"
+	// FIRST_RESULTS selects the first point-in-polygon result or fails if there are no results.
+	FIRST_RESULTS string = "first"
+	// SINGLE_RESULTS selects the only point-in-polygon result or fails if there is not exactly one result.
+	SINGLE_RESULTS string = "single"
+	// SMALLEST_AREA_RESULTS selects the point-in-polygon result with the smallest area, if present.
+	SMALLEST_AREA_RESULTS string = "smallest-area"
+)
+
+var results_callbacks = map[string]hierarchy_filter.FilterSPRResultsFunc{
+	FIRST_BUT_FORGIVING_RESULTS: hierarchy_filter.FirstButForgivingSPRResultsFunc,
+	FIRST_RESULTS:               hierarchy_filter.FirstSPRResultsFunc,
+	SINGLE_RESULTS:              hierarchy_filter.SingleSPRResultsFunc,
+	SMALLEST_AREA_RESULTS:       SmallestAreaSPRResultsFunc,
+}
+
+// FilterOptions defines the criteria used to filter point-in-polygon results and to select a parent from those results.
+// It is a JSON-encodable wrapper around `filter.SPRInputs` and the name of a results callback.
+type FilterOptions struct {
+	// Zero or more placetypes to filter point-in-polygon results by. If empty then the list of ancestors for the
+	// "venue" placetype will be queried, in order, stopping at the first placetype with results.
+	Placetypes []string `json:"placetypes,omitempty"`
+	// Zero or more existential flags (-1, 0, 1) to filter results by their "is current" status.
+	IsCurrent []int64 `json:"is_current,omitempty"`
+	// Zero or more existential flags (-1, 0, 1) to filter results by their "is ceased" status.
+	IsCeased []int64 `json:"is_ceased,omitempty"`
+	// Zero or more existential flags (-1, 0, 1) to filter results by their "is deprecated" status.
+	IsDeprecated []int64 `json:"is_deprecated,omitempty"`
+	// Zero or more existential flags (-1, 0, 1) to filter results by their "is superseded" status.
+	IsSuperseded []int64 `json:"is_superseded,omitempty"`
+	// Zero or more existential flags (-1, 0, 1) to filter results by their "is superseding" status.
+	IsSuperseding []int64 `json:"is_superseding,omitempty"`
+	// Which geometries to query. Valid options are: all, alternate, default.
+	Geometries string `json:"geometries,omitempty"`
+	// Zero or more alternate geometry labels to filter results by.
+	AlternateGeometries []string `json:"alternate_geometries,omitempty"`
+	// An EDTF date string to filter results by their inception date.
+	InceptionDate string `json:"inception_date,omitempty"`
+	// An EDTF date string to filter results by their cessation date.
+	CessationDate string `json:"cessation_date,omitempty"`
+	// The name of the results callback used to select a parent. See `ResultsCallbacks` for valid options.
+	ResultsCallback string `json:"results_callback,omitempty"`
+}
+
+// DefaultFilterOptions returns a `FilterOptions` instance which only considers current records and selects
+// the first result.
+func DefaultFilterOptions() *FilterOptions {
+
+	opts := &FilterOptions{
+		IsCurrent:       []int64{1},
+		ResultsCallback: FIRST_BUT_FORGIVING_RESULTS,
+	}
+
+	return opts
+}
+
+// ReadFilterOptions reads a JSON-encoded `FilterOptions` instance from 'path'. Properties missing from
+// 'path' will be assigned their default values (see `DefaultFilterOptions`).
+func ReadFilterOptions(path string) (*FilterOptions, error) {
+
+	r, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer r.Close()
+
+	opts := DefaultFilterOptions()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	return opts, nil
+}
+
+// SPRInputs returns a new `filter.SPRInputs` instance derived from 'opts'.
+func (opts *FilterOptions) SPRInputs() (*filter.SPRInputs, error) {
+
+	inputs, err := filter.NewSPRInputs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	inputs.Placetypes = opts.Placetypes
+	inputs.IsCurrent = opts.IsCurrent
+	inputs.IsCeased = opts.IsCeased
+	inputs.IsDeprecated = opts.IsDeprecated
+	inputs.IsSuperseded = opts.IsSuperseded
+	inputs.IsSuperseding = opts.IsSuperseding
+	inputs.AlternateGeometries = opts.AlternateGeometries
+	inputs.InceptionDate = opts.InceptionDate
+	inputs.CessationDate = opts.CessationDate
+
+	if opts.Geometries != "" {
+		inputs.Geometries = []string{opts.Geometries}
+	}
+
+	// Make sure the inputs are valid now rather than when we are performing
+	// point-in-polygon operations
+
+	_, err = filter.NewSPRFilterFromInputs(inputs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter options, %w", err)
+	}
+
+	return inputs, nil
+}
+
+// ResultsCallbackFunc returns the results callback function named by 'opts'.
+func (opts *FilterOptions) ResultsCallbackFunc() (hierarchy_filter.FilterSPRResultsFunc, error) {
+
+	name := opts.ResultsCallback
+
+	if name == "" {
+		name = FIRST_BUT_FORGIVING_RESULTS
+	}
+
+	return NewResultsCallback(name)
+}
+
+// NewResultsCallback returns the results callback function registered for 'name'.
+func NewResultsCallback(name string) (hierarchy_filter.FilterSPRResultsFunc, error) {
+
+	cb, exists := results_callbacks[name]
+
+	if !exists {
+		return nil, fmt.Errorf("Invalid results callback '%s'", name)
+	}
+
+	return cb, nil
+}
+
+// ResultsCallbacks returns the names of the valid results callback functions.
+func ResultsCallbacks() []string {
+
+	names := make([]string, 0)
+
+	for k, _ := range results_callbacks {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,405 @@
+package reversegeo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-foursquare-places/cache"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+// ResolverFlags defines command line flags for configuring a `Resolver` instance and the resources (spatial
+// database, properties reader and caches) it depends on.
+type ResolverFlags struct {
+	filter_flags              *FilterFlags
+	spatial_database_uri      string
+	properties_reader_uri     string
+	parent_cache_uri          string
+	cell_cache_precision      int
+	cell_cache_path           string
+	cell_cache_rtree_uri      string
+	with_metadata             bool
+	check_country             bool
+	reject_country_mismatches bool
+	check_names               bool
+	spatial_db                database.SpatialDatabase
+	parent_cache              cache.ParentCache
+	cell_cache                *CellCache
+	cell_db                   *sql.DB
+}
+
+// AppendResolverFlags appends flags for configuring a `Resolver` instance, including those defined by `AppendFilterFlags`, to 'fs'.
+func AppendResolverFlags(fs *flag.FlagSet) *ResolverFlags {
+
+	rf := &ResolverFlags{}
+
+	fs.StringVar(&rf.spatial_database_uri, "spatial-database-uri", "", "A registered whosonfirst/go-whosonfirst-spatial/database/SpatialDatabase URI to use for perforning reverse geocoding tasks.")
+	fs.StringVar(&rf.properties_reader_uri, "properties-reader-uri", "{spatial-database-uri}", "An optional registered whosonfirst/go-reader.Reader URI used to read the properties (hierarchies) of parent records. If \"{spatial-database-uri}\" then the spatial database will be used.")
+
+	rf.filter_flags = AppendFilterFlags(fs)
+
+	fs.StringVar(&rf.parent_cache_uri, "parent-cache-uri", "ristretto://", "A registered whosonfirst/go-foursquare-places/cache.ParentCache URI used to cache the hierarchies of parent records. Valid schemes are: "+strings.Join(cache.ParentCacheSchemes(), ", "))
+
+	fs.IntVar(&rf.cell_cache_precision, "cell-cache-precision", 0, "If > 0 then memoize point-in-polygon results for geohash cells with this many characters. Results are only reused for cells which are known to lie entirely inside a single parent polygon which is the only candidate intersecting the cell. This requires a sqlite:// spatial database, or a -cell-cache-rtree-uri flag, and is disabled if -with-metadata is enabled.")
+	fs.StringVar(&rf.cell_cache_rtree_uri, "cell-cache-rtree-uri", "", "An optional sqlite:// spatial database URI, in the same form as the -spatial-database-uri flag, for a Who's On First SQLite database whose rtree and spr tables are used to determine which records intersect a cell. It must contain the same records as the spatial database. If empty the -spatial-database-uri flag is used, in which case it must be a sqlite:// URI. This allows the cell cache to be used with other spatial databases, like PMTiles.")
+	fs.StringVar(&rf.cell_cache_path, "cell-cache-path", "", "An optional path to a file used to persist the cell cache between runs. If present it will be read on start up and (re)written when processing is complete. The file records a hash of the -spatial-database-uri and -cell-cache-rtree-uri flags and the filter criteria and is discarded if they change.")
+
+	fs.BoolVar(&rf.with_metadata, "with-metadata", false, "Include the number of point-in-polygon candidates, the IDs of candidates which were not chosen, the distance (in metres) to the parent's boundary and the reason no parent was found in the output. Deriving boundary distances requires reading each parent's geometry from the spatial database.")
+
+	fs.BoolVar(&rf.check_country, "check-country", false, "Compare the country of each place with the wof:country property of its parent's country ancestors and include the results in the output.")
+	fs.BoolVar(&rf.reject_country_mismatches, "reject-country-mismatches", false, "Discard parents whose country ancestors disagree with the country of a place. Implies -check-country.")
+
+	fs.BoolVar(&rf.check_names, "check-names", false, "Compare the locality, post town and region names of each place with the names (including alternate names) of the corresponding ancestors of its parent and include similarity scores in the output.")
+
+	return rf
+}
+
+// SpatialDatabaseURI returns the value of the -spatial-database-uri flag.
+func (rf *ResolverFlags) SpatialDatabaseURI() string {
+	return rf.spatial_database_uri
+}
+
+// RowOptions returns a `RowOptions` instance for the optional output columns enabled by the flags defined by `AppendResolverFlags`.
+func (rf *ResolverFlags) RowOptions() *RowOptions {
+
+	opts := &RowOptions{
+		Metadata: rf.with_metadata,
+		Country:  rf.check_country || rf.reject_country_mismatches,
+		Names:    rf.check_names,
+	}
+
+	return opts
+}
+
+// NewResolver returns a new `Resolver` instance derived from the flags defined by `AppendResolverFlags`. It should
+// only be called after the flag set has been parsed and only once. The spatial database and caches it creates are
+// released by the `Close` method.
+func (rf *ResolverFlags) NewResolver(ctx context.Context) (*Resolver, error) {
+
+	filter_opts, err := rf.filter_flags.FilterOptions()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive filter options, %w", err)
+	}
+
+	inputs, err := filter_opts.SPRInputs()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive SPR inputs, %w", err)
+	}
+
+	results_cb, err := filter_opts.ResultsCallbackFunc()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive results callback, %w", err)
+	}
+
+	spatial_db, err := database.NewSpatialDatabase(ctx, rf.spatial_database_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spatial database, %w", err)
+	}
+
+	rf.spatial_db = spatial_db
+
+	var properties_reader reader.Reader
+	properties_reader = spatial_db
+
+	if rf.properties_reader_uri != "{spatial-database-uri}" {
+
+		r, err := reader.NewReader(ctx, rf.properties_reader_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create properties reader, %w", err)
+		}
+
+		properties_reader = r
+	}
+
+	var cell_intersects IntersectsFunc
+
+	switch {
+	case rf.cell_cache_precision > 0 && rf.with_metadata:
+		slog.Warn("Cell cache is disabled when -with-metadata is enabled")
+	case rf.cell_cache_precision > 0:
+
+		rtree_uri := rf.cell_cache_rtree_uri
+
+		if rtree_uri == "" {
+			rtree_uri = rf.spatial_database_uri
+		}
+
+		u, err := url.Parse(rtree_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse cell cache rtree URI, %w", err)
+		}
+
+		if u.Scheme != "sqlite" {
+			return nil, fmt.Errorf("Cell cache requires a sqlite:// spatial database or a sqlite:// -cell-cache-rtree-uri flag")
+		}
+
+		// This is a separate connection, to the spatial database or a copy of its records, used to
+		// determine which records intersect a cell
+
+		db, err := sql.Open(u.Host, u.Query().Get("dsn"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open spatial database for cell cache, %w", err)
+		}
+
+		rf.cell_db = db
+		cell_intersects = NewSQLiteIntersectsFunc(db)
+
+		fingerprint, err := CellCacheFingerprint(filter_opts, rf.spatial_database_uri, rtree_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive cell cache fingerprint, %w", err)
+		}
+
+		c, err := NewCellCache(rf.cell_cache_precision, fingerprint)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create cell cache, %w", err)
+		}
+
+		if rf.cell_cache_path != "" {
+
+			err := c.Load(rf.cell_cache_path)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load cell cache, %w", err)
+			}
+		}
+
+		rf.cell_cache = c
+	}
+
+	parent_cache, err := cache.NewParentCache(ctx, rf.parent_cache_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create parent cache, %w", err)
+	}
+
+	rf.parent_cache = parent_cache
+
+	resolver_opts := &ResolverOptions{
+		SpatialDatabase:         spatial_db,
+		PropertiesReader:        properties_reader,
+		Inputs:                  inputs,
+		ResultsCallback:         results_cb,
+		ParentCache:             parent_cache,
+		CellCache:               rf.cell_cache,
+		CellIntersects:          cell_intersects,
+		Metadata:                rf.with_metadata,
+		CheckCountry:            rf.check_country,
+		RejectCountryMismatches: rf.reject_country_mismatches,
+		CheckNames:              rf.check_names,
+	}
+
+	return NewResolver(ctx, resolver_opts)
+}
+
+// CellCache returns the `CellCache` instance created by the `NewResolver` method or nil if the cell cache is disabled.
+func (rf *ResolverFlags) CellCache() *CellCache {
+	return rf.cell_cache
+}
+
+// Close saves the cell cache, if a -cell-cache-path flag was defined, and releases the spatial database and parent
+// cache created by the `NewResolver` method.
+func (rf *ResolverFlags) Close(ctx context.Context) error {
+
+	errs := make([]error, 0)
+
+	if rf.cell_cache != nil && rf.cell_cache_path != "" {
+
+		err := rf.cell_cache.Save(rf.cell_cache_path)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to save cell cache, %w", err))
+		} else {
+			slog.Debug("Saved cell cache", "path", rf.cell_cache_path)
+		}
+	}
+
+	if rf.cell_db != nil {
+
+		err := rf.cell_db.Close()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to close cell cache database, %w", err))
+		}
+	}
+
+	if rf.parent_cache != nil {
+
+		err := rf.parent_cache.Close()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to close parent cache, %w", err))
+		}
+	}
+
+	if rf.spatial_db != nil {
+
+		err := rf.spatial_db.Disconnect(ctx)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to disconnect spatial database, %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// FilterFlags defines command line flags for configuring a `FilterOptions` instance.
+type FilterFlags struct {
+	fs                   *flag.FlagSet
+	config               string
+	placetypes           stringList
+	is_current           int64List
+	is_ceased            int64List
+	is_deprecated        int64List
+	is_superseded        int64List
+	is_superseding       int64List
+	geometries           string
+	alternate_geometries stringList
+	inception_date       string
+	cessation_date       string
+	results_callback     string
+}
+
+// AppendFilterFlags appends flags for configuring a `FilterOptions` instance to 'fs'.
+func AppendFilterFlags(fs *flag.FlagSet) *FilterFlags {
+
+	ff := &FilterFlags{
+		fs: fs,
+	}
+
+	fs.StringVar(&ff.config, "filters-config", "", "An optional path to a JSON-encoded file containing point-in-polygon filter options. Any filter flags which are explicitly set will override the values in this file.")
+	fs.Var(&ff.placetypes, "placetype", "A comma-separated list of placetypes to filter point-in-polygon results by. If empty the ancestors of the venue placetype will be queried in order, stopping at the first placetype with results.")
+	fs.Var(&ff.is_current, "is-current", "A comma-separated list of existential flags (-1, 0, 1) to filter point-in-polygon results by their is_current status. (default 1)")
+	fs.Var(&ff.is_ceased, "is-ceased", "A comma-separated list of existential flags (-1, 0, 1) to filter point-in-polygon results by their is_ceased status.")
+	fs.Var(&ff.is_deprecated, "is-deprecated", "A comma-separated list of existential flags (-1, 0, 1) to filter point-in-polygon results by their is_deprecated status.")
+	fs.Var(&ff.is_superseded, "is-superseded", "A comma-separated list of existential flags (-1, 0, 1) to filter point-in-polygon results by their is_superseded status.")
+	fs.Var(&ff.is_superseding, "is-superseding", "A comma-separated list of existential flags (-1, 0, 1) to filter point-in-polygon results by their is_superseding status.")
+	fs.StringVar(&ff.geometries, "geometries", "", "Which geometries to query. Valid options are: all, alternate, default.")
+	fs.Var(&ff.alternate_geometries, "alternate-geometry", "A comma-separated list of alternate geometry labels to filter point-in-polygon results by.")
+	fs.StringVar(&ff.inception_date, "inception-date", "", "An EDTF date string to filter point-in-polygon results by their inception date.")
+	fs.StringVar(&ff.cessation_date, "cessation-date", "", "An EDTF date string to filter point-in-polygon results by their cessation date.")
+	fs.StringVar(&ff.results_callback, "results-callback", FIRST_BUT_FORGIVING_RESULTS, "The name of the function used to select a parent from point-in-polygon results. Valid options are: "+strings.Join(ResultsCallbacks(), ", "))
+
+	return ff
+}
+
+// FilterOptions returns a `FilterOptions` instance derived from the flags defined by `AppendFilterFlags`. It should
+// only be called after the flag set has been parsed.
+func (ff *FilterFlags) FilterOptions() (*FilterOptions, error) {
+
+	opts := DefaultFilterOptions()
+
+	if ff.config != "" {
+
+		o, err := ReadFilterOptions(ff.config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = o
+	}
+
+	ff.fs.Visit(func(fl *flag.Flag) {
+
+		switch fl.Name {
+		case "placetype":
+			opts.Placetypes = ff.placetypes
+		case "is-current":
+			opts.IsCurrent = ff.is_current
+		case "is-ceased":
+			opts.IsCeased = ff.is_ceased
+		case "is-deprecated":
+			opts.IsDeprecated = ff.is_deprecated
+		case "is-superseded":
+			opts.IsSuperseded = ff.is_superseded
+		case "is-superseding":
+			opts.IsSuperseding = ff.is_superseding
+		case "geometries":
+			opts.Geometries = ff.geometries
+		case "alternate-geometry":
+			opts.AlternateGeometries = ff.alternate_geometries
+		case "inception-date":
+			opts.InceptionDate = ff.inception_date
+		case "cessation-date":
+			opts.CessationDate = ff.cessation_date
+		case "results-callback":
+			opts.ResultsCallback = ff.results_callback
+		}
+	})
+
+	return opts, nil
+}
+
+// stringList implements the `flag.Value` interface for comma-separated lists of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+
+	values := make([]string, 0)
+
+	for _, v := range strings.Split(value, ",") {
+
+		v = strings.TrimSpace(v)
+
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	*l = values
+	return nil
+}
+
+// int64List implements the `flag.Value` interface for comma-separated lists of integers.
+type int64List []int64
+
+func (l *int64List) String() string {
+	return JoinIds(*l, ",")
+}
+
+func (l *int64List) Set(value string) error {
+
+	values := make([]int64, 0)
+
+	for _, v := range strings.Split(value, ",") {
+
+		v = strings.TrimSpace(v)
+
+		if v == "" {
+			continue
+		}
+
+		i, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil {
+			return fmt.Errorf("Invalid value '%s', %w", v, err)
+		}
+
+		values = append(values, i)
+	}
+
+	*l = values
+	return nil
+}
@@ -0,0 +1,115 @@
+package reversegeo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+const geohash_alphabet string = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// EncodeGeohash returns the geohash, with 'precision' characters, for the cell containing 'lat' and 'lon'.
+func EncodeGeohash(lat float64, lon float64, precision int) string {
+
+	min_lat, max_lat := -90.0, 90.0
+	min_lon, max_lon := -180.0, 180.0
+
+	var sb strings.Builder
+
+	bit := 0
+	idx := 0
+	even := true
+
+	for sb.Len() < precision {
+
+		if even {
+
+			mid := (min_lon + max_lon) / 2
+
+			if lon >= mid {
+				idx = idx<<1 | 1
+				min_lon = mid
+			} else {
+				idx = idx << 1
+				max_lon = mid
+			}
+
+		} else {
+
+			mid := (min_lat + max_lat) / 2
+
+			if lat >= mid {
+				idx = idx<<1 | 1
+				min_lat = mid
+			} else {
+				idx = idx << 1
+				max_lat = mid
+			}
+		}
+
+		even = !even
+		bit += 1
+
+		if bit == 5 {
+			sb.WriteByte(geohash_alphabet[idx])
+			bit = 0
+			idx = 0
+		}
+	}
+
+	return sb.String()
+}
+
+// DecodeGeohash returns the bounding box for the cell identified by 'hash'.
+func DecodeGeohash(hash string) (orb.Bound, error) {
+
+	min_lat, max_lat := -90.0, 90.0
+	min_lon, max_lon := -180.0, 180.0
+
+	even := true
+
+	for _, r := range hash {
+
+		idx := strings.IndexRune(geohash_alphabet, r)
+
+		if idx == -1 {
+			return orb.Bound{}, fmt.Errorf("Invalid geohash character '%c'", r)
+		}
+
+		for i := 4; i >= 0; i-- {
+
+			bit := (idx >> i) & 1
+
+			if even {
+
+				mid := (min_lon + max_lon) / 2
+
+				if bit == 1 {
+					min_lon = mid
+				} else {
+					max_lon = mid
+				}
+
+			} else {
+
+				mid := (min_lat + max_lat) / 2
+
+				if bit == 1 {
+					min_lat = mid
+				} else {
+					max_lat = mid
+				}
+			}
+
+			even = !even
+		}
+	}
+
+	b := orb.Bound{
+		Min: orb.Point{min_lon, min_lat},
+		Max: orb.Point{max_lon, max_lat},
+	}
+
+	return b, nil
+}
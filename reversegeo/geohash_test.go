@@ -0,0 +1,107 @@
+package reversegeo
+
+import (
+	"testing"
+)
+
+func TestEncodeGeohash(t *testing.T) {
+
+	tests := []struct {
+		lat       float64
+		lon       float64
+		precision int
+		expected  string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{42.605, -5.603, 5, "ezs42"},
+		{37.7749, -122.4194, 7, "9q8yyk8"},
+		{-33.8688, 151.2093, 6, "r3gx2f"},
+		// Cells on either side of the antimeridian, the equator and the prime meridian
+		{0.0, 180.0, 3, "xbp"},
+		{0.0, -180.0, 3, "800"},
+		{-0.0001, -0.0001, 4, "7zzz"},
+		{0.0, 0.0, 4, "s000"},
+		// The poles
+		{90.0, 180.0, 2, "zz"},
+		{-90.0, -180.0, 2, "00"},
+	}
+
+	for _, test := range tests {
+
+		hash := EncodeGeohash(test.lat, test.lon, test.precision)
+
+		if hash != test.expected {
+			t.Fatalf("Unexpected geohash for %f,%f, expected '%s' but got '%s'", test.lat, test.lon, test.expected, hash)
+		}
+	}
+}
+
+func TestDecodeGeohash(t *testing.T) {
+
+	points := [][2]float64{
+		{57.64911, 10.40744},
+		{37.7749, -122.4194},
+		{-33.8688, 151.2093},
+		{-17.7134, 179.9999},
+		{-17.7134, -179.9999},
+		{89.9999, 0.0},
+		{0.0, 0.0},
+	}
+
+	for _, pt := range points {
+
+		for precision := 1; precision <= 12; precision++ {
+
+			hash := EncodeGeohash(pt[0], pt[1], precision)
+
+			b, err := DecodeGeohash(hash)
+
+			if err != nil {
+				t.Fatalf("Failed to decode %s, %v", hash, err)
+			}
+
+			// The cell must contain the point it was derived from
+
+			if pt[1] < b.Min.X() || pt[1] > b.Max.X() || pt[0] < b.Min.Y() || pt[0] > b.Max.Y() {
+				t.Fatalf("Cell %s (%v) does not contain %f,%f", hash, b, pt[0], pt[1])
+			}
+
+			// Every point in the cell, including its corners, must be encoded as the same cell. The
+			// maximum edges belong to the neighbouring cells unless they are the edges of the world.
+
+			corners := [][2]float64{
+				{b.Min.Y(), b.Min.X()},
+				{(b.Min.Y() + b.Max.Y()) / 2, (b.Min.X() + b.Max.X()) / 2},
+			}
+
+			if b.Max.X() == 180.0 && b.Max.Y() == 90.0 {
+				corners = append(corners, [2]float64{b.Max.Y(), b.Max.X()})
+			}
+
+			for _, c := range corners {
+
+				if EncodeGeohash(c[0], c[1], precision) != hash {
+					t.Fatalf("Expected %f,%f to be encoded as %s", c[0], c[1], hash)
+				}
+			}
+
+			// Cells halve in size with each bit so sizes depend only on the precision
+
+			w := b.Max.X() - b.Min.X()
+			h := b.Max.Y() - b.Min.Y()
+
+			lon_bits := (precision*5 + 1) / 2
+			lat_bits := precision * 5 / 2
+
+			if w != 360.0/float64(uint64(1)<<lon_bits) || h != 180.0/float64(uint64(1)<<lat_bits) {
+				t.Fatalf("Unexpected size for cell %s, %f x %f", hash, w, h)
+			}
+		}
+	}
+
+	_, err := DecodeGeohash("9q8a")
+
+	if err == nil {
+		t.Fatalf("Expected invalid geohash to fail")
+	}
+}
@@ -0,0 +1,100 @@
+package reversegeo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+)
+
+// IntersectsFunc returns the IDs of the records in a spatial database whose bounding boxes intersect 'b' and which
+// match the placetypes and existential flags in 'inputs'. It is used to determine whether a cell can be cached.
+// Implementations may ignore any other criteria in 'inputs' since that only means fewer cells are cached.
+type IntersectsFunc func(ctx context.Context, b orb.Bound, inputs *filter.SPRInputs) ([]int64, error)
+
+// intersects_query is the query used to find the records in the "rtree" table of a Who's On First SQLite
+// spatial database whose bounding boxes intersect a bounding box.
+const intersects_query string = `SELECT DISTINCT r.wof_id FROM rtree r JOIN spr s ON s.id = CAST(r.wof_id AS TEXT) WHERE r.min_x <= ? AND r.max_x >= ? AND r.min_y <= ? AND r.max_y >= ?`
+
+// NewSQLiteIntersectsFunc returns an `IntersectsFunc` which queries the "rtree" and "spr" tables of the
+// Who's On First SQLite spatial database (as used by whosonfirst/go-whosonfirst-spatial-sqlite) in 'db'.
+func NewSQLiteIntersectsFunc(db *sql.DB) IntersectsFunc {
+
+	return func(ctx context.Context, b orb.Bound, inputs *filter.SPRInputs) ([]int64, error) {
+
+		q := intersects_query
+		args := []any{b.Max.X(), b.Min.X(), b.Max.Y(), b.Min.Y()}
+
+		if len(inputs.Placetypes) > 0 {
+
+			q = fmt.Sprintf("%s AND s.placetype IN (%s)", q, placeholders(len(inputs.Placetypes)))
+
+			for _, pt := range inputs.Placetypes {
+				args = append(args, pt)
+			}
+		}
+
+		existential := []struct {
+			column string
+			values []int64
+		}{
+			{"is_current", inputs.IsCurrent},
+			{"is_ceased", inputs.IsCeased},
+			{"is_deprecated", inputs.IsDeprecated},
+			{"is_superseded", inputs.IsSuperseded},
+			{"is_superseding", inputs.IsSuperseding},
+		}
+
+		for _, e := range existential {
+
+			if len(e.values) == 0 {
+				continue
+			}
+
+			q = fmt.Sprintf("%s AND s.%s IN (%s)", q, e.column, placeholders(len(e.values)))
+
+			for _, v := range e.values {
+				args = append(args, v)
+			}
+		}
+
+		rows, err := db.QueryContext(ctx, q, args...)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to query intersecting records, %w", err)
+		}
+
+		defer rows.Close()
+
+		ids := make([]int64, 0)
+
+		for rows.Next() {
+
+			var id int64
+
+			err := rows.Scan(&id)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to scan row, %w", err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		err = rows.Err()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to iterate rows, %w", err)
+		}
+
+		return ids, nil
+	}
+}
+
+// placeholders returns a comma-separated list of 'count' SQL placeholders.
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", count), ",")
+}
@@ -0,0 +1,292 @@
+package reversegeo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-names/tags"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// NAME_LOCALITY is the key used to record the similarity between a place's locality and its locality ancestors.
+	NAME_LOCALITY string = "locality"
+	// NAME_POST_TOWN is the key used to record the similarity between a place's post town and its locality ancestors.
+	NAME_POST_TOWN string = "post_town"
+	// NAME_REGION is the key used to record the similarity between a place's region and its region ancestors.
+	NAME_REGION string = "region"
+)
+
+// name_folds maps (lower-case) letters which are not decomposed by Unicode normalization to their ASCII equivalents.
+var name_folds = map[rune]string{
+	'ł': "l",
+	'ø': "o",
+	'đ': "d",
+	'ħ': "h",
+	'ı': "i",
+	'ß': "ss",
+	'æ': "ae",
+	'œ': "oe",
+	'þ': "th",
+}
+
+// name_ancestors maps the name properties of a place to the hierarchy keys of the ancestors they are compared with.
+var name_ancestors = map[string][]string{
+	NAME_LOCALITY:  []string{"locality_id", "localadmin_id", "borough_id"},
+	NAME_POST_TOWN: []string{"locality_id", "localadmin_id"},
+	NAME_REGION:    []string{"region_id", "county_id"},
+}
+
+// checkNames compares the locality, post town and region names of 'pl' with the names (including alternate
+// names in all languages) of the relevant ancestors of the parent in 'rsp', updating its `NameScores` and
+// `NameSimilarity` properties. This check is advisory so ancestors whose names can not be read are skipped and if
+// none of the relevant ancestors can be read the corresponding score is set to -1 and excluded from `NameSimilarity`.
+func (r *Resolver) checkNames(ctx context.Context, pl *places.Place, rsp *Result) {
+
+	rsp.NameScores = make(map[string]float64)
+	rsp.NameSimilarity = -1.0
+
+	if rsp.ParentId < 0 {
+		return
+	}
+
+	values := map[string]string{
+		NAME_LOCALITY:  pl.Locality,
+		NAME_POST_TOWN: pl.PostTown,
+		NAME_REGION:    pl.Region,
+	}
+
+	total := 0.0
+	count := 0
+
+	for key, value := range values {
+
+		value = NormalizeName(value)
+
+		if value == "" {
+			continue
+		}
+
+		ids := make([]int64, 0)
+
+		for _, h := range rsp.Hierarchies {
+
+			for _, k := range name_ancestors[key] {
+
+				id, exists := h[k]
+
+				if exists && id > 0 {
+					ids = append(ids, id)
+				}
+			}
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		// Ancestors whose names can't be read are skipped; the score is only -1 if none of them could be read
+
+		score := -1.0
+
+		for _, id := range ids {
+
+			names, err := r.ancestorNames(ctx, id)
+
+			if err != nil {
+				slog.Warn("Failed to derive ancestor names", "id", pl.Id, "ancestor id", id, "error", err)
+				continue
+			}
+
+			score = max(score, 0.0)
+
+			for _, n := range names {
+				score = max(score, NameSimilarity(value, n))
+			}
+		}
+
+		rsp.NameScores[key] = score
+
+		if score >= 0.0 {
+			total += score
+			count += 1
+		}
+	}
+
+	if count > 0 {
+		rsp.NameSimilarity = total / float64(count)
+	}
+}
+
+// ancestorNames returns the normalized `wof:name` and `name:*` values for 'id', as read from the properties reader.
+// Results are cached for the lifetime of the resolver.
+func (r *Resolver) ancestorNames(ctx context.Context, id int64) ([]string, error) {
+
+	v, exists := r.names.Load(id)
+
+	if exists {
+		return v.([]string), nil
+	}
+
+	body, err := loadBytes(ctx, r.properties_reader, id, "properties_reader")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read ancestor %d, %w", id, err)
+	}
+
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add_name := func(name string) {
+
+		name = NormalizeName(name)
+
+		if name == "" || seen[name] {
+			return
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	wof_name, err := properties.Name(body)
+
+	if err == nil {
+		add_name(wof_name)
+	}
+
+	for label, values := range properties.Names(body) {
+
+		// Labels take the form of {LANGUAGE}_x_{QUALIFIER}, for example "fra_x_preferred". Anything
+		// else is assumed to be a non-name property which happens to start with "name:".
+
+		_, err := tags.NewLangTag(label)
+
+		if err != nil {
+			continue
+		}
+
+		for _, n := range values {
+			add_name(n)
+		}
+	}
+
+	r.names.Store(id, names)
+	return names, nil
+}
+
+// NormalizeName returns a normalized version of 'name' suitable for comparison: Diacritics are removed, letters are
+// lower-cased (and a handful of letters like "ł" are folded to ASCII) and punctuation is replaced by whitespace which is then collapsed.
+// The go-whosonfirst-names package only parses language tags so normalization is done here.
+func NormalizeName(name string) string {
+
+	var b strings.Builder
+
+	for _, r := range norm.NFD.String(name) {
+
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+
+			r = unicode.ToLower(r)
+			fold, exists := name_folds[r]
+
+			if exists {
+				b.WriteString(fold)
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(' ')
+		}
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// NameSimilarity returns a score between 0.0 and 1.0 describing the similarity of the (normalized) names 'a' and 'b'.
+// The score is the greater of the overlap between the words in each name (so that "saint louis" and "st louis city"
+// still partially match) and one minus the edit distance between the names relative to the length of the longer name.
+func NameSimilarity(a string, b string) float64 {
+
+	if a == "" || b == "" {
+		return 0.0
+	}
+
+	if a == b {
+		return 1.0
+	}
+
+	return max(tokenSimilarity(a, b), editSimilarity(a, b))
+}
+
+// tokenSimilarity returns the Sørensen–Dice coefficient for the words in 'a' and 'b'.
+func tokenSimilarity(a string, b string) float64 {
+
+	a_tokens := strings.Fields(a)
+	b_tokens := strings.Fields(b)
+
+	b_counts := make(map[string]int)
+
+	for _, t := range b_tokens {
+		b_counts[t] += 1
+	}
+
+	shared := 0
+
+	for _, t := range a_tokens {
+
+		if b_counts[t] > 0 {
+			b_counts[t] -= 1
+			shared += 1
+		}
+	}
+
+	return float64(2*shared) / float64(len(a_tokens)+len(b_tokens))
+}
+
+// editSimilarity returns one minus the Levenshtein distance between 'a' and 'b' relative to the length of the longer string.
+func editSimilarity(a string, b string) float64 {
+
+	a_runes := []rune(a)
+	b_runes := []rune(b)
+
+	longest := max(len(a_runes), len(b_runes))
+
+	if longest == 0 {
+		return 1.0
+	}
+
+	prev := make([]int, len(b_runes)+1)
+	curr := make([]int, len(b_runes)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a_runes); i++ {
+
+		curr[0] = i
+
+		for j := 1; j <= len(b_runes); j++ {
+
+			cost := 1
+
+			if a_runes[i-1] == b_runes[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return 1.0 - float64(prev[len(b_runes)])/float64(longest)
+}
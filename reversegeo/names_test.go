@@ -0,0 +1,76 @@
+package reversegeo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+func TestNormalizeName(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Montréal", "montreal"},
+		{"Łódź", "lodz"},
+		{"Straße", "strasse"},
+		{"Tromsø", "tromso"},
+		{"  Saint-Louis,   MO ", "saint louis mo"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+
+		v := NormalizeName(test.name)
+
+		if v != test.expected {
+			t.Fatalf("Unexpected normalized name for '%s', expected '%s' but got '%s'", test.name, test.expected, v)
+		}
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+
+	ctx := context.Background()
+
+	records := []testRecord{
+		{101, 1000, orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}},
+	}
+
+	r := testResolver(t, records, nil)
+
+	pl := &places.Place{
+		Locality: "Locality 101",
+		Region:   "Region",
+	}
+
+	// 998 and 999 can't be read from the properties reader
+
+	rsp := &Result{
+		ParentId: 101,
+		Hierarchies: []map[string]int64{
+			{"locality_id": 101, "localadmin_id": 999, "region_id": 998},
+		},
+	}
+
+	r.checkNames(ctx, pl, rsp)
+
+	// Ancestors which can't be read are skipped rather than discarding the scores of those which can
+
+	if rsp.NameScores[NAME_LOCALITY] != 1.0 {
+		t.Fatalf("Expected locality score of 1.0, got %f", rsp.NameScores[NAME_LOCALITY])
+	}
+
+	// Properties none of whose ancestors can be read are -1 and excluded from the mean
+
+	if rsp.NameScores[NAME_REGION] != -1.0 {
+		t.Fatalf("Expected region score of -1.0, got %f", rsp.NameScores[NAME_REGION])
+	}
+
+	if rsp.NameSimilarity != 1.0 {
+		t.Fatalf("Expected name similarity of 1.0, got %f", rsp.NameSimilarity)
+	}
+}
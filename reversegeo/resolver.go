@@ -0,0 +1,663 @@
+// Package reversegeo provides methods for reverse-geocoding Foursquare places against a Who's On First spatial database.
+package reversegeo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/cache"
+	"github.com/whosonfirst/go-foursquare-places/metrics"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-spatial/hierarchy"
+	hierarchy_filter "github.com/whosonfirst/go-whosonfirst-spatial/hierarchy/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ResolverOptions defines configuration options for the `NewResolver` method.
+type ResolverOptions struct {
+	// SpatialDatabase is the `database.SpatialDatabase` instance used to perform point-in-polygon operations.
+	SpatialDatabase database.SpatialDatabase
+	// PropertiesReader is the `reader.Reader` instance used to retrieve the properties (hierarchies) of parent records.
+	// If nil then `SpatialDatabase` will be used.
+	PropertiesReader reader.Reader
+	// Inputs are the `filter.SPRInputs` used to filter point-in-polygon results. If `Inputs.Placetypes` is not
+	// empty then point-in-polygon operations will only be performed against those placetypes. Otherwise the
+	// ancestors of the "venue" placetype will be queried, in order, stopping at the first placetype with results.
+	Inputs *filter.SPRInputs
+	// ResultsCallback is the function used to select a single parent from a list of point-in-polygon results.
+	// If nil then `hierarchy_filter.FirstButForgivingSPRResultsFunc` will be used.
+	ResultsCallback hierarchy_filter.FilterSPRResultsFunc
+	// ParentCache is the `cache.ParentCache` instance used to cache the properties of parent records. If nil
+	// then a new in-memory `cache.RistrettoParentCache` instance will be created.
+	ParentCache cache.ParentCache
+	// CellCache is an optional `CellCache` instance used to memoize point-in-polygon results. It is ignored
+	// if `Metadata` is true since cached results don't record any metadata.
+	CellCache *CellCache
+	// CellIntersects is the `IntersectsFunc` used to determine whether a cell can be cached. It is required if
+	// `CellCache` is not nil.
+	CellIntersects IntersectsFunc
+	// Metadata is an optional flag to derive additional (and more expensive) metadata for results: The distance
+	// from each place to the edge of its parent's geometry and whether unmatched places were excluded by the
+	// filter criteria (rather than having no candidates at all).
+	Metadata bool
+	// CheckCountry is an optional flag to compare the country of each place with the `wof:country` property of
+	// the country ancestors of its parent. See `Result.CountryAgreement` for details.
+	CheckCountry bool
+	// RejectCountryMismatches is an optional flag to discard parents whose country ancestors disagree with the
+	// country of a place. It implies `CheckCountry`.
+	RejectCountryMismatches bool
+	// CheckNames is an optional flag to compare the locality, post town and region names of each place with
+	// the names of the corresponding ancestors of its parent. See `Result.NameSimilarity` for details.
+	CheckNames bool
+}
+
+// Resolver reverse-geocodes Foursquare places.
+type Resolver struct {
+	spatial_db        database.SpatialDatabase
+	properties_reader reader.Reader
+	resolver          *hierarchy.PointInPolygonHierarchyResolver
+	inputs            *filter.SPRInputs
+	results_cb        hierarchy_filter.FilterSPRResultsFunc
+	parent_cache      cache.ParentCache
+	cell_cache        *CellCache
+	cell_intersects   IntersectsFunc
+	cell_placetypes   []string
+	metadata          bool
+	check_country     bool
+	reject_mismatches bool
+	countries         *sync.Map
+	check_names       bool
+	names             *sync.Map
+}
+
+// NewResolver returns a new `Resolver` instance configured by 'opts'.
+func NewResolver(ctx context.Context, opts *ResolverOptions) (*Resolver, error) {
+
+	if opts.SpatialDatabase == nil {
+		return nil, fmt.Errorf("Missing spatial database")
+	}
+
+	inputs := opts.Inputs
+
+	if inputs == nil {
+		inputs = &filter.SPRInputs{}
+	}
+
+	resolver_opts := &hierarchy.PointInPolygonHierarchyResolverOptions{
+		Database:            opts.SpatialDatabase,
+		SkipPlacetypeFilter: len(inputs.Placetypes) > 0,
+	}
+
+	resolver, err := hierarchy.NewPointInPolygonHierarchyResolver(ctx, resolver_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create hierarchy resolver, %w", err)
+	}
+
+	parent_cache := opts.ParentCache
+
+	if parent_cache == nil {
+
+		c, err := cache.NewParentCache(ctx, "ristretto://")
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create parent cache, %w", err)
+		}
+
+		parent_cache = c
+	}
+
+	properties_reader := opts.PropertiesReader
+
+	if properties_reader == nil {
+		properties_reader = opts.SpatialDatabase
+	}
+
+	results_cb := opts.ResultsCallback
+
+	if results_cb == nil {
+		results_cb = hierarchy_filter.FirstButForgivingSPRResultsFunc
+	}
+
+	cell_cache := opts.CellCache
+
+	if opts.Metadata {
+		cell_cache = nil
+	}
+
+	if cell_cache != nil && opts.CellIntersects == nil {
+		return nil, fmt.Errorf("Cell cache requires an intersects function")
+	}
+
+	// The placetypes queried, in order, when no placetypes are specified. These are used to determine
+	// which records might yield a candidate for a cell.
+
+	cell_placetypes := make([]string, 0)
+
+	venue_pt, err := placetypes.GetPlacetypeByName("venue")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive venue placetype, %w", err)
+	}
+
+	for _, pt := range placetypes.AncestorsForRoles(venue_pt, placetypes.AllRoles()) {
+		cell_placetypes = append(cell_placetypes, pt.Name)
+	}
+
+	r := &Resolver{
+		spatial_db:        opts.SpatialDatabase,
+		properties_reader: properties_reader,
+		resolver:          resolver,
+		inputs:            inputs,
+		results_cb:        results_cb,
+		parent_cache:      parent_cache,
+		cell_cache:        cell_cache,
+		cell_intersects:   opts.CellIntersects,
+		cell_placetypes:   cell_placetypes,
+		metadata:          opts.Metadata,
+		check_country:     opts.CheckCountry || opts.RejectCountryMismatches,
+		reject_mismatches: opts.RejectCountryMismatches,
+		countries:         new(sync.Map),
+		check_names:       opts.CheckNames,
+		names:             new(sync.Map),
+	}
+
+	return r, nil
+}
+
+// ResolvePlace reverse-geocodes 'pl' returning a `Result` instance. If no parent can be determined the result's
+// `ParentId` property will be -1 and its `Reason` property will explain why.
+func (r *Resolver) ResolvePlace(ctx context.Context, pl *places.Place) (*Result, error) {
+
+	ctx, span := tracer.Start(ctx, "ResolvePlace", trace.WithAttributes(
+		attribute.String("4sq.id", pl.Id),
+	))
+
+	defer span.End()
+
+	rsp, err := r.resolvePlace(ctx, pl)
+
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	if r.check_country {
+		r.checkCountry(ctx, pl, rsp)
+	}
+
+	if r.check_names {
+		r.checkNames(ctx, pl, rsp)
+	}
+
+	span.SetAttributes(
+		attribute.Int64("wof.parent_id", rsp.ParentId),
+		attribute.Int("candidates", rsp.Candidates),
+		attribute.String("reason", rsp.Reason),
+	)
+
+	return rsp, nil
+}
+
+// resolvePlace performs the point-in-polygon operations, and parent selection, for 'pl'.
+func (r *Resolver) resolvePlace(ctx context.Context, pl *places.Place) (*Result, error) {
+
+	rsp := &Result{
+		Id:               pl.Id,
+		ParentId:         -1,
+		BelongsTo:        make([]int64, 0),
+		Hierarchies:      make([]map[string]int64, 0),
+		Alternates:       make([]int64, 0),
+		BoundaryDistance: -1.0,
+		NameSimilarity:   -1.0,
+	}
+
+	if !validCoordinates(pl.Latitude, pl.Longitude) {
+		rsp.Reason = REASON_INVALID_COORDINATES
+		return rsp, nil
+	}
+
+	pt := orb.Point{pl.Longitude, pl.Latitude}
+
+	var cell string
+	cell_exists := false
+
+	if r.cell_cache != nil {
+
+		cell = r.cell_cache.Cell(pl.Latitude, pl.Longitude)
+		entry, exists := r.cell_cache.Get(cell)
+
+		cell_exists = exists
+
+		if exists && entry.ParentId != UNCACHEABLE_CELL {
+
+			parent, err := r.parent(ctx, entry.ParentId, entry.LastModified, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			// If the parent has been modified since the cell was cached its geometry may have changed
+			// too so resolve the place (and the cell) again.
+
+			if entry.LastModified > 0 && parent.LastModified > 0 && parent.LastModified != entry.LastModified {
+				slog.Debug("Cached cell is stale", "cell", cell, "parent id", entry.ParentId, "cached", entry.LastModified, "lastmodified", parent.LastModified)
+				r.cell_cache.Delete(cell)
+				cell_exists = false
+			} else {
+
+				rsp.setParent(parent)
+
+				// Cells are only cached when they intersect a single candidate.
+				rsp.Candidates = 1
+				return rsp, nil
+			}
+		}
+	}
+
+	body, err := placeFeature(pl.Id, pl.Name, pl.Latitude, pl.Longitude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	possible, err := r.pointInPolygon(ctx, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve PIP, %w", err)
+	}
+
+	rsp.Candidates = len(possible)
+
+	if len(possible) == 0 {
+
+		rsp.Reason = REASON_NO_CANDIDATES
+
+		if r.metadata {
+
+			unfiltered, err := r.pointInPolygonUnfiltered(ctx, body)
+
+			if err != nil {
+				slog.Warn("Failed to resolve unfiltered PIP", "id", pl.Id, "error", err)
+			} else if len(unfiltered) > 0 {
+				rsp.Reason = REASON_FILTERED_OUT
+			}
+		}
+
+		return rsp, nil
+	}
+
+	parent_spr, err := r.resultsCallback(ctx, body, possible)
+
+	if err != nil {
+		slog.Debug("Results callback rejected candidates", "id", pl.Id, "count", len(possible), "error", err)
+		rsp.Reason = REASON_REJECTED
+		rsp.Alternates = resultIds(possible, "")
+		return rsp, nil
+	}
+
+	if parent_spr == nil {
+		rsp.Reason = REASON_NOT_SELECTED
+		rsp.Alternates = resultIds(possible, "")
+		return rsp, nil
+	}
+
+	parent_id, err := strconv.ParseInt(parent_spr.Id(), 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse parent ID '%s', %w", parent_spr.Id(), err)
+	}
+
+	parent, err := r.parent(ctx, parent_id, parent_spr.LastModified(), parent_spr.BelongsTo())
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp.setParent(parent)
+	rsp.Alternates = resultIds(possible, parent_spr.Id())
+
+	if r.metadata {
+
+		geom, err := r.parentGeometry(ctx, parent_id)
+
+		if err != nil {
+			slog.Debug("Failed to derive parent geometry", "id", pl.Id, "parent id", parent_id, "error", err)
+		} else {
+			rsp.BoundaryDistance = BoundaryDistance(pt, geom)
+		}
+	}
+
+	if cell != "" && !cell_exists {
+		r.cacheCell(ctx, cell, parent_id, parent_spr, possible)
+	}
+
+	return rsp, nil
+}
+
+// Candidates returns the point-in-polygon results, after filtering, for 'pl'. These are the records that
+// `ResolvePlace` selects a parent from. Unlike `ResolvePlace` the cell cache is never consulted.
+func (r *Resolver) Candidates(ctx context.Context, pl *places.Place) ([]spr.StandardPlacesResult, error) {
+
+	if !validCoordinates(pl.Latitude, pl.Longitude) {
+		return nil, fmt.Errorf("Invalid coordinates")
+	}
+
+	body, err := placeFeature(pl.Id, pl.Name, pl.Latitude, pl.Longitude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.pointInPolygon(ctx, body)
+}
+
+// PropertiesReader returns the `reader.Reader` instance used to retrieve the properties of parent records.
+func (r *Resolver) PropertiesReader() reader.Reader {
+	return r.properties_reader
+}
+
+// pointInPolygon performs a point-in-polygon operation for 'body'.
+func (r *Resolver) pointInPolygon(ctx context.Context, body []byte) ([]spr.StandardPlacesResult, error) {
+
+	// The hierarchy resolver assigns the placetypes to filter by on the inputs
+	// it is passed so always hand it a copy.
+
+	inputs := *r.inputs
+	return r.tracePointInPolygon(ctx, &inputs, body, true)
+}
+
+// pointInPolygonUnfiltered performs a point-in-polygon operation for 'body' without any of the existential,
+// geometry or date criteria defined by the resolver's inputs.
+func (r *Resolver) pointInPolygonUnfiltered(ctx context.Context, body []byte) ([]spr.StandardPlacesResult, error) {
+
+	inputs := &filter.SPRInputs{
+		Placetypes: r.inputs.Placetypes,
+	}
+
+	return r.tracePointInPolygon(ctx, inputs, body, false)
+}
+
+// tracePointInPolygon performs a point-in-polygon operation for 'body' using 'inputs', recording its duration
+// and a span.
+func (r *Resolver) tracePointInPolygon(ctx context.Context, inputs *filter.SPRInputs, body []byte, filtered bool) ([]spr.StandardPlacesResult, error) {
+
+	ctx, span := tracer.Start(ctx, "PointInPolygon", trace.WithAttributes(
+		attribute.Bool("filtered", filtered),
+	))
+
+	defer span.End()
+
+	t1 := time.Now()
+	results, err := r.resolver.PointInPolygon(ctx, inputs, body)
+
+	metrics.PointInPolygonDuration.Observe(time.Since(t1).Seconds())
+
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("candidates", len(results)))
+	return results, nil
+}
+
+// resultsCallback selects a parent from 'possible' using the resolver's results callback, recording a span.
+func (r *Resolver) resultsCallback(ctx context.Context, body []byte, possible []spr.StandardPlacesResult) (spr.StandardPlacesResult, error) {
+
+	ctx, span := tracer.Start(ctx, "ResultsCallback", trace.WithAttributes(
+		attribute.Int("candidates", len(possible)),
+	))
+
+	defer span.End()
+
+	parent_spr, err := r.results_cb(ctx, r.properties_reader, body, possible)
+
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	if parent_spr != nil {
+		span.SetAttributes(attribute.String("wof.parent_id", parent_spr.Id()))
+	}
+
+	return parent_spr, nil
+}
+
+// parentGeometry returns the geometry for 'parent_id' as read from the spatial database.
+func (r *Resolver) parentGeometry(ctx context.Context, parent_id int64) (orb.Geometry, error) {
+
+	parent_body, err := loadBytes(ctx, r.spatial_db, parent_id, "spatial_database")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read parent from spatial database, %w", err)
+	}
+
+	parent_f, err := geojson.UnmarshalFeature(parent_body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal parent, %w", err)
+	}
+
+	return parent_f.Geometry, nil
+}
+
+// parent returns the `cache.Parent` record for 'parent_id', reading it from the properties reader if necessary.
+// If 'lastmodified' is greater than zero it will be compared against the cached record and stale records will be
+// re-read. If 'belongs_to' is not nil it will be used as the parent's "belongs to" values in the event that the
+// properties reader fails.
+func (r *Resolver) parent(ctx context.Context, parent_id int64, lastmodified int64, belongs_to []int64) (*cache.Parent, error) {
+
+	logger := slog.Default()
+	logger = logger.With("id", parent_id)
+
+	v, err := r.parent_cache.Get(ctx, parent_id)
+
+	switch {
+	case err == nil:
+
+		// Not all spatial databases return last modified times in their results
+
+		if lastmodified <= 0 || lastmodified == v.LastModified {
+			metrics.ObserveCache(metrics.CACHE_PARENT, true)
+			return v, nil
+		}
+
+		logger.Debug("Cached parent is stale", "cached", v.LastModified, "lastmodified", lastmodified)
+
+	case err != cache.ErrCacheMiss:
+		logger.Warn("Failed to read parent from cache", "error", err)
+	}
+
+	metrics.ObserveCache(metrics.CACHE_PARENT, false)
+
+	parent := &cache.Parent{
+		Id:           parent_id,
+		LastModified: -1,
+		BelongsTo:    make([]int64, 0),
+		Hierarchies:  make([]map[string]int64, 0),
+	}
+
+	if belongs_to != nil {
+		parent.BelongsTo = belongs_to
+	}
+
+	parent_body, err := loadBytes(ctx, r.properties_reader, parent_id, "properties_reader")
+
+	if err != nil {
+		// Note that we don't cache this result since we don't actually know anything about the parent record
+		logger.Warn("Failed to derive record from properties reader", "error", err)
+		return parent, nil
+	}
+
+	parent.LastModified = properties.LastModified(parent_body)
+	parent.BelongsTo = properties.BelongsTo(parent_body)
+	parent.Hierarchies = properties.Hierarchies(parent_body)
+
+	err = r.parent_cache.Set(ctx, parent)
+
+	if err != nil {
+		logger.Warn("Failed to cache parent", "error", err)
+	}
+
+	return parent, nil
+}
+
+// cacheCell determines whether 'cell' lies entirely inside the polygon for 'parent_id', and whether that is the only
+// candidate whose bounding box intersects the cell, and records the result in the cell cache.
+func (r *Resolver) cacheCell(ctx context.Context, cell string, parent_id int64, parent_spr spr.StandardPlacesResult, possible []spr.StandardPlacesResult) {
+
+	logger := slog.Default()
+	logger = logger.With("cell", cell)
+	logger = logger.With("parent id", parent_id)
+
+	uncacheable := CellEntry{
+		ParentId:     UNCACHEABLE_CELL,
+		LastModified: -1,
+	}
+
+	if len(possible) != 1 {
+		logger.Debug("Cell has more than one candidate", "count", len(possible))
+		r.cell_cache.Set(cell, uncacheable)
+		return
+	}
+
+	b, err := DecodeGeohash(cell)
+
+	if err != nil {
+		logger.Warn("Failed to decode cell", "error", err)
+		return
+	}
+
+	// Polygons which don't contain the original point may still overlap other parts of the cell so make sure
+	// the parent is the only candidate which intersects the cell. If no placetypes are specified then the
+	// resolver stops at the first placetype with results so include the parent's placetype and any placetypes
+	// queried before it.
+
+	inputs := *r.inputs
+
+	if len(inputs.Placetypes) == 0 {
+
+		pt := parent_spr.Placetype()
+
+		for _, name := range r.cell_placetypes {
+
+			inputs.Placetypes = append(inputs.Placetypes, name)
+
+			if name == pt {
+				break
+			}
+		}
+	}
+
+	ids, err := r.cell_intersects(ctx, b, &inputs)
+
+	if err != nil {
+		// Note that we don't record this as "uncacheable" because it may be a transient error.
+		logger.Warn("Failed to derive candidates intersecting cell", "error", err)
+		return
+	}
+
+	if len(ids) != 1 || ids[0] != parent_id {
+		logger.Debug("Cell intersects more than one candidate", "count", len(ids))
+		r.cell_cache.Set(cell, uncacheable)
+		return
+	}
+
+	parent_geom, err := r.parentGeometry(ctx, parent_id)
+
+	if err != nil {
+		// Note that we don't record this as "uncacheable" because it may be a transient error.
+		logger.Debug("Failed to derive parent geometry", "error", err)
+		return
+	}
+
+	if !BoundWithin(b, parent_geom) {
+		logger.Debug("Cell is not contained by parent")
+		r.cell_cache.Set(cell, uncacheable)
+		return
+	}
+
+	entry := CellEntry{
+		ParentId:     parent_id,
+		LastModified: parent_spr.LastModified(),
+	}
+
+	r.cell_cache.Set(cell, entry)
+}
+
+// validCoordinates returns false if 'lat' and 'lon' are out of range or are both zero (which is what the CSV
+// emitter assigns when it can not parse coordinates).
+func validCoordinates(lat float64, lon float64) bool {
+
+	if lat == 0.0 && lon == 0.0 {
+		return false
+	}
+
+	if math.IsNaN(lat) || math.IsNaN(lon) {
+		return false
+	}
+
+	return lat >= -90.0 && lat <= 90.0 && lon >= -180.0 && lon <= 180.0
+}
+
+// resultIds returns the IDs of the records in 'results', excluding 'skip'.
+func resultIds(results []spr.StandardPlacesResult, skip string) []int64 {
+
+	ids := make([]int64, 0)
+
+	for _, s := range results {
+
+		if s.Id() == skip {
+			continue
+		}
+
+		id, err := strconv.ParseInt(s.Id(), 10, 64)
+
+		if err != nil {
+			slog.Warn("Failed to parse candidate ID", "id", s.Id(), "error", err)
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// placeFeature returns a GeoJSON Feature describing a venue at 'lat' and 'lon' suitable for passing to a
+// `hierarchy.PointInPolygonHierarchyResolver` instance.
+func placeFeature(id string, name string, lat float64, lon float64) ([]byte, error) {
+
+	pt := orb.Point([2]float64{lon, lat})
+	f := geojson.NewFeature(pt)
+
+	f.Properties["wof:id"] = id
+	f.Properties["wof:name"] = name
+	f.Properties["wof:placetype"] = "venue"
+	f.Properties["lbl:latitude"] = lat
+	f.Properties["lbl:longitude"] = lon
+
+	body, err := f.MarshalJSON()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal JSON, %w", err)
+	}
+
+	return body, nil
+}
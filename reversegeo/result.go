@@ -0,0 +1,194 @@
+package reversegeo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-foursquare-places/cache"
+)
+
+// HIERARCHY_KEYS is the ordered list of hierarchy keys used to encode a hierarchy as a colon-separated string.
+var HIERARCHY_KEYS = []string{
+	"microhood_id",
+	"neighbourhood_id",
+	"macrohood_id",
+	"borough_id",
+	"locality_id",
+	"localadmin_id",
+	"county_id",
+	"region_id",
+	"country_id",
+	"continent_id",
+	"empire_id",
+}
+
+const (
+	// REASON_INVALID_COORDINATES signals that a place has missing or invalid coordinates.
+	REASON_INVALID_COORDINATES string = "invalid-coordinates"
+	// REASON_NO_CANDIDATES signals that there were no point-in-polygon results for a place.
+	REASON_NO_CANDIDATES string = "no-candidates"
+	// REASON_FILTERED_OUT signals that there were point-in-polygon results for a place but all of them were excluded by the filter criteria.
+	REASON_FILTERED_OUT string = "filtered-out"
+	// REASON_NOT_SELECTED signals that there were point-in-polygon results for a place but the results callback did not select any of them.
+	REASON_NOT_SELECTED string = "not-selected"
+	// REASON_REJECTED signals that the results callback rejected the point-in-polygon results for a place.
+	REASON_REJECTED string = "rejected"
+	// REASON_COUNTRY_MISMATCH signals that a parent was found but discarded because its country ancestors disagree with the country of a place.
+	REASON_COUNTRY_MISMATCH string = "country-mismatch"
+	// REASON_ERROR signals that an error occurred while reverse-geocoding a place.
+	REASON_ERROR string = "error"
+)
+
+// Result is the result of reverse-geocoding a Foursquare place.
+type Result struct {
+	// The Foursquare place ID.
+	Id string `json:"4sq:id"`
+	// The Who's On First ID of the parent record or -1 if no parent could be determined.
+	ParentId int64 `json:"wof:parent_id"`
+	// The Who's On First IDs of the parent record's ancestors.
+	BelongsTo []int64 `json:"wof:belongs_to"`
+	// The hierarchies of the parent record.
+	Hierarchies []map[string]int64 `json:"wof:hierarchy"`
+	// The number of point-in-polygon candidates the parent was chosen from.
+	Candidates int `json:"reversegeo:candidates"`
+	// The Who's On First IDs of the point-in-polygon candidates that were not chosen.
+	Alternates []int64 `json:"reversegeo:alternates"`
+	// The distance, in metres, from the place to the nearest edge of the parent's geometry or -1 if unknown.
+	BoundaryDistance float64 `json:"reversegeo:boundary_distance"`
+	// The reason no parent could be determined. One of the REASON_ constants or empty if a parent was found.
+	Reason string `json:"reversegeo:reason,omitempty"`
+	// The `wof:country` values of the country ancestors of the parent record.
+	Countries []string `json:"reversegeo:countries,omitempty"`
+	// Whether the country of the place agrees with the parent's country ancestors. One of the COUNTRY_ constants
+	// or empty if no comparison was made.
+	CountryAgreement string `json:"reversegeo:country_agreement,omitempty"`
+	// The similarity (0.0 - 1.0) between each of the locality, post town and region names of the place and
+	// the names of the corresponding ancestors of the parent record, keyed by the NAME_ constants. Scores are -1 if
+	// the names of those ancestors could not be read.
+	NameScores map[string]float64 `json:"reversegeo:name_scores,omitempty"`
+	// The mean of the (non-negative) values in `NameScores` or -1 if no names were compared.
+	NameSimilarity float64 `json:"reversegeo:name_similarity"`
+}
+
+// RowOptions defines which optional columns are included by the `Result.RowWithOptions` method.
+type RowOptions struct {
+	// Include the candidates, alternates, boundary distance and reason columns.
+	Metadata bool
+	// Include the countries and country agreement columns.
+	Country bool
+	// Include the name similarity columns.
+	Names bool
+}
+
+func (r *Result) setParent(parent *cache.Parent) {
+	r.ParentId = parent.Id
+	r.BelongsTo = parent.BelongsTo
+	r.Hierarchies = parent.Hierarchies
+}
+
+// Row returns 'r' encoded as a dictionary of strings suitable for writing as CSV data. The "wof:belongs_to" value
+// is a comma-separated list of IDs. The "wof:hierarchies" value is a comma-separated list of hierarchies each of
+// which is a colon-separated list of IDs in the order defined by `HIERARCHY_KEYS`.
+func (r *Result) Row() map[string]string {
+
+	row := map[string]string{
+		"4sq:id":          r.Id,
+		"wof:parent_id":   strconv.FormatInt(r.ParentId, 10),
+		"wof:belongs_to":  JoinIds(r.BelongsTo, ","),
+		"wof:hierarchies": HierarchiesToString(r.Hierarchies),
+	}
+
+	return row
+}
+
+// RowWithMetadata returns 'r' encoded as a dictionary of strings suitable for writing as CSV data, including the
+// candidates, alternates, boundary distance and reason properties. The "reversegeo:alternates" value is a
+// comma-separated list of IDs. The "reversegeo:boundary_distance" value is rounded to the nearest centimetre.
+func (r *Result) RowWithMetadata() map[string]string {
+
+	opts := &RowOptions{
+		Metadata: true,
+	}
+
+	return r.RowWithOptions(opts)
+}
+
+// RowWithOptions returns 'r' encoded as a dictionary of strings suitable for writing as CSV data, including the
+// optional columns defined by 'opts'. The "reversegeo:countries" value is a comma-separated list of country codes.
+// Name similarity scores are rounded to three decimal places and are empty if no names were compared.
+func (r *Result) RowWithOptions(opts *RowOptions) map[string]string {
+
+	row := r.Row()
+
+	if opts.Metadata {
+		row["reversegeo:candidates"] = strconv.Itoa(r.Candidates)
+		row["reversegeo:alternates"] = JoinIds(r.Alternates, ",")
+		row["reversegeo:boundary_distance"] = fmt.Sprintf("%.2f", r.BoundaryDistance)
+		row["reversegeo:reason"] = r.Reason
+	}
+
+	if opts.Country {
+		row["reversegeo:countries"] = strings.Join(r.Countries, ",")
+		row["reversegeo:country_agreement"] = r.CountryAgreement
+	}
+
+	if opts.Names {
+
+		row["reversegeo:name_similarity"] = fmt.Sprintf("%.3f", r.NameSimilarity)
+
+		for _, k := range []string{NAME_LOCALITY, NAME_POST_TOWN, NAME_REGION} {
+
+			v := ""
+			score, exists := r.NameScores[k]
+
+			if exists {
+				v = fmt.Sprintf("%.3f", score)
+			}
+
+			row[fmt.Sprintf("reversegeo:%s_similarity", k)] = v
+		}
+	}
+
+	return row
+}
+
+// JoinIds returns a string containing 'ids' separated by 'sep'.
+func JoinIds(ids []int64, sep string) string {
+
+	str_ids := make([]string, len(ids))
+
+	for i, id := range ids {
+		str_ids[i] = strconv.FormatInt(id, 10)
+	}
+
+	return strings.Join(str_ids, sep)
+}
+
+// HierarchiesToString encodes 'hierarchies' as a comma-separated list of hierarchies each of which is
+// a colon-separated list of IDs in the order defined by `HIERARCHY_KEYS`.
+func HierarchiesToString(hierarchies []map[string]int64) string {
+
+	str_hier := make([]string, len(hierarchies))
+
+	for i, h := range hierarchies {
+
+		hier_csv := make([]string, len(HIERARCHY_KEYS))
+
+		for j, k := range HIERARCHY_KEYS {
+
+			id, exists := h[k]
+			v := ""
+
+			if exists {
+				v = strconv.FormatInt(id, 10)
+			}
+
+			hier_csv[j] = v
+		}
+
+		str_hier[i] = strings.Join(hier_csv, ":")
+	}
+
+	return strings.Join(str_hier, ",")
+}
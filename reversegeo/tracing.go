@@ -0,0 +1,43 @@
+package reversegeo
+
+import (
+	"context"
+
+	"github.com/whosonfirst/go-reader"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is the OpenTelemetry tracer used to trace reverse-geocoding operations. It does nothing unless a global
+// tracer provider has been configured (see the tracing package).
+var tracer = otel.Tracer("github.com/whosonfirst/go-foursquare-places/reversegeo")
+
+// loadBytes reads the Who's On First record for 'id' from 'r', recording the read as a span labeled with 'source'.
+func loadBytes(ctx context.Context, r reader.Reader, id int64, source string) ([]byte, error) {
+
+	ctx, span := tracer.Start(ctx, "LoadBytes", trace.WithAttributes(
+		attribute.Int64("wof.id", id),
+		attribute.String("source", source),
+	))
+
+	defer span.End()
+
+	body, err := wof_reader.LoadBytes(ctx, r, id)
+
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("size", len(body)))
+	return body, nil
+}
+
+// recordError records 'err' on 'span' and marks it as failed.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
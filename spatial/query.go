@@ -0,0 +1,343 @@
+package spatial
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+	"sort"
+)
+
+// metres_per_degree is the length, in metres, of one degree of latitude.
+const metres_per_degree float64 = EARTH_RADIUS * math.Pi / 180.0
+
+// max_distance is (roughly) the largest possible distance, in metres, between two points.
+const max_distance float64 = EARTH_RADIUS * math.Pi
+
+// QueryOptions defines configuration options for queries.
+type QueryOptions struct {
+	// Categories is an optional list of category IDs. If present only places in at least one of these categories
+	// are returned.
+	Categories []string
+	// Limit is the maximum number of results to return for `Radius` and `BoundingBox` queries. If 0 all the
+	// results are returned.
+	Limit int
+}
+
+// Result is a place returned by a query.
+type Result struct {
+	*Point
+	// The distance, in metres, from the query's point. This is not set for `BoundingBox` queries.
+	Distance float64
+}
+
+// Nearest returns (up to) the 'k' places nearest to 'lat' and 'lon', ordered by distance. If 'max_distance' is
+// greater than 0 only places within that many metres are returned.
+func (idx *Index) Nearest(lat float64, lon float64, k int, max_dist float64, opts *QueryOptions) []*Result {
+
+	if k <= 0 {
+		return []*Result{}
+	}
+
+	filter, ok := idx.categoryFilter(opts)
+
+	if !ok {
+		return []*Result{}
+	}
+
+	if max_dist <= 0 || max_dist > max_distance {
+		max_dist = max_distance
+	}
+
+	// Search ever larger circles until one contains 'k' places or is as large as the maximum distance. The
+	// first circle is the size of a cell.
+
+	radius := min(idx.cell_size*metres_per_degree, max_dist)
+
+	for {
+
+		h := make(candidates, 0, k)
+
+		idx.radius(lat, lon, radius, filter, func(i int, d float64) {
+
+			c := candidate{index: i, distance: d}
+
+			if len(h) < k {
+				heap.Push(&h, c)
+				return
+			}
+
+			if c.less(h[0]) {
+				h[0] = c
+				heap.Fix(&h, 0)
+			}
+		})
+
+		if len(h) == k || radius >= max_dist {
+			return idx.results(h)
+		}
+
+		radius = min(radius*2, max_dist)
+	}
+}
+
+// Radius returns the places within 'radius' metres of 'lat' and 'lon', ordered by distance.
+func (idx *Index) Radius(lat float64, lon float64, radius float64, opts *QueryOptions) []*Result {
+
+	filter, ok := idx.categoryFilter(opts)
+
+	if !ok || radius < 0 {
+		return []*Result{}
+	}
+
+	matches := make(candidates, 0)
+
+	idx.radius(lat, lon, radius, filter, func(i int, d float64) {
+		matches = append(matches, candidate{index: i, distance: d})
+	})
+
+	if opts != nil && opts.Limit > 0 && len(matches) > opts.Limit {
+
+		slices.SortFunc(matches, compareCandidates)
+		matches = matches[:opts.Limit]
+	}
+
+	return idx.results(matches)
+}
+
+// BoundingBox returns the places inside the bounding box defined by 'min_lon', 'min_lat', 'max_lon' and
+// 'max_lat', ordered by cell. Bounding boxes which cross the antimeridian are not supported and, like other
+// invalid bounding boxes, return no results.
+func (idx *Index) BoundingBox(min_lon float64, min_lat float64, max_lon float64, max_lat float64, opts *QueryOptions) []*Result {
+
+	filter, ok := idx.categoryFilter(opts)
+
+	if !ok || min_lon > max_lon || min_lat > max_lat {
+		return []*Result{}
+	}
+
+	limit := 0
+
+	if opts != nil {
+		limit = opts.Limit
+	}
+
+	// Compare fixed-point values so that places on the edges of the box are included
+
+	min_x := int32(math.Round(min_lon * coord_scale))
+	max_x := int32(math.Round(max_lon * coord_scale))
+	min_y := int32(math.Round(min_lat * coord_scale))
+	max_y := int32(math.Round(max_lat * coord_scale))
+
+	results := make([]*Result, 0)
+
+	idx.scan(min_lat, max_lat, min_lon, max_lon, func(i int) bool {
+
+		if idx.lons[i] < min_x || idx.lons[i] > max_x || idx.lats[i] < min_y || idx.lats[i] > max_y {
+			return true
+		}
+
+		if !filter(i) {
+			return true
+		}
+
+		results = append(results, &Result{Point: idx.Point(i)})
+		return limit <= 0 || len(results) < limit
+	})
+
+	return results
+}
+
+// radius calls 'fn' with the position and distance of each place within 'radius' metres of 'lat' and 'lon' which
+// matches 'filter'.
+func (idx *Index) radius(lat float64, lon float64, radius float64, filter func(int) bool, fn func(int, float64)) {
+
+	dy := radius / metres_per_degree
+
+	min_lat := lat - dy
+	max_lat := lat + dy
+
+	// The largest difference in longitude between the centre and the edge of the circle, unless the circle
+	// contains a pole in which case every longitude is searched
+
+	dx := 360.0
+
+	if max_lat < 90.0 && min_lat > -90.0 {
+		dx = math.Asin(math.Sin(radius/EARTH_RADIUS)/math.Cos(lat*math.Pi/180.0)) * 180.0 / math.Pi
+	}
+
+	idx.scan(min_lat, max_lat, lon-dx, lon+dx, func(i int) bool {
+
+		if !filter(i) {
+			return true
+		}
+
+		d := distance(lat, lon, idx.latitude(i), idx.longitude(i))
+
+		if d <= radius {
+			fn(i, d)
+		}
+
+		return true
+	})
+}
+
+// scan calls 'fn' with the position of each place in the cells which intersect the bounding box defined by
+// 'min_lat', 'max_lat', 'min_lon' and 'max_lon' until 'fn' returns false. Longitudes outside of -180 to 180
+// wrap around the antimeridian.
+func (idx *Index) scan(min_lat float64, max_lat float64, min_lon float64, max_lon float64, fn func(int) bool) {
+
+	if len(idx.cells) == 0 {
+		return
+	}
+
+	// Ranges of columns to scan in each row
+
+	type span struct {
+		start int64
+		end   int64
+	}
+
+	spans := make([]span, 0, 2)
+
+	switch {
+	case max_lon-min_lon >= 360.0:
+		spans = append(spans, span{0, idx.columns - 1})
+	case min_lon < -180.0:
+		spans = append(spans, span{idx.column(min_lon + 360.0), idx.columns - 1}, span{0, idx.column(max_lon)})
+	case max_lon > 180.0:
+		spans = append(spans, span{idx.column(min_lon), idx.columns - 1}, span{0, idx.column(max_lon - 360.0)})
+	default:
+		spans = append(spans, span{idx.column(min_lon), idx.column(max_lon)})
+	}
+
+	start_row := max(idx.row(min_lat), idx.min_row)
+	end_row := min(idx.row(max_lat), idx.max_row)
+
+	for r := start_row; r <= end_row; r++ {
+
+		for _, s := range spans {
+
+			start_key := uint64(r*idx.columns + s.start)
+			end_key := uint64(r*idx.columns + s.end)
+
+			j := sort.Search(len(idx.cells), func(j int) bool {
+				return idx.cells[j] >= start_key
+			})
+
+			for ; j < len(idx.cells) && idx.cells[j] <= end_key; j++ {
+
+				for i := idx.offsets[j]; i < idx.offsets[j+1]; i++ {
+
+					if !fn(int(i)) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// categoryFilter returns a function which reports whether the place at a given position matches the categories
+// in 'opts'. It returns false if none of the categories are in the index.
+func (idx *Index) categoryFilter(opts *QueryOptions) (func(int) bool, bool) {
+
+	if opts == nil || len(opts.Categories) == 0 {
+		return func(int) bool { return true }, true
+	}
+
+	refs := make([]uint32, 0, len(opts.Categories))
+
+	for _, c := range opts.Categories {
+
+		ref, exists := idx.category_lookup[c]
+
+		if exists {
+			refs = append(refs, ref)
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, false
+	}
+
+	fn := func(i int) bool {
+
+		for _, ref := range idx.place_categories[idx.category_offsets[i]:idx.category_offsets[i+1]] {
+
+			if slices.Contains(refs, ref) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return fn, true
+}
+
+// results returns the `Result` instances for 'c', ordered by distance.
+func (idx *Index) results(c candidates) []*Result {
+
+	slices.SortFunc(c, compareCandidates)
+
+	results := make([]*Result, len(c))
+
+	for i, m := range c {
+		results[i] = &Result{Point: idx.Point(m.index), Distance: m.distance}
+	}
+
+	return results
+}
+
+// candidate is the position and distance of a place matching a query.
+type candidate struct {
+	index    int
+	distance float64
+}
+
+func (c candidate) less(other candidate) bool {
+	return compareCandidates(c, other) < 0
+}
+
+func compareCandidates(a candidate, b candidate) int {
+
+	switch {
+	case a.distance < b.distance:
+		return -1
+	case a.distance > b.distance:
+		return 1
+	default:
+		return a.index - b.index
+	}
+}
+
+// candidates is a max-heap of `candidate` instances, used to keep the nearest places seen so far.
+type candidates []candidate
+
+func (h candidates) Len() int           { return len(h) }
+func (h candidates) Less(i, j int) bool { return h[j].less(h[i]) }
+func (h candidates) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *candidates) Push(x any) {
+	*h = append(*h, x.(candidate))
+}
+
+func (h *candidates) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// distance returns the great-circle distance, in metres, between two points.
+func distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+
+	phi1 := lat1 * math.Pi / 180.0
+	phi2 := lat2 * math.Pi / 180.0
+	d_phi := (lat2 - lat1) * math.Pi / 180.0
+	d_lambda := (lon2 - lon1) * math.Pi / 180.0
+
+	a := math.Sin(d_phi/2)*math.Sin(d_phi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(d_lambda/2)*math.Sin(d_lambda/2)
+
+	return 2 * EARTH_RADIUS * math.Asin(math.Min(1.0, math.Sqrt(a)))
+}
@@ -0,0 +1,202 @@
+package spatial
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// testIndex returns a new `Index` with places scattered at random around a number of centres, including ones
+// near the antimeridian and the north pole, and a list of those centres.
+func testIndex(t *testing.T) (*Index, [][2]float64) {
+
+	r := rand.New(rand.NewSource(44))
+
+	centres := [][2]float64{
+		{40.7128, -74.0060},
+		{0.0, 0.0},
+		{-17.7134, 179.995},
+		{-17.7134, -179.995},
+		{89.99, 45.0},
+	}
+
+	b := NewBuilder(&IndexOptions{CellSize: 0.05})
+
+	for i := 0; i < 5000; i++ {
+
+		c := centres[i%len(centres)]
+
+		lat := min(max(c[0]+(r.Float64()-0.5)*0.2, -90.0), 90.0)
+		lon := c[1] + (r.Float64()-0.5)*0.2
+
+		switch {
+		case lon > 180.0:
+			lon -= 360.0
+		case lon < -180.0:
+			lon += 360.0
+		}
+
+		id := fmt.Sprintf("place%d", i)
+		category := fmt.Sprintf("category%d", i%3)
+
+		err := b.Add(id, lat, lon, category)
+
+		if err != nil {
+			t.Fatalf("Failed to add %s, %v", id, err)
+		}
+	}
+
+	return b.Build(), centres
+}
+
+// bruteForce returns the IDs of the places in 'idx' within 'radius' metres of 'lat' and 'lon', in category 'category'
+// if not empty, ordered by distance.
+func bruteForce(idx *Index, lat float64, lon float64, radius float64, category string) []string {
+
+	matches := make(candidates, 0)
+
+	for pt := range idx.Points() {
+
+		if category != "" && !slices.Contains(pt.Categories, category) {
+			continue
+		}
+
+		d := distance(lat, lon, pt.Latitude, pt.Longitude)
+
+		if d <= radius {
+			matches = append(matches, candidate{index: pt.Index, distance: d})
+		}
+	}
+
+	slices.SortFunc(matches, compareCandidates)
+
+	ids := make([]string, len(matches))
+
+	for i, m := range matches {
+		ids[i] = idx.Point(m.index).Id
+	}
+
+	return ids
+}
+
+func resultIds(results []*Result) []string {
+
+	ids := make([]string, len(results))
+
+	for i, r := range results {
+		ids[i] = r.Id
+	}
+
+	return ids
+}
+
+func TestRadius(t *testing.T) {
+
+	idx, centres := testIndex(t)
+
+	for _, c := range centres {
+
+		for _, radius := range []float64{0, 500, 2500, 10000, 50000} {
+
+			for _, category := range []string{"", "category1"} {
+
+				name := fmt.Sprintf("%f,%f radius %f category '%s'", c[0], c[1], radius, category)
+
+				opts := &QueryOptions{}
+
+				if category != "" {
+					opts.Categories = []string{category}
+				}
+
+				expected := bruteForce(idx, c[0], c[1], radius, category)
+				results := idx.Radius(c[0], c[1], radius, opts)
+
+				if !slices.Equal(resultIds(results), expected) {
+					t.Fatalf("%s: expected %d results, got %d or results are out of order", name, len(expected), len(results))
+				}
+
+				for i := 1; i < len(results); i++ {
+
+					if results[i].Distance < results[i-1].Distance {
+						t.Fatalf("%s: result %d is closer than the result before it", name, i)
+					}
+				}
+
+				opts.Limit = 10
+
+				results = idx.Radius(c[0], c[1], radius, opts)
+
+				if !slices.Equal(resultIds(results), expected[:min(len(expected), 10)]) {
+					t.Fatalf("%s: limited results do not match the nearest %d places", name, min(len(expected), 10))
+				}
+			}
+		}
+	}
+}
+
+func TestRadiusAntimeridian(t *testing.T) {
+
+	idx, _ := testIndex(t)
+
+	// Places on both sides of the antimeridian are included
+
+	results := idx.Radius(-17.7134, 180.0, 10000, nil)
+
+	east := 0
+	west := 0
+
+	for _, r := range results {
+
+		if r.Longitude > 0 {
+			east += 1
+		} else {
+			west += 1
+		}
+	}
+
+	if east == 0 || west == 0 {
+		t.Fatalf("Expected results on both sides of the antimeridian, got %d east and %d west", east, west)
+	}
+}
+
+func TestRadiusInvalid(t *testing.T) {
+
+	idx, centres := testIndex(t)
+
+	results := idx.Radius(centres[0][0], centres[0][1], -1, nil)
+
+	if len(results) != 0 {
+		t.Fatalf("Expected no results for a negative radius, got %d", len(results))
+	}
+
+	opts := &QueryOptions{
+		Categories: []string{"missing"},
+	}
+
+	results = idx.Radius(centres[0][0], centres[0][1], 10000, opts)
+
+	if len(results) != 0 {
+		t.Fatalf("Expected no results for an unknown category, got %d", len(results))
+	}
+}
+
+func TestNearest(t *testing.T) {
+
+	idx, centres := testIndex(t)
+
+	for _, c := range centres {
+
+		expected := bruteForce(idx, c[0], c[1], max_distance, "category2")
+
+		opts := &QueryOptions{
+			Categories: []string{"category2"},
+		}
+
+		results := idx.Nearest(c[0], c[1], 25, 0, opts)
+
+		if !slices.Equal(resultIds(results), expected[:25]) {
+			t.Fatalf("%f,%f: results do not match the nearest 25 places", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package spatial provides an in-memory spatial index of Foursquare places for nearest-neighbour, radius and
+// bounding box queries within a single process.
+//
+// Places are bucketed in to a fixed grid of cells and stored in columns, sorted by cell, rather than as
+// individual records: coordinates are stored as 32-bit fixed-point integers (about 1cm of precision), IDs
+// are packed in to a single byte slice and categories are stored as references to a shared dictionary. This
+// keeps the cost of indexing tens of millions of places to a few tens of bytes per place.
+package spatial
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"math"
+	"sort"
+
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// DEFAULT_CELL_SIZE is the default size, in decimal degrees, of the cells in the grid used to bucket places.
+// This is about 1km at the equator.
+const DEFAULT_CELL_SIZE float64 = 0.01
+
+// EARTH_RADIUS is the mean radius of the Earth, in metres, used to derive distances.
+const EARTH_RADIUS float64 = 6371008.8
+
+// coord_scale is the factor used to store coordinates as fixed-point integers.
+const coord_scale float64 = 1e7
+
+// IndexOptions defines configuration options for creating a new `Index`.
+type IndexOptions struct {
+	// CellSize is the size, in decimal degrees, of the cells in the grid used to bucket places. Smaller cells
+	// make queries over small areas faster at the cost of more memory. Default is `DEFAULT_CELL_SIZE`.
+	CellSize float64
+}
+
+// Point is a place stored in an `Index`.
+type Point struct {
+	// The position of the place in the index. This is stable for the lifetime of the index and can be passed to
+	// the `Point` method.
+	Index int
+	// The Foursquare ID of the place.
+	Id string
+	// The latitude of the place.
+	Latitude float64
+	// The longitude of the place.
+	Longitude float64
+	// The IDs of the categories of the place.
+	Categories []string
+}
+
+// Index is an immutable in-memory spatial index of places. It is safe for concurrent use.
+type Index struct {
+	cell_size float64
+	columns   int64
+	rows      int64
+	// The (sorted) keys of the cells which contain places and, for each cell, the position of its first place
+	cells   []uint64
+	offsets []uint32
+	// The range of rows containing places
+	min_row int64
+	max_row int64
+	// Columns of place data, sorted by cell
+	lats             []int32
+	lons             []int32
+	id_data          []byte
+	id_offsets       []uint64
+	category_offsets []uint32
+	place_categories []uint32
+	// The order in which each place was added to the builder
+	order []uint32
+	// The dictionary of category IDs
+	category_ids    []string
+	category_lookup map[string]uint32
+}
+
+// Builder accumulates places to create a new `Index`.
+type Builder struct {
+	cell_size        float64
+	lats             []int32
+	lons             []int32
+	id_data          []byte
+	id_offsets       []uint64
+	category_offsets []uint32
+	place_categories []uint32
+	category_ids     []string
+	category_lookup  map[string]uint32
+}
+
+// NewIndex returns a new `Index` instance for the places yielded by 'seq'. Errors yielded by 'seq' and places
+// with invalid coordinates are logged and skipped. If 'ctx' is cancelled the places read so far are not indexed
+// and the context's error is returned.
+func NewIndex(ctx context.Context, seq iter.Seq2[*places.Place, error], opts *IndexOptions) (*Index, error) {
+
+	b := NewBuilder(opts)
+
+	for pl, err := range seq {
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			slog.Warn("Failed to read place", "error", err)
+			continue
+		}
+
+		err = b.AddPlace(pl)
+
+		if err != nil {
+			slog.Warn("Failed to add place", "id", pl.Id, "error", err)
+			continue
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return b.Build(), nil
+}
+
+// NewBuilder returns a new `Builder` instance.
+func NewBuilder(opts *IndexOptions) *Builder {
+
+	cell_size := DEFAULT_CELL_SIZE
+
+	if opts != nil && opts.CellSize > 0 {
+		cell_size = opts.CellSize
+	}
+
+	b := &Builder{
+		cell_size:        cell_size,
+		lats:             make([]int32, 0),
+		lons:             make([]int32, 0),
+		id_data:          make([]byte, 0),
+		id_offsets:       []uint64{0},
+		category_offsets: []uint32{0},
+		place_categories: make([]uint32, 0),
+		category_ids:     make([]string, 0),
+		category_lookup:  make(map[string]uint32),
+	}
+
+	return b
+}
+
+// AddPlace adds 'pl' to the builder.
+func (b *Builder) AddPlace(pl *places.Place) error {
+
+	categories := make([]string, len(pl.Categories))
+
+	for i, c := range pl.Categories {
+		categories[i] = c.Id
+	}
+
+	return b.Add(pl.Id, pl.Latitude, pl.Longitude, categories...)
+}
+
+// Add adds a place with ID 'id', located at 'lat' and 'lon', in zero or more categories to the builder.
+func (b *Builder) Add(id string, lat float64, lon float64, categories ...string) error {
+
+	if lat < -90.0 || lat > 90.0 || lon < -180.0 || lon > 180.0 || math.IsNaN(lat) || math.IsNaN(lon) {
+		return fmt.Errorf("Invalid coordinates")
+	}
+
+	b.lats = append(b.lats, int32(math.Round(lat*coord_scale)))
+	b.lons = append(b.lons, int32(math.Round(lon*coord_scale)))
+
+	b.id_data = append(b.id_data, id...)
+	b.id_offsets = append(b.id_offsets, uint64(len(b.id_data)))
+
+	for _, c := range categories {
+
+		if c == "" {
+			continue
+		}
+
+		ref, exists := b.category_lookup[c]
+
+		if !exists {
+			ref = uint32(len(b.category_ids))
+			b.category_ids = append(b.category_ids, c)
+			b.category_lookup[c] = ref
+		}
+
+		b.place_categories = append(b.place_categories, ref)
+	}
+
+	b.category_offsets = append(b.category_offsets, uint32(len(b.place_categories)))
+	return nil
+}
+
+// Len returns the number of places which have been added to the builder.
+func (b *Builder) Len() int {
+	return len(b.lats)
+}
+
+// Build returns a new `Index` for the places which have been added to the builder. The builder should not be
+// used after calling this method.
+func (b *Builder) Build() *Index {
+
+	idx := &Index{
+		cell_size:       b.cell_size,
+		columns:         int64(math.Ceil(360.0 / b.cell_size)),
+		rows:            int64(math.Ceil(180.0 / b.cell_size)),
+		category_ids:    b.category_ids,
+		category_lookup: b.category_lookup,
+	}
+
+	count := len(b.lats)
+
+	// Sort the places by cell (and then by the order they were added in)
+
+	keys := make([]uint64, count)
+	order := make([]uint32, count)
+
+	for i := 0; i < count; i++ {
+		keys[i] = idx.key(float64(b.lats[i])/coord_scale, float64(b.lons[i])/coord_scale)
+		order[i] = uint32(i)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]] < keys[order[j]]
+	})
+
+	idx.lats = make([]int32, count)
+	idx.lons = make([]int32, count)
+	idx.id_data = make([]byte, 0, len(b.id_data))
+	idx.id_offsets = make([]uint64, 1, count+1)
+	idx.place_categories = make([]uint32, 0, len(b.place_categories))
+	idx.category_offsets = make([]uint32, 1, count+1)
+	idx.cells = make([]uint64, 0)
+	idx.offsets = make([]uint32, 0)
+
+	for i, j := range order {
+
+		k := keys[j]
+
+		if len(idx.cells) == 0 || idx.cells[len(idx.cells)-1] != k {
+			idx.cells = append(idx.cells, k)
+			idx.offsets = append(idx.offsets, uint32(i))
+		}
+
+		idx.lats[i] = b.lats[j]
+		idx.lons[i] = b.lons[j]
+
+		idx.id_data = append(idx.id_data, b.id_data[b.id_offsets[j]:b.id_offsets[j+1]]...)
+		idx.id_offsets = append(idx.id_offsets, uint64(len(idx.id_data)))
+
+		idx.place_categories = append(idx.place_categories, b.place_categories[b.category_offsets[j]:b.category_offsets[j+1]]...)
+		idx.category_offsets = append(idx.category_offsets, uint32(len(idx.place_categories)))
+	}
+
+	idx.offsets = append(idx.offsets, uint32(count))
+	idx.order = order
+
+	if len(idx.cells) > 0 {
+		idx.min_row = int64(idx.cells[0]) / idx.columns
+		idx.max_row = int64(idx.cells[len(idx.cells)-1]) / idx.columns
+	}
+
+	*b = Builder{}
+	return idx
+}
+
+// Len returns the number of places in the index.
+func (idx *Index) Len() int {
+	return len(idx.lats)
+}
+
+// Point returns the place at position 'i' in the index.
+func (idx *Index) Point(i int) *Point {
+
+	categories := make([]string, 0, idx.category_offsets[i+1]-idx.category_offsets[i])
+
+	for _, ref := range idx.place_categories[idx.category_offsets[i]:idx.category_offsets[i+1]] {
+		categories = append(categories, idx.category_ids[ref])
+	}
+
+	pt := &Point{
+		Index:      i,
+		Id:         string(idx.id_data[idx.id_offsets[i]:idx.id_offsets[i+1]]),
+		Latitude:   idx.latitude(i),
+		Longitude:  idx.longitude(i),
+		Categories: categories,
+	}
+
+	return pt
+}
+
+// Added returns the position, in the order they were added to the builder the index was created from, of the place
+// at position 'i' in the index. This can be used to associate places with other data kept in that order.
+func (idx *Index) Added(i int) int {
+	return int(idx.order[i])
+}
+
+// Points returns an iterator yielding every place in the index, ordered by cell.
+func (idx *Index) Points() iter.Seq[*Point] {
+
+	return func(yield func(*Point) bool) {
+
+		for i := 0; i < idx.Len(); i++ {
+
+			if !yield(idx.Point(i)) {
+				return
+			}
+		}
+	}
+}
+
+func (idx *Index) latitude(i int) float64 {
+	return float64(idx.lats[i]) / coord_scale
+}
+
+func (idx *Index) longitude(i int) float64 {
+	return float64(idx.lons[i]) / coord_scale
+}
+
+// column returns the column of the grid containing 'lon'.
+func (idx *Index) column(lon float64) int64 {
+	return min(max(int64(math.Floor((lon+180.0)/idx.cell_size)), 0), idx.columns-1)
+}
+
+// row returns the row of the grid containing 'lat'.
+func (idx *Index) row(lat float64) int64 {
+	return min(max(int64(math.Floor((lat+90.0)/idx.cell_size)), 0), idx.rows-1)
+}
+
+// key returns the key of the cell containing 'lat' and 'lon'. Keys are ordered by row and then by column so
+// that adjacent cells in the same row have consecutive keys.
+func (idx *Index) key(lat float64, lon float64) uint64 {
+	return uint64(idx.row(lat)*idx.columns + idx.column(lon))
+}
@@ -0,0 +1,397 @@
+// Package sqlite provides methods for loading Foursquare places into a SQLite database with a normalized table
+// of places, a join table of categories, an R-tree index of coordinates and an FTS5 full-text index of names
+// and addresses. Databases can be updated incrementally since places are keyed by their Foursquare ID.
+//
+// FTS5 support in the mattn/go-sqlite3 package requires that code be compiled with the "sqlite_fts5" build tag.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// ErrNotFound is returned when there is no place for a given ID.
+var ErrNotFound = errors.New("Place not found")
+
+// CATEGORY_LABEL_SEPARATOR is the string used to join the (hierarchical) labels of a category.
+const CATEGORY_LABEL_SEPARATOR string = " > "
+
+// places_columns are the columns of the places table, other than its rowid, in the order they are read and written.
+var places_columns = []string{
+	"fsq_place_id",
+	"name",
+	"address",
+	"locality",
+	"post_town",
+	"region",
+	"admin_region",
+	"post_code",
+	"po_box",
+	"country",
+	"latitude",
+	"longitude",
+	"tel",
+	"website",
+	"email",
+	"facebook_id",
+	"instagram",
+	"twitter",
+	"date_created",
+	"date_refreshed",
+	"date_closed",
+}
+
+// The R-tree and full-text indices use the rowid of the places table and are kept in sync with it by triggers
+// so that places can be inserted, updated and deleted with ordinary SQL statements.
+
+const database_schema string = `CREATE TABLE IF NOT EXISTS places (
+	fsq_place_id TEXT NOT NULL UNIQUE,
+	name TEXT,
+	address TEXT,
+	locality TEXT,
+	post_town TEXT,
+	region TEXT,
+	admin_region TEXT,
+	post_code TEXT,
+	po_box TEXT,
+	country TEXT,
+	latitude REAL,
+	longitude REAL,
+	tel TEXT,
+	website TEXT,
+	email TEXT,
+	facebook_id TEXT,
+	instagram TEXT,
+	twitter TEXT,
+	date_created TEXT,
+	date_refreshed TEXT,
+	date_closed TEXT
+);
+
+CREATE INDEX IF NOT EXISTS places_by_country ON places (country);
+
+CREATE TABLE IF NOT EXISTS categories (
+	id TEXT PRIMARY KEY,
+	labels TEXT
+);
+
+CREATE TABLE IF NOT EXISTS place_categories (
+	place_id INTEGER NOT NULL,
+	category_id TEXT NOT NULL,
+	PRIMARY KEY (place_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS place_categories_by_category ON place_categories (category_id, place_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS places_rtree USING rtree (
+	id,
+	min_x,
+	max_x,
+	min_y,
+	max_y
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS places_search USING fts5 (
+	name,
+	address,
+	content='places',
+	tokenize='unicode61 remove_diacritics 2',
+	prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS places_after_insert AFTER INSERT ON places BEGIN
+	INSERT INTO places_rtree (id, min_x, max_x, min_y, max_y) VALUES (new.rowid, new.longitude, new.longitude, new.latitude, new.latitude);
+	INSERT INTO places_search (rowid, name, address) VALUES (new.rowid, new.name, new.address);
+END;
+
+CREATE TRIGGER IF NOT EXISTS places_after_update AFTER UPDATE ON places BEGIN
+	UPDATE places_rtree SET min_x = new.longitude, max_x = new.longitude, min_y = new.latitude, max_y = new.latitude WHERE id = new.rowid;
+	INSERT INTO places_search (places_search, rowid, name, address) VALUES ('delete', old.rowid, old.name, old.address);
+	INSERT INTO places_search (rowid, name, address) VALUES (new.rowid, new.name, new.address);
+END;
+
+CREATE TRIGGER IF NOT EXISTS places_after_delete AFTER DELETE ON places BEGIN
+	DELETE FROM places_rtree WHERE id = old.rowid;
+	INSERT INTO places_search (places_search, rowid, name, address) VALUES ('delete', old.rowid, old.name, old.address);
+	DELETE FROM place_categories WHERE place_id = old.rowid;
+END`
+
+// Database is a SQLite database of Foursquare places.
+type Database struct {
+	db *sql.DB
+}
+
+// NewDatabase returns a new `Database` instance for the SQLite database at 'path'. The database (and its
+// tables) will be created if they do not already exist.
+func NewDatabase(ctx context.Context, path string) (*Database, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, database_schema)
+
+	if err != nil {
+
+		db.Close()
+
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			return nil, fmt.Errorf("Failed to create schema, SQLite was compiled without FTS5 support (build with -tags sqlite_fts5), %w", err)
+		}
+
+		return nil, fmt.Errorf("Failed to create schema, %w", err)
+	}
+
+	d := &Database{
+		db: db,
+	}
+
+	return d, nil
+}
+
+// NewReadOnlyDatabase returns a new `Database` instance for an existing SQLite database at 'path' which is opened
+// read-only. The schema is not created (or checked) so methods which write to the database will fail. Since the
+// full-text index is only read by `Search` the "sqlite_fts5" build tag is only required for that method.
+func NewReadOnlyDatabase(ctx context.Context, path string) (*Database, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	// Opening a database is lazy so check that it exists, and is readable, now
+
+	err = db.PingContext(ctx)
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to connect to database, %w", err)
+	}
+
+	d := &Database{
+		db: db,
+	}
+
+	return d, nil
+}
+
+// DB returns the underlying `sql.DB` instance.
+func (d *Database) DB() *sql.DB {
+	return d.db
+}
+
+// GetPlace returns the place with Foursquare ID 'id' or `ErrNotFound`.
+func (d *Database) GetPlace(ctx context.Context, id string) (*places.Place, error) {
+
+	q := fmt.Sprintf("SELECT rowid, %s FROM places WHERE fsq_place_id = ?", strings.Join(places_columns, ", "))
+	row := d.db.QueryRowContext(ctx, q, id)
+
+	rowid, pl, err := scanPlace(row)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query place %s, %w", id, err)
+	}
+
+	categories, err := d.placeCategories(ctx, rowid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pl.Categories = categories
+	return pl, nil
+}
+
+// RemovePlace removes the place with Foursquare ID 'id' from the database. It is not an error if there is no such place.
+func (d *Database) RemovePlace(ctx context.Context, id string) error {
+
+	_, err := d.db.ExecContext(ctx, "DELETE FROM places WHERE fsq_place_id = ?", id)
+
+	if err != nil {
+		return fmt.Errorf("Failed to remove place %s, %w", id, err)
+	}
+
+	return nil
+}
+
+// Count returns the number of places in the database.
+func (d *Database) Count(ctx context.Context) (int64, error) {
+
+	var count int64
+
+	row := d.db.QueryRowContext(ctx, "SELECT COUNT(rowid) FROM places")
+	err := row.Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to count places, %w", err)
+	}
+
+	return count, nil
+}
+
+// Optimize merges the segments of the full-text index and updates the statistics used by the query planner.
+// This is useful after loading a large number of places.
+func (d *Database) Optimize(ctx context.Context) error {
+
+	_, err := d.db.ExecContext(ctx, "INSERT INTO places_search (places_search) VALUES ('optimize')")
+
+	if err != nil {
+		return fmt.Errorf("Failed to optimize full-text index, %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, "ANALYZE")
+
+	if err != nil {
+		return fmt.Errorf("Failed to analyze database, %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the underlying database.
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+func (d *Database) placeCategories(ctx context.Context, rowid int64) ([]places.Category, error) {
+
+	q := "SELECT c.id, c.labels FROM place_categories pc JOIN categories c ON pc.category_id = c.id WHERE pc.place_id = ? ORDER BY c.id"
+	rows, err := d.db.QueryContext(ctx, q, rowid)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query categories for place %d, %w", rowid, err)
+	}
+
+	defer rows.Close()
+
+	categories := make([]places.Category, 0)
+
+	for rows.Next() {
+
+		var id string
+		var labels string
+
+		err := rows.Scan(&id, &labels)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan category for place %d, %w", rowid, err)
+		}
+
+		c := places.Category{
+			Id: id,
+		}
+
+		if labels != "" {
+			c.Labels = strings.Split(labels, CATEGORY_LABEL_SEPARATOR)
+		}
+
+		categories = append(categories, c)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate categories for place %d, %w", rowid, err)
+	}
+
+	return categories, nil
+}
+
+// rowScanner is the interface shared by `sql.Row` and `sql.Rows` for scanning values.
+type rowScanner interface {
+	Scan(...any) error
+}
+
+// scanPlace scans a row of "rowid" followed by `places_columns` returning the rowid and a new `places.Place`
+// instance without categories. Any additional columns are scanned in to 'extra'.
+func scanPlace(row rowScanner, extra ...any) (int64, *places.Place, error) {
+
+	var rowid int64
+	pl := new(places.Place)
+
+	dest := []any{
+		&rowid,
+		&pl.Id,
+		&pl.Name,
+		&pl.Address,
+		&pl.Locality,
+		&pl.PostTown,
+		&pl.Region,
+		&pl.AdminRegion,
+		&pl.PostCode,
+		&pl.PostBox,
+		&pl.Country,
+		&pl.Latitude,
+		&pl.Longitude,
+		&pl.Telephone,
+		&pl.Website,
+		&pl.Email,
+		&pl.FacebookId,
+		&pl.Instagram,
+		&pl.Twitter,
+		&pl.DateCreated,
+		&pl.DateRefreshed,
+		&pl.DateClosed,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return rowid, pl, nil
+}
+
+// placeValues returns the values of 'pl' in the same order as `places_columns`.
+func placeValues(pl *places.Place) []any {
+
+	return []any{
+		pl.Id,
+		pl.Name,
+		pl.Address,
+		pl.Locality,
+		pl.PostTown,
+		pl.Region,
+		pl.AdminRegion,
+		pl.PostCode,
+		pl.PostBox,
+		pl.Country,
+		pl.Latitude,
+		pl.Longitude,
+		pl.Telephone,
+		pl.Website,
+		pl.Email,
+		pl.FacebookId,
+		pl.Instagram,
+		pl.Twitter,
+		pl.DateCreated,
+		pl.DateRefreshed,
+		pl.DateClosed,
+	}
+}
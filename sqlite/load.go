@@ -0,0 +1,250 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"log/slog"
+	"strings"
+
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// DEFAULT_BATCH_SIZE is the default number of places to write in a single database transaction.
+const DEFAULT_BATCH_SIZE int = 10000
+
+// LoadOptions defines configuration options for the `Load` method.
+type LoadOptions struct {
+	// BatchSize is the number of places to write in a single database transaction. Default is `DEFAULT_BATCH_SIZE`.
+	BatchSize int
+	// Progress is an optional function called after each batch of places has been written.
+	Progress func(*LoadStats)
+}
+
+// LoadStats are the statistics for loading places in to a database.
+type LoadStats struct {
+	// The number of places which were not already in the database.
+	Added int64
+	// The number of places which were already in the database and have been updated.
+	Updated int64
+	// The number of places which were already in the database and have not changed.
+	Unchanged int64
+	// The number of errors yielded while reading places.
+	Errors int64
+}
+
+// upsert_query inserts a place or, if a place with the same ID exists and any of its properties have changed,
+// updates it. The rowid is only returned if the place was written.
+var upsert_query = func() string {
+
+	placeholders := make([]string, len(places_columns))
+	updates := make([]string, 0, len(places_columns))
+	changes := make([]string, 0, len(places_columns))
+
+	for i, col := range places_columns {
+
+		placeholders[i] = "?"
+
+		if col == "fsq_place_id" {
+			continue
+		}
+
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", col, col))
+		changes = append(changes, fmt.Sprintf("places.%s IS NOT excluded.%s", col, col))
+	}
+
+	return fmt.Sprintf("INSERT INTO places (%s) VALUES (%s) ON CONFLICT (fsq_place_id) DO UPDATE SET %s WHERE %s RETURNING rowid",
+		strings.Join(places_columns, ", "),
+		strings.Join(placeholders, ", "),
+		strings.Join(updates, ", "),
+		strings.Join(changes, " OR "),
+	)
+}()
+
+// Load adds or updates the places yielded by 'seq' in the database. Places are matched by their Foursquare ID
+// and places which are already in the database but not yielded by 'seq' are left as-is. Errors yielded by 'seq'
+// are logged and counted. If 'ctx' is cancelled the places read so far are written before returning.
+func (d *Database) Load(ctx context.Context, seq iter.Seq2[*places.Place, error], opts *LoadOptions) (*LoadStats, error) {
+
+	batch_size := opts.BatchSize
+
+	if batch_size <= 0 {
+		batch_size = DEFAULT_BATCH_SIZE
+	}
+
+	stats := new(LoadStats)
+	batch := make([]*places.Place, 0, batch_size)
+
+	flush := func() error {
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		// Write the batch even if the context has been cancelled so that nothing which was read is lost
+		err := d.writePlaces(context.WithoutCancel(ctx), batch, stats)
+
+		if err != nil {
+			return err
+		}
+
+		batch = batch[:0]
+
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+
+		return nil
+	}
+
+	for pl, err := range seq {
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			slog.Warn("Failed to read place", "error", err)
+			stats.Errors += 1
+			continue
+		}
+
+		batch = append(batch, pl)
+
+		if len(batch) < batch_size {
+			continue
+		}
+
+		err = flush()
+
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	err := flush()
+
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, ctx.Err()
+}
+
+// AddPlaces adds or updates 'pls' in the database in a single transaction.
+func (d *Database) AddPlaces(ctx context.Context, pls ...*places.Place) (*LoadStats, error) {
+
+	stats := new(LoadStats)
+
+	err := d.writePlaces(ctx, pls, stats)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (d *Database) writePlaces(ctx context.Context, pls []*places.Place, stats *LoadStats) error {
+
+	tx, err := d.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	statements := map[string]string{
+		"exists":         "SELECT rowid FROM places WHERE fsq_place_id = ?",
+		"upsert":         upsert_query,
+		"category":       "INSERT INTO categories (id, labels) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET labels = excluded.labels WHERE excluded.labels != '' AND categories.labels IS NOT excluded.labels",
+		"clear_category": "DELETE FROM place_categories WHERE place_id = ?",
+		"place_category": "INSERT OR IGNORE INTO place_categories (place_id, category_id) VALUES (?, ?)",
+	}
+
+	stmts := make(map[string]*sql.Stmt)
+
+	for k, q := range statements {
+
+		stmt, err := tx.PrepareContext(ctx, q)
+
+		if err != nil {
+			return fmt.Errorf("Failed to prepare %s statement, %w", k, err)
+		}
+
+		defer stmt.Close()
+		stmts[k] = stmt
+	}
+
+	for _, pl := range pls {
+
+		var rowid int64
+		exists := true
+
+		err := stmts["exists"].QueryRowContext(ctx, pl.Id).Scan(&rowid)
+
+		switch {
+		case err == sql.ErrNoRows:
+			exists = false
+		case err != nil:
+			return fmt.Errorf("Failed to query place %s, %w", pl.Id, err)
+		}
+
+		written := true
+
+		err = stmts["upsert"].QueryRowContext(ctx, placeValues(pl)...).Scan(&rowid)
+
+		switch {
+		case err == sql.ErrNoRows:
+			written = false
+		case err != nil:
+			return fmt.Errorf("Failed to write place %s, %w", pl.Id, err)
+		}
+
+		switch {
+		case !exists:
+			stats.Added += 1
+		case written:
+			stats.Updated += 1
+		default:
+			stats.Unchanged += 1
+		}
+
+		// Categories are not part of the places table so always replace them
+
+		_, err = stmts["clear_category"].ExecContext(ctx, rowid)
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove categories for place %s, %w", pl.Id, err)
+		}
+
+		for _, c := range pl.Categories {
+
+			if c.Id == "" {
+				continue
+			}
+
+			_, err := stmts["category"].ExecContext(ctx, c.Id, strings.Join(c.Labels, CATEGORY_LABEL_SEPARATOR))
+
+			if err != nil {
+				return fmt.Errorf("Failed to write category %s for place %s, %w", c.Id, pl.Id, err)
+			}
+
+			_, err = stmts["place_category"].ExecContext(ctx, rowid, c.Id)
+
+			if err != nil {
+				return fmt.Errorf("Failed to write category %s for place %s, %w", c.Id, pl.Id, err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// ErrInvalidQuery is returned (or yielded) when the parameters of a query are not valid.
+var ErrInvalidQuery = errors.New("Invalid query")
+
+// DEFAULT_LIMIT is the default maximum number of results returned by a query.
+const DEFAULT_LIMIT int = 100
+
+// EARTH_RADIUS is the mean radius of the Earth, in metres, used to derive distances.
+const EARTH_RADIUS float64 = 6371008.8
+
+// metres_per_degree is the length, in metres, of one degree of latitude.
+const metres_per_degree float64 = EARTH_RADIUS * math.Pi / 180.0
+
+// QueryOptions defines configuration options for queries.
+type QueryOptions struct {
+	// Limit is the maximum number of results to return. Default is `DEFAULT_LIMIT`.
+	Limit int
+	// Cursor is the value of the `Cursor` property of the last result of a previous (identical) query. If
+	// present the results following that result are returned.
+	Cursor string
+	// Category is an optional category ID to filter results by.
+	Category string
+	// IsCurrent is an optional list of Who's On First existential flag values (0 or 1) to filter results by. Places
+	// are current (1) unless they have a closing date (0).
+	IsCurrent []int64
+}
+
+// QueryResult is a single result of a query.
+type QueryResult struct {
+	// The place matching the query.
+	Place *places.Place
+	// The distance, in metres, from the query's point. This is only set for `Nearby` queries.
+	Distance float64
+	// An opaque cursor which can be passed to the same query to return the results following this one.
+	Cursor string
+}
+
+// cursor is the position of a result in the results of a query. The properties which are set depend on the
+// order of the results.
+type cursor struct {
+	Rowid    int64    `json:"r,omitempty"`
+	Distance *float64 `json:"d,omitempty"`
+	Offset   int      `json:"o,omitempty"`
+}
+
+func (c *cursor) String() string {
+
+	// Marshaling a struct of numbers can't fail
+	enc, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(enc)
+}
+
+func parseCursor(str string) (*cursor, error) {
+
+	c := new(cursor)
+
+	if str == "" {
+		return c, nil
+	}
+
+	enc, err := base64.RawURLEncoding.DecodeString(str)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w, invalid cursor", ErrInvalidQuery)
+	}
+
+	err = json.Unmarshal(enc, c)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w, invalid cursor", ErrInvalidQuery)
+	}
+
+	return c, nil
+}
+
+// Nearby returns an iterator yielding the places within 'radius' metres of 'lat' and 'lon', ordered by distance.
+// Distances are derived using an equirectangular approximation which is accurate enough for the small areas
+// this is meant for. Circles which cross the antimeridian wrap around it.
+func (d *Database) Nearby(ctx context.Context, lat float64, lon float64, radius float64, opts *QueryOptions) iter.Seq2[*QueryResult, error] {
+
+	if radius <= 0 {
+		return queryError(fmt.Errorf("%w, radius must be greater than 0", ErrInvalidQuery))
+	}
+
+	c, err := parseCursor(opts.Cursor)
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	// Distances are measured in degrees of latitude with degrees of longitude scaled to match
+
+	scale := math.Cos(lat * math.Pi / 180.0)
+
+	if scale < 0.0001 {
+		scale = 0.0001
+	}
+
+	dy := radius / metres_per_degree
+	dx := dy / scale
+
+	// The difference in longitude is wrapped around the antimeridian. The centre's longitude, the scale and
+	// the centre's latitude are bound to the numbered parameters ?1, ?2 and ?3 so they can be reused and the
+	// remaining (anonymous) parameters follow them.
+
+	dlon := "(CASE WHEN p.longitude - ?1 > 180.0 THEN p.longitude - ?1 - 360.0 WHEN p.longitude - ?1 < -180.0 THEN p.longitude - ?1 + 360.0 ELSE p.longitude - ?1 END)"
+	d2 := fmt.Sprintf("(%s * ?2) * (%s * ?2) + (p.latitude - ?3) * (p.latitude - ?3)", dlon, dlon)
+
+	// The R-tree stores coordinates as 32-bit floats, rounded outwards, so it is only used to find candidates
+
+	where := []string{
+		"r.max_y >= ? AND r.min_y <= ?",
+		"d2 <= ?",
+	}
+
+	args := []any{
+		lon, scale, lat,
+		lat - dy, lat + dy,
+		dy * dy,
+	}
+
+	min_x := lon - dx
+	max_x := lon + dx
+
+	switch {
+	case dx >= 180.0:
+		// Every longitude
+	case min_x < -180.0:
+		where = append(where, "((r.max_x >= ? AND r.min_x <= ?) OR (r.max_x >= ? AND r.min_x <= ?))")
+		args = append(args, min_x+360.0, 180.0, -180.0, max_x)
+	case max_x > 180.0:
+		where = append(where, "((r.max_x >= ? AND r.min_x <= ?) OR (r.max_x >= ? AND r.min_x <= ?))")
+		args = append(args, min_x, 180.0, -180.0, max_x-360.0)
+	default:
+		where = append(where, "r.max_x >= ? AND r.min_x <= ?")
+		args = append(args, min_x, max_x)
+	}
+
+	if opts.Category != "" {
+		where = append(where, "p.rowid IN (SELECT place_id FROM place_categories WHERE category_id = ?)")
+		args = append(args, opts.Category)
+	}
+
+	where = appendIsCurrent(where, opts)
+
+	if c.Distance != nil {
+		where = append(where, "(d2 > ? OR (d2 = ? AND p.rowid > ?))")
+		args = append(args, *c.Distance, *c.Distance, c.Rowid)
+	}
+
+	q := fmt.Sprintf("SELECT %s, %s AS d2 FROM places_rtree r JOIN places p ON p.rowid = r.id WHERE %s ORDER BY d2 ASC, p.rowid ASC LIMIT ?",
+		selectColumns(), d2, strings.Join(where, " AND "))
+
+	args = append(args, queryLimit(opts))
+
+	return d.query(ctx, q, args, func(rowid int64, dist float64, offset int) *cursor {
+		return &cursor{Rowid: rowid, Distance: &dist}
+	})
+}
+
+// BoundingBox returns an iterator yielding the places inside the bounding box defined by 'min_lon', 'min_lat',
+// 'max_lon' and 'max_lat'.
+func (d *Database) BoundingBox(ctx context.Context, min_lon float64, min_lat float64, max_lon float64, max_lat float64, opts *QueryOptions) iter.Seq2[*QueryResult, error] {
+
+	if min_lon > max_lon || min_lat > max_lat {
+		return queryError(fmt.Errorf("%w, invalid bounding box", ErrInvalidQuery))
+	}
+
+	c, err := parseCursor(opts.Cursor)
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	// See notes in Nearby
+
+	where := []string{
+		"r.max_x >= ? AND r.min_x <= ? AND r.max_y >= ? AND r.min_y <= ?",
+		"p.longitude BETWEEN ? AND ? AND p.latitude BETWEEN ? AND ?",
+		"p.rowid > ?",
+	}
+
+	args := []any{
+		min_lon, max_lon, min_lat, max_lat,
+		min_lon, max_lon, min_lat, max_lat,
+		c.Rowid,
+	}
+
+	if opts.Category != "" {
+		where = append(where, "p.rowid IN (SELECT place_id FROM place_categories WHERE category_id = ?)")
+		args = append(args, opts.Category)
+	}
+
+	where = appendIsCurrent(where, opts)
+
+	q := fmt.Sprintf("SELECT %s, 0 FROM places_rtree r JOIN places p ON p.rowid = r.id WHERE %s ORDER BY p.rowid ASC LIMIT ?",
+		selectColumns(), strings.Join(where, " AND "))
+
+	args = append(args, queryLimit(opts))
+
+	return d.query(ctx, q, args, func(rowid int64, dist float64, offset int) *cursor {
+		return &cursor{Rowid: rowid}
+	})
+}
+
+// Search returns an iterator yielding the places whose name or address match 'terms', ordered by relevance. Every
+// term must match and the last term is treated as a prefix so that partial queries (for example "blue bott") match.
+func (d *Database) Search(ctx context.Context, terms string, opts *QueryOptions) iter.Seq2[*QueryResult, error] {
+
+	match := SearchQuery(terms)
+
+	if match == "" {
+		return queryError(fmt.Errorf("%w, missing search terms", ErrInvalidQuery))
+	}
+
+	c, err := parseCursor(opts.Cursor)
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	where := []string{
+		"places_search MATCH ?",
+	}
+
+	args := []any{
+		match,
+	}
+
+	if opts.Category != "" {
+		where = append(where, "p.rowid IN (SELECT place_id FROM place_categories WHERE category_id = ?)")
+		args = append(args, opts.Category)
+	}
+
+	where = appendIsCurrent(where, opts)
+
+	// Relevance scores aren't stable enough to page through by value so use an offset instead
+
+	q := fmt.Sprintf("SELECT %s, 0 FROM places_search s JOIN places p ON p.rowid = s.rowid WHERE %s ORDER BY s.rank ASC, p.rowid ASC LIMIT ? OFFSET ?",
+		selectColumns(), strings.Join(where, " AND "))
+
+	args = append(args, queryLimit(opts), c.Offset)
+
+	return d.query(ctx, q, args, func(rowid int64, dist float64, offset int) *cursor {
+		return &cursor{Offset: c.Offset + offset + 1}
+	})
+}
+
+// CategoryPlaces returns an iterator yielding the places in the category with ID 'category_id'. The `Category`
+// property of 'opts' is ignored.
+func (d *Database) CategoryPlaces(ctx context.Context, category_id string, opts *QueryOptions) iter.Seq2[*QueryResult, error] {
+
+	c, err := parseCursor(opts.Cursor)
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	where := []string{
+		"pc.category_id = ?",
+		"pc.place_id > ?",
+	}
+
+	args := []any{
+		category_id,
+		c.Rowid,
+	}
+
+	where = appendIsCurrent(where, opts)
+
+	q := fmt.Sprintf("SELECT %s, 0 FROM place_categories pc JOIN places p ON p.rowid = pc.place_id WHERE %s ORDER BY pc.place_id ASC LIMIT ?",
+		selectColumns(), strings.Join(where, " AND "))
+
+	args = append(args, queryLimit(opts))
+
+	return d.query(ctx, q, args, func(rowid int64, dist float64, offset int) *cursor {
+		return &cursor{Rowid: rowid}
+	})
+}
+
+// SearchQuery returns an FTS5 query string for 'terms' in which every term is quoted, so that punctuation
+// and FTS5 operators are treated as text, and the last term is a prefix query.
+func SearchQuery(terms string) string {
+
+	tokens := strings.FieldsFunc(terms, func(r rune) bool {
+		return unicode.IsSpace(r)
+	})
+
+	quoted := make([]string, 0, len(tokens))
+
+	for _, t := range tokens {
+		quoted = append(quoted, `"`+strings.ReplaceAll(t, `"`, `""`)+`"`)
+	}
+
+	if len(quoted) == 0 {
+		return ""
+	}
+
+	quoted[len(quoted)-1] = quoted[len(quoted)-1] + "*"
+
+	return strings.Join(quoted, " ")
+}
+
+// query executes 'q' whose rows are "rowid", followed by `places_columns` and a (squared) distance, yielding a
+// `QueryResult` for each row. 'cursor_func' derives the cursor for a row from its rowid, distance and position.
+func (d *Database) query(ctx context.Context, q string, args []any, cursor_func func(int64, float64, int) *cursor) iter.Seq2[*QueryResult, error] {
+
+	return func(yield func(*QueryResult, error) bool) {
+
+		// Categories are looked up using a separate connection from the pool while the query's rows
+		// are being read
+
+		rows, err := d.db.QueryContext(ctx, q, args...)
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to execute query, %w", err))
+			return
+		}
+
+		defer rows.Close()
+
+		i := 0
+
+		for rows.Next() {
+
+			var d2 float64
+
+			rowid, pl, err := scanPlace(rows, &d2)
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to scan row, %w", err))
+				return
+			}
+
+			categories, err := d.placeCategories(ctx, rowid)
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			pl.Categories = categories
+
+			r := &QueryResult{
+				Place:    pl,
+				Distance: math.Sqrt(d2) * metres_per_degree,
+				Cursor:   cursor_func(rowid, d2, i).String(),
+			}
+
+			if !yield(r, nil) {
+				return
+			}
+
+			i += 1
+		}
+
+		err = rows.Err()
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to iterate rows, %w", err))
+			return
+		}
+	}
+}
+
+// appendIsCurrent appends the condition for the `IsCurrent` property of 'opts', if necessary, to 'where'.
+func appendIsCurrent(where []string, opts *QueryOptions) []string {
+
+	if len(opts.IsCurrent) == 0 {
+		return where
+	}
+
+	current := slices.Contains(opts.IsCurrent, 1)
+	closed := slices.Contains(opts.IsCurrent, 0)
+
+	switch {
+	case current && closed:
+		return where
+	case current:
+		return append(where, "COALESCE(p.date_closed, '') = ''")
+	case closed:
+		return append(where, "COALESCE(p.date_closed, '') != ''")
+	default:
+		return append(where, "0")
+	}
+}
+
+func queryError(err error) iter.Seq2[*QueryResult, error] {
+
+	return func(yield func(*QueryResult, error) bool) {
+		yield(nil, err)
+	}
+}
+
+func queryLimit(opts *QueryOptions) int {
+
+	if opts.Limit <= 0 {
+		return DEFAULT_LIMIT
+	}
+
+	return opts.Limit
+}
+
+// selectColumns returns the columns of the places table, qualified by the "p" alias, used by `scanPlace`.
+func selectColumns() string {
+
+	cols := make([]string, len(places_columns)+1)
+	cols[0] = "p.rowid"
+
+	for i, col := range places_columns {
+		cols[i+1] = "p." + col
+	}
+
+	return strings.Join(cols, ", ")
+}
@@ -0,0 +1,553 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-foursquare-places/whosonfirst"
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-whosonfirst-flags/date"
+	"github.com/whosonfirst/go-whosonfirst-flags/existential"
+	"github.com/whosonfirst/go-whosonfirst-flags/geometry"
+	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	wof_spatial "github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// DEFAULT_SPATIAL_RADIUS is the default distance, in metres, from a point within which venues are considered
+// to "contain" that point in point-in-polygon queries.
+const DEFAULT_SPATIAL_RADIUS float64 = 25.0
+
+// SpatialDatabase implements the `whosonfirst/go-whosonfirst-spatial/database.SpatialDatabase` interface for
+// the places in a `Database`. Places are indexed as point records so point-in-polygon queries return the
+// places within a fixed distance of a point, ordered by distance.
+type SpatialDatabase struct {
+	database.SpatialDatabase
+	database *Database
+	radius   float64
+	limit    int
+}
+
+// SpatialResults is the list of results for a point-in-polygon query.
+type SpatialResults struct {
+	spr.StandardPlacesResults `json:",omitempty"`
+	Places                    []spr.StandardPlacesResult `json:"places"`
+}
+
+// Results returns the list of results.
+func (r *SpatialResults) Results() []spr.StandardPlacesResult {
+	return r.Places
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := database.RegisterSpatialDatabase(ctx, "fsq-sqlite", NewSpatialDatabase)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewSpatialDatabase returns a new `SpatialDatabase` instance configured by 'uri' which is expected to take
+// the form of:
+//
+//	fsq-sqlite://{PATH_TO_DATABASE}?{PARAMETERS}
+//
+// Where {PARAMETERS} may be:
+// * `radius` – The distance, in metres, from a point within which places are returned by point-in-polygon queries. Default is `DEFAULT_SPATIAL_RADIUS`.
+// * `limit` – The maximum number of places returned by point-in-polygon queries. Default is `DEFAULT_LIMIT`.
+// * `read-only` – Whether to open the database read-only, in which case features can't be indexed or removed. Default is true if the database already exists and false otherwise.
+//
+// Databases which are not opened read-only are created if necessary and, since their full-text index is updated
+// whenever a place is, require that code be compiled with the "sqlite_fts5" build tag.
+func NewSpatialDatabase(ctx context.Context, uri string) (database.SpatialDatabase, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Account for relative paths like fsq-sqlite://4sq.db
+
+	path := u.Host + u.Path
+
+	if path == "" {
+		return nil, fmt.Errorf("Missing database path")
+	}
+
+	q := u.Query()
+
+	radius := DEFAULT_SPATIAL_RADIUS
+	limit := DEFAULT_LIMIT
+
+	if q.Has("radius") {
+
+		v, err := strconv.ParseFloat(q.Get("radius"), 64)
+
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("Invalid ?radius= parameter")
+		}
+
+		radius = v
+	}
+
+	if q.Has("limit") {
+
+		v, err := strconv.Atoi(q.Get("limit"))
+
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("Invalid ?limit= parameter")
+		}
+
+		limit = v
+	}
+
+	// Existing databases are opened read-only by default so that they can be queried by code compiled
+	// without FTS5 support, which is only needed for full-text searches and to update the database
+
+	_, err = os.Stat(path)
+	read_only := err == nil
+
+	if q.Has("read-only") {
+
+		v, err := strconv.ParseBool(q.Get("read-only"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?read-only= parameter, %w", err)
+		}
+
+		read_only = v
+	}
+
+	var db *Database
+
+	if read_only {
+		db, err = NewReadOnlyDatabase(ctx, path)
+	} else {
+		db, err = NewDatabase(ctx, path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &SpatialDatabase{
+		database: db,
+		radius:   radius,
+		limit:    limit,
+	}
+
+	return sp, nil
+}
+
+// IndexFeature adds (or updates) the place encoded in 'body', a GeoJSON Feature which is either a Who's On First
+// venue record (as returned by the `whosonfirst.AsFeature` method) or whose properties are a JSON-encoded
+// `places.Place` (as returned by the `places.Place.AsFeature` method). If the feature has a Point geometry its
+// coordinates are used for the place.
+func (sp *SpatialDatabase) IndexFeature(ctx context.Context, body []byte) error {
+
+	var pl *places.Place
+	var err error
+
+	props := gjson.GetBytes(body, "properties")
+
+	if props.Get(`wof:id`).Exists() || props.Get(`4sq:id`).Exists() {
+		pl, err = whosonfirst.PlaceFromFeature(body)
+	} else {
+		pl, err = placeFromFeature(body)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = sp.database.AddPlaces(ctx, pl)
+
+	if err != nil {
+		return fmt.Errorf("Failed to index place %s, %w", pl.Id, err)
+	}
+
+	return nil
+}
+
+// placeFromFeature returns the GeoJSON Feature in 'body', whose properties are a JSON-encoded `places.Place`, as
+// a `places.Place` instance.
+func placeFromFeature(body []byte) (*places.Place, error) {
+
+	f, err := geojson.UnmarshalFeature(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal feature, %w", err)
+	}
+
+	enc, err := json.Marshal(f.Properties)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal properties, %w", err)
+	}
+
+	pl := new(places.Place)
+
+	err = json.Unmarshal(enc, pl)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal place, %w", err)
+	}
+
+	if pl.Id == "" {
+
+		id, ok := f.ID.(string)
+
+		if !ok || id == "" {
+			return nil, fmt.Errorf("Feature is missing a Foursquare ID")
+		}
+
+		pl.Id = id
+	}
+
+	pt, ok := f.Geometry.(orb.Point)
+
+	if ok {
+		pl.Latitude = pt.Lat()
+		pl.Longitude = pt.Lon()
+	}
+
+	return pl, nil
+}
+
+// RemoveFeature removes the place whose Foursquare ID is 'id'.
+func (sp *SpatialDatabase) RemoveFeature(ctx context.Context, id string) error {
+	return sp.database.RemovePlace(ctx, id)
+}
+
+// PointInPolygon returns (up to the database's limit) the places within the database's radius of 'coord' which
+// match 'filters', ordered by distance. Existential filters are applied by the database query. Since inception
+// and cessation dates are EDTF strings they are compared as each page of results is read and pages are read
+// until there are enough matching places.
+func (sp *SpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...wof_spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResult, 0)
+
+	opts, ok := spatialQueryOptions(filters...)
+
+	if ok {
+
+		opts.Limit = sp.limit
+
+		for len(results) < sp.limit {
+
+			count := 0
+
+			for r, err := range sp.database.Nearby(ctx, coord.Lat(), coord.Lon(), sp.radius, opts) {
+
+				if err != nil {
+					return nil, err
+				}
+
+				count += 1
+				opts.Cursor = r.Cursor
+
+				s := whosonfirst.NewSPR(r.Place)
+
+				if !matchesDates(s, filters...) {
+					continue
+				}
+
+				results = append(results, s)
+
+				if len(results) == sp.limit {
+					break
+				}
+			}
+
+			if count < opts.Limit {
+				break
+			}
+		}
+	}
+
+	spr_results := &SpatialResults{
+		Places: results,
+	}
+
+	return spr_results, nil
+}
+
+// PointInPolygonWithChannels sends the results of `PointInPolygon` to 'rsp_ch' and any error to 'err_ch'
+// before signaling 'done_ch'.
+func (sp *SpatialDatabase) PointInPolygonWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...wof_spatial.Filter) {
+
+	defer func() {
+		done_ch <- true
+	}()
+
+	results, err := sp.PointInPolygon(ctx, coord, filters...)
+
+	if err != nil {
+		err_ch <- err
+		return
+	}
+
+	for _, r := range results.Results() {
+		rsp_ch <- r
+	}
+}
+
+// PointInPolygonCandidates returns the places within the database's radius of 'coord', ordered by distance.
+// Filters are not applied to candidates.
+func (sp *SpatialDatabase) PointInPolygonCandidates(ctx context.Context, coord *orb.Point, filters ...wof_spatial.Filter) ([]*wof_spatial.PointInPolygonCandidate, error) {
+
+	candidates := make([]*wof_spatial.PointInPolygonCandidate, 0)
+
+	opts := &QueryOptions{
+		Limit: sp.limit,
+	}
+
+	for r, err := range sp.database.Nearby(ctx, coord.Lat(), coord.Lon(), sp.radius, opts) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		pt := orb.Point{r.Place.Longitude, r.Place.Latitude}
+
+		c := &wof_spatial.PointInPolygonCandidate{
+			Id:        r.Place.Id,
+			FeatureId: r.Place.Id,
+			Bounds:    pt.Bound(),
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	return candidates, nil
+}
+
+// PointInPolygonCandidatesWithChannels sends the results of `PointInPolygonCandidates` to 'rsp_ch' and any
+// error to 'err_ch' before signaling 'done_ch'.
+func (sp *SpatialDatabase) PointInPolygonCandidatesWithChannels(ctx context.Context, rsp_ch chan *wof_spatial.PointInPolygonCandidate, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...wof_spatial.Filter) {
+
+	defer func() {
+		done_ch <- true
+	}()
+
+	candidates, err := sp.PointInPolygonCandidates(ctx, coord, filters...)
+
+	if err != nil {
+		err_ch <- err
+		return
+	}
+
+	for _, c := range candidates {
+		rsp_ch <- c
+	}
+}
+
+// Disconnect closes the underlying database.
+func (sp *SpatialDatabase) Disconnect(ctx context.Context) error {
+	return sp.database.Close()
+}
+
+// Read returns the place whose Foursquare ID is 'path' as a Who's On First venue record (see `whosonfirst.AsFeature`).
+// For compatibility with other readers 'path' may also be a filename like "{ID}.geojson".
+func (sp *SpatialDatabase) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	id := strings.TrimSuffix(filepath.Base(path), ".geojson")
+
+	pl, err := sp.database.GetPlace(ctx, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read place %s, %w", id, err)
+	}
+
+	f, err := whosonfirst.AsFeature(pl, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive feature for place %s, %w", id, err)
+	}
+
+	body, err := f.MarshalJSON()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal feature for place %s, %w", id, err)
+	}
+
+	return ioutil.NewReadSeekCloser(bytes.NewReader(body))
+}
+
+// ReaderURI returns the value of 'path'.
+func (sp *SpatialDatabase) ReaderURI(ctx context.Context, path string) string {
+	return path
+}
+
+// Write indexes the feature read from 'r'. See `IndexFeature` for details.
+func (sp *SpatialDatabase) Write(ctx context.Context, path string, r io.ReadSeeker) (int64, error) {
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	err = sp.IndexFeature(ctx, body)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(body)), nil
+}
+
+// WriterURI returns the value of 'path'.
+func (sp *SpatialDatabase) WriterURI(ctx context.Context, path string) string {
+	return path
+}
+
+// Flush is a no-op since places are written as they are indexed.
+func (sp *SpatialDatabase) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the underlying database.
+func (sp *SpatialDatabase) Close(ctx context.Context) error {
+	return sp.database.Close()
+}
+
+// SetLogger is a no-op.
+func (sp *SpatialDatabase) SetLogger(ctx context.Context, logger *log.Logger) error {
+	return nil
+}
+
+// spatialQueryOptions returns the `QueryOptions` for the parts of 'filters' which can be applied by a database
+// query. Places are always treated as venues with a single, default geometry which are never deprecated,
+// superseded or superseding (since their paths can't be parsed as Who's On First URIs) and whose existential
+// flags are derived from their closing date (see `whosonfirst.NewSPR`). It returns false if no place can
+// match 'filters'.
+func spatialQueryOptions(filters ...wof_spatial.Filter) (*QueryOptions, bool) {
+
+	opts := &QueryOptions{
+		IsCurrent: make([]int64, 0, 2),
+	}
+
+	if len(filters) == 0 {
+		return opts, true
+	}
+
+	pt_fl, err := placetypes.NewPlacetypeFlag(whosonfirst.PLACETYPE)
+
+	if err != nil {
+		return nil, false
+	}
+
+	alt_fl, err := geometry.NewAlternateGeometryFlag("0.geojson")
+
+	if err != nil {
+		return nil, false
+	}
+
+	false_fl, err := existential.NewKnownUnknownFlag(0)
+
+	if err != nil {
+		return nil, false
+	}
+
+	for _, f := range filters {
+
+		switch {
+		case !f.HasPlacetypes(pt_fl):
+			return nil, false
+		case !f.IsDeprecated(false_fl):
+			return nil, false
+		case !f.IsSuperseded(false_fl):
+			return nil, false
+		case !f.IsSuperseding(false_fl):
+			return nil, false
+		case !f.IsAlternateGeometry(alt_fl):
+			return nil, false
+		case !f.HasAlternateGeometry(alt_fl):
+			return nil, false
+		}
+	}
+
+	// Places are either current (and not ceased) or closed (and ceased)
+
+	for _, is_current := range []int64{0, 1} {
+
+		current_fl, err := existential.NewKnownUnknownFlag(is_current)
+
+		if err != nil {
+			return nil, false
+		}
+
+		ceased_fl, err := existential.NewKnownUnknownFlag(1 - is_current)
+
+		if err != nil {
+			return nil, false
+		}
+
+		matches := true
+
+		for _, f := range filters {
+
+			if !f.IsCurrent(current_fl) || !f.IsCeased(ceased_fl) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			opts.IsCurrent = append(opts.IsCurrent, is_current)
+		}
+	}
+
+	if len(opts.IsCurrent) == 0 {
+		return nil, false
+	}
+
+	return opts, true
+}
+
+// matchesDates reports whether the inception and cessation dates of 's' match all of 'filters'.
+func matchesDates(s spr.StandardPlacesResult, filters ...wof_spatial.Filter) bool {
+
+	if len(filters) == 0 {
+		return true
+	}
+
+	inception_fl, err := date.NewEDTFDateFlagWithDate(s.Inception())
+
+	if err != nil {
+		return false
+	}
+
+	cessation_fl, err := date.NewEDTFDateFlagWithDate(s.Cessation())
+
+	if err != nil {
+		return false
+	}
+
+	for _, f := range filters {
+
+		if !f.MatchesInception(inception_fl) || !f.MatchesCessation(cessation_fl) {
+			return false
+		}
+	}
+
+	return true
+}
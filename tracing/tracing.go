@@ -0,0 +1,188 @@
+// Package tracing provides methods for configuring an OpenTelemetry tracer provider used to trace the processing
+// of Foursquare places. Packages in this module create spans using the global tracer provider which does nothing
+// until one has been configured.
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TracingFlags defines command line flags for configuring an OpenTelemetry tracer provider.
+type TracingFlags struct {
+	exporter_uri string
+	sample_rate  float64
+	provider     *sdktrace.TracerProvider
+	closer       io.Closer
+}
+
+// AppendTracingFlags appends flags for configuring an OpenTelemetry tracer provider to 'fs'.
+func AppendTracingFlags(fs *flag.FlagSet) *TracingFlags {
+
+	tf := &TracingFlags{}
+
+	fs.StringVar(&tf.exporter_uri, "tracing-exporter-uri", "", "An optional URI for exporting OpenTelemetry traces. Valid schemes are: stdout:// which writes traces to STDERR (or to a file if a path is included, for example stdout:///tmp/traces.json) and otlp://{HOST}:{PORT} which sends traces to an OTLP HTTP collector. Append ?insecure=true to OTLP URIs to disable TLS. If empty tracing is disabled.")
+	fs.Float64Var(&tf.sample_rate, "tracing-sample-rate", 1.0, "The ratio (0.0 to 1.0) of places to trace.")
+
+	return tf
+}
+
+// Enabled returns true if the -tracing-exporter-uri flag has been set.
+func (tf *TracingFlags) Enabled() bool {
+	return tf.exporter_uri != ""
+}
+
+// SetTracerProvider creates a new tracer provider for 'service_name', as configured by the flags in 'tf', and
+// registers it as the global tracer provider. If tracing is not enabled this method does nothing.
+func (tf *TracingFlags) SetTracerProvider(ctx context.Context, service_name string) error {
+
+	if !tf.Enabled() {
+		return nil
+	}
+
+	if tf.sample_rate < 0.0 || tf.sample_rate > 1.0 {
+		return fmt.Errorf("Invalid sample rate, must be between 0.0 and 1.0")
+	}
+
+	exporter, closer, err := NewExporter(ctx, tf.exporter_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create exporter, %w", err)
+	}
+
+	r := resource.NewSchemaless(
+		attribute.String("service.name", service_name),
+	)
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tf.sample_rate))
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(r),
+		sdktrace.WithSampler(sampler),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	tf.provider = tp
+	tf.closer = closer
+
+	return nil
+}
+
+// Shutdown flushes any pending spans and shuts down the tracer provider created by `SetTracerProvider`.
+func (tf *TracingFlags) Shutdown(ctx context.Context) error {
+
+	if tf.provider == nil {
+		return nil
+	}
+
+	err := tf.provider.Shutdown(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to shut down tracer provider, %w", err)
+	}
+
+	if tf.closer != nil {
+
+		err := tf.closer.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to close exporter, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// NewExporter returns a new `sdktrace.SpanExporter` instance configured by 'uri' and an optional `io.Closer`
+// instance which should be closed after the exporter has been shut down.
+func NewExporter(ctx context.Context, uri string) (sdktrace.SpanExporter, io.Closer, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	switch u.Scheme {
+	case "stdout":
+
+		// Write to STDERR by default since some tools write their output to STDOUT
+
+		var wr io.Writer = os.Stderr
+		var closer io.Closer
+
+		if u.Path != "" {
+
+			fh, err := os.Create(u.Path)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to create %s, %w", u.Path, err)
+			}
+
+			wr = fh
+			closer = fh
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(wr))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return exporter, closer, nil
+
+	case "otlp":
+
+		opts := make([]otlptracehttp.Option, 0)
+
+		// If no host is defined then the exporter defaults (and OTEL_EXPORTER_OTLP_* environment variables) are used
+
+		if u.Host != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(u.Host))
+		}
+
+		if u.Path != "" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+
+		if q.Has("insecure") {
+
+			insecure, err := strconv.ParseBool(q.Get("insecure"))
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to parse ?insecure= parameter, %w", err)
+			}
+
+			if insecure {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+		}
+
+		exporter, err := otlptracehttp.New(ctx, opts...)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return exporter, nil, nil
+
+	default:
+		return nil, nil, fmt.Errorf("Unsupported scheme '%s'", u.Scheme)
+	}
+}
@@ -0,0 +1,263 @@
+// Package whosonfirst provides methods for representing Foursquare places as Who's On First (WOF) venue records.
+package whosonfirst
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/sfomuseum/go-edtf"
+	"github.com/sfomuseum/go-edtf/parser"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-foursquare-places"
+)
+
+// PLACETYPE is the Who's On First placetype for Foursquare places.
+const PLACETYPE string = "venue"
+
+// SOURCE is the prefix of the Who's On First source for Foursquare data.
+const SOURCE string = "4sq"
+
+// CONCORDANCE is the key for Foursquare IDs in "wof:concordances" dictionaries.
+const CONCORDANCE string = "4sq:id"
+
+// FeatureOptions defines configuration options for the `AsFeature` method.
+type FeatureOptions struct {
+	// Id is the Who's On First ID to assign to the place. If 0 the value of `Id` for the place's Foursquare ID
+	// will be used.
+	Id int64
+	// Repo is the name of the repository to assign to the place. If empty the value of `Repo` for the
+	// place's country will be used.
+	Repo string
+}
+
+// Id returns a stable, synthetic Who's On First ID for the place with Foursquare ID 'fsq_id'. IDs are derived
+// from a 53-bit hash of 'fsq_id' (so that they can be represented exactly by JavaScript numbers) which
+// makes them far larger than any ID minted by Who's On First but collisions between Foursquare IDs are still
+// possible, if unlikely. They are meant for tools which need a numeric ID for places which have not been
+// assigned one yet.
+func Id(fsq_id string) int64 {
+
+	h := fnv.New64a()
+	h.Write([]byte(fsq_id))
+
+	return int64(h.Sum64() & (1<<53 - 1))
+}
+
+// Repo returns the name of the Who's On First venue repository for places in 'country'.
+func Repo(country string) string {
+
+	if country == "" {
+		country = "xx"
+	}
+
+	return fmt.Sprintf("whosonfirst-data-venue-%s", strings.ToLower(country))
+}
+
+// Inception returns the EDTF inception date of 'pl' which is the date it was created in the Foursquare
+// dataset, if that is a valid EDTF date, or `edtf.UNKNOWN_2012`.
+func Inception(pl *places.Place) string {
+	return edtfDate(pl.DateCreated)
+}
+
+// Cessation returns the EDTF cessation date of 'pl' which is the date it was closed, if that is a valid EDTF
+// date, or `edtf.UNKNOWN_2012`.
+func Cessation(pl *places.Place) string {
+	return edtfDate(pl.DateClosed)
+}
+
+// IsCurrent returns the Who's On First existential flag for whether 'pl' is current: 0 if it has been
+// closed and 1 otherwise.
+func IsCurrent(pl *places.Place) int64 {
+
+	if pl.DateClosed != "" {
+		return 0
+	}
+
+	return 1
+}
+
+// LastModified returns the Unix timestamp of the date 'pl' was last refreshed, or 0 if that is unknown.
+func LastModified(pl *places.Place) int64 {
+
+	t, err := time.Parse(time.DateOnly, pl.DateRefreshed)
+
+	if err != nil {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// AsFeature returns 'pl' as a Who's On First venue record with a Point geometry. Properties which are
+// specific to Foursquare are prefixed with "4sq:".
+func AsFeature(pl *places.Place, opts *FeatureOptions) (*geojson.Feature, error) {
+
+	if pl.Id == "" {
+		return nil, fmt.Errorf("Place is missing a Foursquare ID")
+	}
+
+	id := Id(pl.Id)
+	repo := Repo(pl.Country)
+
+	if opts != nil && opts.Id != 0 {
+		id = opts.Id
+	}
+
+	if opts != nil && opts.Repo != "" {
+		repo = opts.Repo
+	}
+
+	category_ids := make([]string, 0, len(pl.Categories))
+	category_labels := make([]string, 0, len(pl.Categories))
+
+	for _, c := range pl.Categories {
+		category_ids = append(category_ids, c.Id)
+		category_labels = append(category_labels, strings.Join(c.Labels, " > "))
+	}
+
+	props := map[string]any{
+		"wof:id":           id,
+		"wof:name":         pl.Name,
+		"wof:placetype":    PLACETYPE,
+		"wof:country":      pl.Country,
+		"wof:parent_id":    -1,
+		"wof:hierarchy":    []map[string]int64{},
+		"wof:belongsto":    []int64{},
+		"wof:repo":         repo,
+		"wof:lastmodified": LastModified(pl),
+		"wof:concordances": map[string]string{
+			CONCORDANCE: pl.Id,
+		},
+		"wof:superseded_by":   []int64{},
+		"wof:supersedes":      []int64{},
+		"edtf:inception":      Inception(pl),
+		"edtf:cessation":      Cessation(pl),
+		"mz:is_current":       IsCurrent(pl),
+		"src:geom":            SOURCE,
+		"geom:latitude":       pl.Latitude,
+		"geom:longitude":      pl.Longitude,
+		"lbl:latitude":        pl.Latitude,
+		"lbl:longitude":       pl.Longitude,
+		"addr:full":           pl.Address,
+		"addr:postcode":       pl.PostCode,
+		"addr:phone":          pl.Telephone,
+		"4sq:id":              pl.Id,
+		"4sq:locality":        pl.Locality,
+		"4sq:post_town":       pl.PostTown,
+		"4sq:region":          pl.Region,
+		"4sq:admin_region":    pl.AdminRegion,
+		"4sq:po_box":          pl.PostBox,
+		"4sq:website":         pl.Website,
+		"4sq:email":           pl.Email,
+		"4sq:facebook_id":     pl.FacebookId,
+		"4sq:instagram":       pl.Instagram,
+		"4sq:twitter":         pl.Twitter,
+		"4sq:date_created":    pl.DateCreated,
+		"4sq:date_refreshed":  pl.DateRefreshed,
+		"4sq:date_closed":     pl.DateClosed,
+		"4sq:category_ids":    category_ids,
+		"4sq:category_labels": category_labels,
+	}
+
+	pt := orb.Point{pl.Longitude, pl.Latitude}
+
+	f := geojson.NewFeature(pt)
+	f.ID = id
+	f.Properties = props
+
+	return f, nil
+}
+
+// PlaceFromFeature returns the Who's On First venue record in 'body', as returned by the `AsFeature` method, as a
+// `places.Place` instance. The place's Foursquare ID is read from the "4sq:id" property or, failing that, the
+// record's concordances. Its coordinates are read from the record's Point geometry or, failing that, its
+// "geom:latitude" and "geom:longitude" properties.
+func PlaceFromFeature(body []byte) (*places.Place, error) {
+
+	props := gjson.GetBytes(body, "properties")
+
+	fsq_id := props.Get(`4sq:id`).String()
+
+	if fsq_id == "" {
+		fsq_id = props.Get(`wof:concordances.4sq:id`).String()
+	}
+
+	if fsq_id == "" {
+		return nil, fmt.Errorf("Feature is missing a Foursquare ID")
+	}
+
+	pl := &places.Place{
+		Id:            fsq_id,
+		Name:          props.Get(`wof:name`).String(),
+		Country:       props.Get(`wof:country`).String(),
+		Address:       props.Get(`addr:full`).String(),
+		PostCode:      props.Get(`addr:postcode`).String(),
+		Telephone:     props.Get(`addr:phone`).String(),
+		Locality:      props.Get(`4sq:locality`).String(),
+		PostTown:      props.Get(`4sq:post_town`).String(),
+		Region:        props.Get(`4sq:region`).String(),
+		AdminRegion:   props.Get(`4sq:admin_region`).String(),
+		PostBox:       props.Get(`4sq:po_box`).String(),
+		Website:       props.Get(`4sq:website`).String(),
+		Email:         props.Get(`4sq:email`).String(),
+		FacebookId:    props.Get(`4sq:facebook_id`).String(),
+		Instagram:     props.Get(`4sq:instagram`).String(),
+		Twitter:       props.Get(`4sq:twitter`).String(),
+		DateCreated:   props.Get(`4sq:date_created`).String(),
+		DateRefreshed: props.Get(`4sq:date_refreshed`).String(),
+		DateClosed:    props.Get(`4sq:date_closed`).String(),
+		Latitude:      props.Get(`geom:latitude`).Float(),
+		Longitude:     props.Get(`geom:longitude`).Float(),
+		Categories:    make([]places.Category, 0),
+	}
+
+	geom := gjson.GetBytes(body, "geometry")
+
+	if geom.Get("type").String() == "Point" {
+
+		coords := geom.Get("coordinates").Array()
+
+		if len(coords) >= 2 {
+			pl.Longitude = coords[0].Float()
+			pl.Latitude = coords[1].Float()
+		}
+	}
+
+	category_ids := props.Get(`4sq:category_ids`).Array()
+	category_labels := props.Get(`4sq:category_labels`).Array()
+
+	for i, c := range category_ids {
+
+		category := places.Category{
+			Id:     c.String(),
+			Labels: make([]string, 0),
+		}
+
+		if i < len(category_labels) && category_labels[i].String() != "" {
+			category.Labels = strings.Split(category_labels[i].String(), " > ")
+		}
+
+		pl.Categories = append(pl.Categories, category)
+	}
+
+	return pl, nil
+}
+
+func edtfDate(str string) string {
+
+	if str == "" {
+		return edtf.UNKNOWN_2012
+	}
+
+	_, err := parser.ParseString(str)
+
+	if err != nil {
+		return edtf.UNKNOWN_2012
+	}
+
+	return str
+}
@@ -0,0 +1,229 @@
+package whosonfirst
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"github.com/whosonfirst/go-foursquare-places/emitter"
+	"github.com/whosonfirst/go-foursquare-places/ledger"
+	"github.com/whosonfirst/go-ioutil"
+	iterate_emitter "github.com/whosonfirst/go-whosonfirst-iterate/v2/emitter"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/filters"
+)
+
+// FoursquareEmitter implements the `whosonfirst/go-whosonfirst-iterate/v2/emitter.Emitter` interface for
+// crawling the places yielded by a `emitter.Emitter` instance as Who's On First venue records.
+type FoursquareEmitter struct {
+	iterate_emitter.Emitter
+	emitter_uri string
+	repo        string
+	filters     filters.Filters
+	ledger_uri  string
+	assign      bool
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := iterate_emitter.RegisterEmitter(ctx, "foursquare", NewFoursquareEmitter)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewFoursquareEmitter returns a new `FoursquareEmitter` instance configured by 'uri' in the form of:
+//
+//	foursquare://?{PARAMETERS}
+//
+// Where {PARAMETERS} may be:
+// * `?emitter-uri=` A registered whosonfirst/go-foursquare-places/emitter.Emitter URI. If present the URIs passed to `WalkURI` are ignored.
+// * `?repo=` The name of the repository to assign to every record. If empty the value of `Repo` for each place's country is used.
+// * `?ledger-uri=` A registered whosonfirst/go-foursquare-places/ledger.Ledger URI used to look up the Who's On First ID of each place. Places which are not in the ledger, or which have been merged or retired, are skipped. If empty the value of `Id` for each place's Foursquare ID is used.
+// * `?ledger-assign=` A boolean flag indicating that new Who's On First IDs should be allocated (and recorded) for places which are not in the ledger rather than skipping them. Default is false.
+// * `?include=` Zero or more `aaronland/go-json-query` query strings containing rules that must match for a document to be considered for further processing.
+// * `?exclude=` Zero or more `aaronland/go-json-query` query strings containing rules that if matched will prevent a document from being considered for further processing.
+// * `?include_mode=` A valid `aaronland/go-json-query` query mode string for testing inclusion rules.
+// * `?exclude_mode=` A valid `aaronland/go-json-query` query mode string for testing exclusion rules.
+func NewFoursquareEmitter(ctx context.Context, uri string) (iterate_emitter.Emitter, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	f, err := filters.NewQueryFiltersFromURI(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create filters from query, %w", err)
+	}
+
+	e := &FoursquareEmitter{
+		emitter_uri: q.Get("emitter-uri"),
+		repo:        q.Get("repo"),
+		filters:     f,
+		ledger_uri:  q.Get("ledger-uri"),
+	}
+
+	if q.Has("ledger-assign") {
+
+		v, err := strconv.ParseBool(q.Get("ledger-assign"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?ledger-assign= parameter, %w", err)
+		}
+
+		e.assign = v
+	}
+
+	if e.assign && e.ledger_uri == "" {
+		return nil, fmt.Errorf("?ledger-assign= parameter requires a ?ledger-uri= parameter")
+	}
+
+	return e, nil
+}
+
+// WalkURI crawls the places yielded by an `emitter.Emitter` instance, invoking 'index_cb' for each place
+// (not excluded by any filters) encoded as a Who's On First venue record. If the `FoursquareEmitter` was
+// not created with an emitter URI then 'uri' is used: it may be an emitter URI or the path to a
+// (bzip2-compressed) Foursquare places CSV file.
+func (e *FoursquareEmitter) WalkURI(ctx context.Context, index_cb iterate_emitter.EmitterCallbackFunc, uri string) error {
+
+	emitter_uri := e.emitter_uri
+
+	if emitter_uri == "" {
+
+		u, err := url.Parse(uri)
+
+		switch {
+		case err == nil && u.Scheme != "":
+			emitter_uri = uri
+		default:
+
+			abs_path, err := filepath.Abs(uri)
+
+			if err != nil {
+				return fmt.Errorf("Failed to derive absolute path for '%s', %w", uri, err)
+			}
+
+			emitter_uri = fmt.Sprintf("csv://%s", abs_path)
+		}
+	}
+
+	pl_emitter, err := emitter.NewEmitter(ctx, emitter_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create emitter for '%s', %w", emitter_uri, err)
+	}
+
+	defer pl_emitter.Close()
+
+	// Since emitters aren't closed the ledger is only kept open for the duration of the crawl
+
+	var l ledger.Ledger
+
+	if e.ledger_uri != "" {
+
+		l, err = ledger.NewLedger(ctx, e.ledger_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create ledger, %w", err)
+		}
+
+		defer l.Close()
+	}
+
+	opts := &FeatureOptions{
+		Repo: e.repo,
+	}
+
+	for pl, err := range pl_emitter.Emit(ctx) {
+
+		if err != nil {
+			return fmt.Errorf("Failed to emit place from '%s', %w", emitter_uri, err)
+		}
+
+		path := fmt.Sprintf("%s#%s", emitter_uri, pl.Id)
+
+		if l != nil {
+
+			var entry *ledger.Entry
+
+			if e.assign {
+				entry, err = l.Assign(ctx, pl.Id)
+			} else {
+				entry, err = l.Lookup(ctx, pl.Id)
+			}
+
+			if errors.Is(err, ledger.ErrNotFound) {
+				slog.Debug("Skip place which is not in ledger", "path", path)
+				continue
+			}
+
+			if err != nil {
+				return fmt.Errorf("Failed to derive ID for '%s', %w", path, err)
+			}
+
+			if entry.Status != ledger.ACTIVE {
+				slog.Debug("Skip place which is no longer active in ledger", "path", path, "status", entry.Status)
+				continue
+			}
+
+			opts.Id = entry.WOFId
+		}
+
+		f, err := AsFeature(pl, opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive feature for '%s', %w", path, err)
+		}
+
+		body, err := f.MarshalJSON()
+
+		if err != nil {
+			return fmt.Errorf("Failed to marshal feature for '%s', %w", path, err)
+		}
+
+		fh, err := ioutil.NewReadSeekCloser(bytes.NewReader(body))
+
+		if err != nil {
+			return fmt.Errorf("Failed to create new ReadSeekCloser for '%s', %w", path, err)
+		}
+
+		if e.filters != nil {
+
+			ok, err := e.filters.Apply(ctx, fh)
+
+			if err != nil {
+				return fmt.Errorf("Failed to apply filters for '%s', %w", path, err)
+			}
+
+			if !ok {
+				continue
+			}
+
+			_, err = fh.Seek(0, 0)
+
+			if err != nil {
+				return fmt.Errorf("Failed to reset file handle for '%s', %w", path, err)
+			}
+		}
+
+		err = index_cb(ctx, path, fh)
+
+		if err != nil {
+			return fmt.Errorf("Index callback failed for '%s', %w", path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package whosonfirst
+
+import (
+	"fmt"
+
+	"github.com/sfomuseum/go-edtf"
+	"github.com/sfomuseum/go-edtf/parser"
+	"github.com/whosonfirst/go-foursquare-places"
+	"github.com/whosonfirst/go-whosonfirst-flags"
+	"github.com/whosonfirst/go-whosonfirst-flags/existential"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// FoursquareStandardPlacesResult implements the `whosonfirst/go-whosonfirst-spr/v2.StandardPlacesResult`
+// interface for Foursquare places. Its ID is the place's Foursquare ID and its path is "{ID}.geojson" which
+// can be read by the `fsq://` reader and the `fsq-sqlite://` spatial database.
+type FoursquareStandardPlacesResult struct {
+	spr.StandardPlacesResult `json:",omitempty"`
+	WOFId                    string  `json:"wof:id"`
+	WOFParentId              string  `json:"wof:parent_id"`
+	WOFName                  string  `json:"wof:name"`
+	WOFCountry               string  `json:"wof:country"`
+	WOFPlacetype             string  `json:"wof:placetype"`
+	MZLatitude               float64 `json:"mz:latitude"`
+	MZLongitude              float64 `json:"mz:longitude"`
+	MZMinLatitude            float64 `json:"mz:min_latitude"`
+	MZMinLongitude           float64 `json:"mz:min_longitude"`
+	MZMaxLatitude            float64 `json:"mz:max_latitude"`
+	MZMaxLongitude           float64 `json:"mz:max_longitude"`
+	MZIsCurrent              int64   `json:"mz:is_current"`
+	MZIsDeprecated           int64   `json:"mz:is_deprecated"`
+	MZIsCeased               int64   `json:"mz:is_ceased"`
+	MZIsSuperseded           int64   `json:"mz:is_superseded"`
+	MZIsSuperseding          int64   `json:"mz:is_superseding"`
+	EDTFInception            string  `json:"edtf:inception"`
+	EDTFCessation            string  `json:"edtf:cessation"`
+	WOFSupersedes            []int64 `json:"wof:supersedes"`
+	WOFSupersededBy          []int64 `json:"wof:superseded_by"`
+	WOFBelongsTo             []int64 `json:"wof:belongsto"`
+	WOFPath                  string  `json:"wof:path"`
+	WOFRepo                  string  `json:"wof:repo"`
+	WOFLastModified          int64   `json:"wof:lastmodified"`
+}
+
+// NewSPR returns a new `FoursquareStandardPlacesResult` instance for 'pl'. Places are always current unless
+// they have a closing date, in which case they are ceased, and are never deprecated, superseded or superseding.
+func NewSPR(pl *places.Place) *FoursquareStandardPlacesResult {
+
+	is_current := IsCurrent(pl)
+	is_ceased := int64(0)
+
+	if is_current == 0 {
+		is_ceased = 1
+	}
+
+	s := &FoursquareStandardPlacesResult{
+		WOFId:           pl.Id,
+		WOFParentId:     "-1",
+		WOFName:         pl.Name,
+		WOFCountry:      pl.Country,
+		WOFPlacetype:    PLACETYPE,
+		MZLatitude:      pl.Latitude,
+		MZLongitude:     pl.Longitude,
+		MZMinLatitude:   pl.Latitude,
+		MZMinLongitude:  pl.Longitude,
+		MZMaxLatitude:   pl.Latitude,
+		MZMaxLongitude:  pl.Longitude,
+		MZIsCurrent:     is_current,
+		MZIsDeprecated:  0,
+		MZIsCeased:      is_ceased,
+		MZIsSuperseded:  0,
+		MZIsSuperseding: 0,
+		EDTFInception:   Inception(pl),
+		EDTFCessation:   Cessation(pl),
+		WOFSupersedes:   []int64{},
+		WOFSupersededBy: []int64{},
+		WOFBelongsTo:    []int64{},
+		WOFPath:         fmt.Sprintf("%s.geojson", pl.Id),
+		WOFRepo:         Repo(pl.Country),
+		WOFLastModified: LastModified(pl),
+	}
+
+	return s
+}
+
+func (s *FoursquareStandardPlacesResult) Id() string {
+	return s.WOFId
+}
+
+func (s *FoursquareStandardPlacesResult) ParentId() string {
+	return s.WOFParentId
+}
+
+func (s *FoursquareStandardPlacesResult) Name() string {
+	return s.WOFName
+}
+
+func (s *FoursquareStandardPlacesResult) Placetype() string {
+	return s.WOFPlacetype
+}
+
+func (s *FoursquareStandardPlacesResult) Country() string {
+	return s.WOFCountry
+}
+
+func (s *FoursquareStandardPlacesResult) Repo() string {
+	return s.WOFRepo
+}
+
+func (s *FoursquareStandardPlacesResult) Path() string {
+	return s.WOFPath
+}
+
+// URI returns an empty string since Foursquare places are not published at a canonical URI.
+func (s *FoursquareStandardPlacesResult) URI() string {
+	return ""
+}
+
+func (s *FoursquareStandardPlacesResult) Inception() *edtf.EDTFDate {
+	return edtfDateWithString(s.EDTFInception)
+}
+
+func (s *FoursquareStandardPlacesResult) Cessation() *edtf.EDTFDate {
+	return edtfDateWithString(s.EDTFCessation)
+}
+
+func (s *FoursquareStandardPlacesResult) Latitude() float64 {
+	return s.MZLatitude
+}
+
+func (s *FoursquareStandardPlacesResult) Longitude() float64 {
+	return s.MZLongitude
+}
+
+func (s *FoursquareStandardPlacesResult) MinLatitude() float64 {
+	return s.MZMinLatitude
+}
+
+func (s *FoursquareStandardPlacesResult) MinLongitude() float64 {
+	return s.MZMinLongitude
+}
+
+func (s *FoursquareStandardPlacesResult) MaxLatitude() float64 {
+	return s.MZMaxLatitude
+}
+
+func (s *FoursquareStandardPlacesResult) MaxLongitude() float64 {
+	return s.MZMaxLongitude
+}
+
+func (s *FoursquareStandardPlacesResult) IsCurrent() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsCurrent)
+}
+
+func (s *FoursquareStandardPlacesResult) IsCeased() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsCeased)
+}
+
+func (s *FoursquareStandardPlacesResult) IsDeprecated() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsDeprecated)
+}
+
+func (s *FoursquareStandardPlacesResult) IsSuperseded() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsSuperseded)
+}
+
+func (s *FoursquareStandardPlacesResult) IsSuperseding() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsSuperseding)
+}
+
+func (s *FoursquareStandardPlacesResult) SupersededBy() []int64 {
+	return s.WOFSupersededBy
+}
+
+func (s *FoursquareStandardPlacesResult) Supersedes() []int64 {
+	return s.WOFSupersedes
+}
+
+func (s *FoursquareStandardPlacesResult) BelongsTo() []int64 {
+	return s.WOFBelongsTo
+}
+
+func (s *FoursquareStandardPlacesResult) LastModified() int64 {
+	return s.WOFLastModified
+}
+
+func edtfDateWithString(str string) *edtf.EDTFDate {
+
+	d, err := parser.ParseString(str)
+
+	if err != nil {
+		return nil
+	}
+
+	return d
+}
+
+func existentialFlag(i int64) flags.ExistentialFlag {
+	fl, _ := existential.NewKnownUnknownFlag(i)
+	return fl
+}